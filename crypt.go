@@ -0,0 +1,127 @@
+package gofpdf
+
+import (
+	"crypto/md5"
+	"crypto/rc4"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// Permissions granted by SetProtection, to be combined with a bitwise or.
+const (
+	PermissionPrint    = 4
+	PermissionModify   = 8
+	PermissionCopy     = 16
+	PermissionAnnotate = 32
+)
+
+// pdfPasswordPadding is the padding string of the standard security handler.
+var pdfPasswordPadding = []byte{
+	0x28, 0xBF, 0x4E, 0x5E, 0x4E, 0x75, 0x8A, 0x41,
+	0x64, 0x00, 0x4E, 0x56, 0xFF, 0xFA, 0x01, 0x08,
+	0x2E, 0x2E, 0x00, 0xB6, 0xD0, 0x68, 0x3E, 0x80,
+	0x2F, 0x0C, 0xA9, 0xFE, 0x64, 0x53, 0x69, 0x7A,
+}
+
+// pdfProtection holds the state of the standard security handler, revision 3
+// with 128-bit RC4 keys.
+type pdfProtection struct {
+	userPass  string
+	ownerPass string
+	p         int32
+	id        []byte
+	key       []byte
+	o, u      []byte
+}
+
+// SetProtection encrypts the document with 128-bit RC4. permissions is a
+// combination of PermissionPrint, PermissionModify, PermissionCopy and
+// PermissionAnnotate granted to users opening the document with userPass,
+// which may be empty to let anyone open it. ownerPass gives full access; when
+// empty, a password derived from the current time is used.
+func (p *Fpdf) SetProtection(permissions int, userPass, ownerPass string) {
+	all := PermissionPrint | PermissionModify | PermissionCopy | PermissionAnnotate
+	if permissions&^all != 0 {
+		p.panicError("invalid permissions: " + strconv.Itoa(permissions))
+	}
+	if ownerPass == "" {
+		ownerPass = time.Now().String()
+	}
+	flags := uint32(0xFFFFF0C0) | uint32(permissions)
+	if permissions&PermissionPrint != 0 {
+		// High quality printing.
+		flags |= 0x800
+	}
+	p.protection = &pdfProtection{userPass: userPass, ownerPass: ownerPass, p: int32(flags)}
+	p.requireVersion("1.4")
+}
+
+// init computes the document keys for the given file identifier.
+func (pr *pdfProtection) init(id []byte) {
+	pr.id = id
+	// Algorithm 3: the owner password entry.
+	h := md5.Sum(padPassword(pr.ownerPass))
+	for i := 0; i < 50; i++ {
+		h = md5.Sum(h[:])
+	}
+	pr.o = rc4Rounds(h[:16], padPassword(pr.userPass))
+	// Algorithm 2: the encryption key.
+	b := append(padPassword(pr.userPass), pr.o...)
+	b = append(b, byte(pr.p), byte(pr.p>>8), byte(pr.p>>16), byte(pr.p>>24))
+	b = append(b, id...)
+	h = md5.Sum(b)
+	for i := 0; i < 50; i++ {
+		h = md5.Sum(h[:16])
+	}
+	pr.key = append([]byte{}, h[:16]...)
+	// Algorithm 5: the user password entry.
+	h = md5.Sum(append(append([]byte{}, pdfPasswordPadding...), id...))
+	pr.u = append(rc4Rounds(pr.key, h[:]), make([]byte, 16)...)
+}
+
+// encrypt returns data encrypted with the key of object n.
+func (pr *pdfProtection) encrypt(n int, data []byte) []byte {
+	b := append(append([]byte{}, pr.key...), byte(n), byte(n>>8), byte(n>>16), 0, 0)
+	h := md5.Sum(b)
+	c, _ := rc4.NewCipher(h[:])
+	out := make([]byte, len(data))
+	c.XORKeyStream(out, data)
+	return out
+}
+
+// dict returns the entries of the encryption dictionary.
+func (pr *pdfProtection) dict() string {
+	return "/Filter /Standard /V 2 /R 3 /Length 128" +
+		" /O <" + hex.EncodeToString(pr.o) + "> /U <" + hex.EncodeToString(pr.u) + ">" +
+		" /P " + strconv.Itoa(int(pr.p))
+}
+
+// padPassword truncates or pads a password to 32 bytes.
+func padPassword(s string) []byte {
+	b := append([]byte(s), pdfPasswordPadding...)
+	return b[:32]
+}
+
+// rc4Rounds encrypts data with key, then 19 more times with the key bytes
+// xored with the round number.
+func rc4Rounds(key, data []byte) []byte {
+	out := append([]byte{}, data...)
+	k := make([]byte, len(key))
+	for i := 0; i < 20; i++ {
+		for j := range key {
+			k[j] = key[j] ^ byte(i)
+		}
+		c, _ := rc4.NewCipher(k)
+		c.XORKeyStream(out, out)
+	}
+	return out
+}
+
+// putEncryption writes the encryption dictionary.
+func (p *Fpdf) putEncryption() {
+	p.newObj()
+	p.encryptionObj = p.n
+	p.put("<<" + p.protection.dict() + ">>")
+	p.put("endobj")
+}
@@ -0,0 +1,66 @@
+package gofpdf
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSetProtection(t *testing.T) {
+	p := newTestPdf()
+	p.SetProtection(PermissionPrint|PermissionCopy, "user", "owner")
+	p.Cell(40, 10, "confidential", 0, 0, "L", false, "")
+	doc := output(t, p)
+
+	if strings.Contains(doc, "confidential") {
+		t.Error("page content is not encrypted")
+	}
+	n := findRef(t, doc, `/Encrypt (\d+) 0 R`)
+	enc := pdfObject(t, doc, n)
+	m := regexp.MustCompile(`/O <([0-9a-f]*)> /U <([0-9a-f]*)> /P (-?\d+)`).FindStringSubmatch(enc)
+	if m == nil || !strings.Contains(enc, "/Filter /Standard /V 2 /R 3 /Length 128") {
+		t.Fatalf("encryption dictionary %q", enc)
+	}
+	o, _ := hex.DecodeString(m[1])
+	u, _ := hex.DecodeString(m[2])
+	if len(o) != 32 || len(u) != 32 {
+		t.Errorf("/O is %d bytes and /U %d bytes, want 32", len(o), len(u))
+	}
+	perm, _ := strconv.Atoi(m[3])
+	if perm&(PermissionPrint|PermissionCopy) == 0 || perm&(PermissionModify|PermissionAnnotate) != 0 {
+		t.Errorf("/P %d does not grant exactly printing and copying", perm)
+	}
+	ids := regexp.MustCompile(`/ID \[<([0-9a-f]{32})> <[0-9a-f]{32}>\]`).FindStringSubmatch(doc)
+	if ids == nil {
+		t.Fatal("trailer has no /ID")
+	}
+	id, _ := hex.DecodeString(ids[1])
+
+	// A reader derives the key from the user password (algorithm 2) and
+	// checks it against the first 16 bytes of /U (algorithm 5).
+	b := append(padPassword("user"), o...)
+	b = append(b, byte(perm), byte(perm>>8), byte(perm>>16), byte(perm>>24))
+	h := md5.Sum(append(b, id...))
+	for i := 0; i < 50; i++ {
+		h = md5.Sum(h[:16])
+	}
+	key := h[:16]
+	check := md5.Sum(append(append([]byte{}, pdfPasswordPadding...), id...))
+	if !bytes.Equal(rc4Rounds(key, check[:]), u[:16]) {
+		t.Error("the user password does not open the document")
+	}
+
+	// With that key the page content decrypts to the text operators.
+	pr := &pdfProtection{key: key}
+	page := findRef(t, doc, `/Contents (\d+) 0 R`)
+	obj := pdfObject(t, doc, page)
+	start := strings.Index(obj, "stream\n") + len("stream\n")
+	end := strings.LastIndex(obj, "\nendstream")
+	if plain := pr.encrypt(page, []byte(obj[start:end])); !bytes.Contains(plain, []byte("(confidential) Tj")) {
+		t.Errorf("decrypted content %q", plain)
+	}
+}
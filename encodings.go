@@ -0,0 +1,624 @@
+package gofpdf
+
+// Code pages supported by AddFontWithEncoding. Each table maps the bytes
+// 0x80-0xFF to Unicode code points; zero marks an unused position.
+var codePages = map[string]*[128]rune{
+	"cp1250": {
+		0x20AC, 0x0000, 0x201A, 0x0000, 0x201E, 0x2026, 0x2020, 0x2021,
+		0x0000, 0x2030, 0x0160, 0x2039, 0x015A, 0x0164, 0x017D, 0x0179,
+		0x0000, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+		0x0000, 0x2122, 0x0161, 0x203A, 0x015B, 0x0165, 0x017E, 0x017A,
+		0x00A0, 0x02C7, 0x02D8, 0x0141, 0x00A4, 0x0104, 0x00A6, 0x00A7,
+		0x00A8, 0x00A9, 0x015E, 0x00AB, 0x00AC, 0x00AD, 0x00AE, 0x017B,
+		0x00B0, 0x00B1, 0x02DB, 0x0142, 0x00B4, 0x00B5, 0x00B6, 0x00B7,
+		0x00B8, 0x0105, 0x015F, 0x00BB, 0x013D, 0x02DD, 0x013E, 0x017C,
+		0x0154, 0x00C1, 0x00C2, 0x0102, 0x00C4, 0x0139, 0x0106, 0x00C7,
+		0x010C, 0x00C9, 0x0118, 0x00CB, 0x011A, 0x00CD, 0x00CE, 0x010E,
+		0x0110, 0x0143, 0x0147, 0x00D3, 0x00D4, 0x0150, 0x00D6, 0x00D7,
+		0x0158, 0x016E, 0x00DA, 0x0170, 0x00DC, 0x00DD, 0x0162, 0x00DF,
+		0x0155, 0x00E1, 0x00E2, 0x0103, 0x00E4, 0x013A, 0x0107, 0x00E7,
+		0x010D, 0x00E9, 0x0119, 0x00EB, 0x011B, 0x00ED, 0x00EE, 0x010F,
+		0x0111, 0x0144, 0x0148, 0x00F3, 0x00F4, 0x0151, 0x00F6, 0x00F7,
+		0x0159, 0x016F, 0x00FA, 0x0171, 0x00FC, 0x00FD, 0x0163, 0x02D9,
+	},
+	"cp1251": {
+		0x0402, 0x0403, 0x201A, 0x0453, 0x201E, 0x2026, 0x2020, 0x2021,
+		0x20AC, 0x2030, 0x0409, 0x2039, 0x040A, 0x040C, 0x040B, 0x040F,
+		0x0452, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+		0x0000, 0x2122, 0x0459, 0x203A, 0x045A, 0x045C, 0x045B, 0x045F,
+		0x00A0, 0x040E, 0x045E, 0x0408, 0x00A4, 0x0490, 0x00A6, 0x00A7,
+		0x0401, 0x00A9, 0x0404, 0x00AB, 0x00AC, 0x00AD, 0x00AE, 0x0407,
+		0x00B0, 0x00B1, 0x0406, 0x0456, 0x0491, 0x00B5, 0x00B6, 0x00B7,
+		0x0451, 0x2116, 0x0454, 0x00BB, 0x0458, 0x0405, 0x0455, 0x0457,
+		0x0410, 0x0411, 0x0412, 0x0413, 0x0414, 0x0415, 0x0416, 0x0417,
+		0x0418, 0x0419, 0x041A, 0x041B, 0x041C, 0x041D, 0x041E, 0x041F,
+		0x0420, 0x0421, 0x0422, 0x0423, 0x0424, 0x0425, 0x0426, 0x0427,
+		0x0428, 0x0429, 0x042A, 0x042B, 0x042C, 0x042D, 0x042E, 0x042F,
+		0x0430, 0x0431, 0x0432, 0x0433, 0x0434, 0x0435, 0x0436, 0x0437,
+		0x0438, 0x0439, 0x043A, 0x043B, 0x043C, 0x043D, 0x043E, 0x043F,
+		0x0440, 0x0441, 0x0442, 0x0443, 0x0444, 0x0445, 0x0446, 0x0447,
+		0x0448, 0x0449, 0x044A, 0x044B, 0x044C, 0x044D, 0x044E, 0x044F,
+	},
+	"cp1252": {
+		0x20AC, 0x0000, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+		0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0x0000, 0x017D, 0x0000,
+		0x0000, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+		0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0x0000, 0x017E, 0x0178,
+		0x00A0, 0x00A1, 0x00A2, 0x00A3, 0x00A4, 0x00A5, 0x00A6, 0x00A7,
+		0x00A8, 0x00A9, 0x00AA, 0x00AB, 0x00AC, 0x00AD, 0x00AE, 0x00AF,
+		0x00B0, 0x00B1, 0x00B2, 0x00B3, 0x00B4, 0x00B5, 0x00B6, 0x00B7,
+		0x00B8, 0x00B9, 0x00BA, 0x00BB, 0x00BC, 0x00BD, 0x00BE, 0x00BF,
+		0x00C0, 0x00C1, 0x00C2, 0x00C3, 0x00C4, 0x00C5, 0x00C6, 0x00C7,
+		0x00C8, 0x00C9, 0x00CA, 0x00CB, 0x00CC, 0x00CD, 0x00CE, 0x00CF,
+		0x00D0, 0x00D1, 0x00D2, 0x00D3, 0x00D4, 0x00D5, 0x00D6, 0x00D7,
+		0x00D8, 0x00D9, 0x00DA, 0x00DB, 0x00DC, 0x00DD, 0x00DE, 0x00DF,
+		0x00E0, 0x00E1, 0x00E2, 0x00E3, 0x00E4, 0x00E5, 0x00E6, 0x00E7,
+		0x00E8, 0x00E9, 0x00EA, 0x00EB, 0x00EC, 0x00ED, 0x00EE, 0x00EF,
+		0x00F0, 0x00F1, 0x00F2, 0x00F3, 0x00F4, 0x00F5, 0x00F6, 0x00F7,
+		0x00F8, 0x00F9, 0x00FA, 0x00FB, 0x00FC, 0x00FD, 0x00FE, 0x00FF,
+	},
+	"cp1253": {
+		0x20AC, 0x0000, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+		0x0000, 0x2030, 0x0000, 0x2039, 0x0000, 0x0000, 0x0000, 0x0000,
+		0x0000, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+		0x0000, 0x2122, 0x0000, 0x203A, 0x0000, 0x0000, 0x0000, 0x0000,
+		0x00A0, 0x0385, 0x0386, 0x00A3, 0x00A4, 0x00A5, 0x00A6, 0x00A7,
+		0x00A8, 0x00A9, 0x0000, 0x00AB, 0x00AC, 0x00AD, 0x00AE, 0x2015,
+		0x00B0, 0x00B1, 0x00B2, 0x00B3, 0x0384, 0x00B5, 0x00B6, 0x00B7,
+		0x0388, 0x0389, 0x038A, 0x00BB, 0x038C, 0x00BD, 0x038E, 0x038F,
+		0x0390, 0x0391, 0x0392, 0x0393, 0x0394, 0x0395, 0x0396, 0x0397,
+		0x0398, 0x0399, 0x039A, 0x039B, 0x039C, 0x039D, 0x039E, 0x039F,
+		0x03A0, 0x03A1, 0x0000, 0x03A3, 0x03A4, 0x03A5, 0x03A6, 0x03A7,
+		0x03A8, 0x03A9, 0x03AA, 0x03AB, 0x03AC, 0x03AD, 0x03AE, 0x03AF,
+		0x03B0, 0x03B1, 0x03B2, 0x03B3, 0x03B4, 0x03B5, 0x03B6, 0x03B7,
+		0x03B8, 0x03B9, 0x03BA, 0x03BB, 0x03BC, 0x03BD, 0x03BE, 0x03BF,
+		0x03C0, 0x03C1, 0x03C2, 0x03C3, 0x03C4, 0x03C5, 0x03C6, 0x03C7,
+		0x03C8, 0x03C9, 0x03CA, 0x03CB, 0x03CC, 0x03CD, 0x03CE, 0x0000,
+	},
+	"cp1254": {
+		0x20AC, 0x0000, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+		0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0x0000, 0x0000, 0x0000,
+		0x0000, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+		0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0x0000, 0x0000, 0x0178,
+		0x00A0, 0x00A1, 0x00A2, 0x00A3, 0x00A4, 0x00A5, 0x00A6, 0x00A7,
+		0x00A8, 0x00A9, 0x00AA, 0x00AB, 0x00AC, 0x00AD, 0x00AE, 0x00AF,
+		0x00B0, 0x00B1, 0x00B2, 0x00B3, 0x00B4, 0x00B5, 0x00B6, 0x00B7,
+		0x00B8, 0x00B9, 0x00BA, 0x00BB, 0x00BC, 0x00BD, 0x00BE, 0x00BF,
+		0x00C0, 0x00C1, 0x00C2, 0x00C3, 0x00C4, 0x00C5, 0x00C6, 0x00C7,
+		0x00C8, 0x00C9, 0x00CA, 0x00CB, 0x00CC, 0x00CD, 0x00CE, 0x00CF,
+		0x011E, 0x00D1, 0x00D2, 0x00D3, 0x00D4, 0x00D5, 0x00D6, 0x00D7,
+		0x00D8, 0x00D9, 0x00DA, 0x00DB, 0x00DC, 0x0130, 0x015E, 0x00DF,
+		0x00E0, 0x00E1, 0x00E2, 0x00E3, 0x00E4, 0x00E5, 0x00E6, 0x00E7,
+		0x00E8, 0x00E9, 0x00EA, 0x00EB, 0x00EC, 0x00ED, 0x00EE, 0x00EF,
+		0x011F, 0x00F1, 0x00F2, 0x00F3, 0x00F4, 0x00F5, 0x00F6, 0x00F7,
+		0x00F8, 0x00F9, 0x00FA, 0x00FB, 0x00FC, 0x0131, 0x015F, 0x00FF,
+	},
+	"cp1257": {
+		0x20AC, 0x0000, 0x201A, 0x0000, 0x201E, 0x2026, 0x2020, 0x2021,
+		0x0000, 0x2030, 0x0000, 0x2039, 0x0000, 0x00A8, 0x02C7, 0x00B8,
+		0x0000, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+		0x0000, 0x2122, 0x0000, 0x203A, 0x0000, 0x00AF, 0x02DB, 0x0000,
+		0x00A0, 0x0000, 0x00A2, 0x00A3, 0x00A4, 0x0000, 0x00A6, 0x00A7,
+		0x00D8, 0x00A9, 0x0156, 0x00AB, 0x00AC, 0x00AD, 0x00AE, 0x00C6,
+		0x00B0, 0x00B1, 0x00B2, 0x00B3, 0x00B4, 0x00B5, 0x00B6, 0x00B7,
+		0x00F8, 0x00B9, 0x0157, 0x00BB, 0x00BC, 0x00BD, 0x00BE, 0x00E6,
+		0x0104, 0x012E, 0x0100, 0x0106, 0x00C4, 0x00C5, 0x0118, 0x0112,
+		0x010C, 0x00C9, 0x0179, 0x0116, 0x0122, 0x0136, 0x012A, 0x013B,
+		0x0160, 0x0143, 0x0145, 0x00D3, 0x014C, 0x00D5, 0x00D6, 0x00D7,
+		0x0172, 0x0141, 0x015A, 0x016A, 0x00DC, 0x017B, 0x017D, 0x00DF,
+		0x0105, 0x012F, 0x0101, 0x0107, 0x00E4, 0x00E5, 0x0119, 0x0113,
+		0x010D, 0x00E9, 0x017A, 0x0117, 0x0123, 0x0137, 0x012B, 0x013C,
+		0x0161, 0x0144, 0x0146, 0x00F3, 0x014D, 0x00F5, 0x00F6, 0x00F7,
+		0x0173, 0x0142, 0x015B, 0x016B, 0x00FC, 0x017C, 0x017E, 0x02D9,
+	},
+	"iso-8859-1": {
+		0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000,
+		0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000,
+		0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000,
+		0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000,
+		0x00A0, 0x00A1, 0x00A2, 0x00A3, 0x00A4, 0x00A5, 0x00A6, 0x00A7,
+		0x00A8, 0x00A9, 0x00AA, 0x00AB, 0x00AC, 0x00AD, 0x00AE, 0x00AF,
+		0x00B0, 0x00B1, 0x00B2, 0x00B3, 0x00B4, 0x00B5, 0x00B6, 0x00B7,
+		0x00B8, 0x00B9, 0x00BA, 0x00BB, 0x00BC, 0x00BD, 0x00BE, 0x00BF,
+		0x00C0, 0x00C1, 0x00C2, 0x00C3, 0x00C4, 0x00C5, 0x00C6, 0x00C7,
+		0x00C8, 0x00C9, 0x00CA, 0x00CB, 0x00CC, 0x00CD, 0x00CE, 0x00CF,
+		0x00D0, 0x00D1, 0x00D2, 0x00D3, 0x00D4, 0x00D5, 0x00D6, 0x00D7,
+		0x00D8, 0x00D9, 0x00DA, 0x00DB, 0x00DC, 0x00DD, 0x00DE, 0x00DF,
+		0x00E0, 0x00E1, 0x00E2, 0x00E3, 0x00E4, 0x00E5, 0x00E6, 0x00E7,
+		0x00E8, 0x00E9, 0x00EA, 0x00EB, 0x00EC, 0x00ED, 0x00EE, 0x00EF,
+		0x00F0, 0x00F1, 0x00F2, 0x00F3, 0x00F4, 0x00F5, 0x00F6, 0x00F7,
+		0x00F8, 0x00F9, 0x00FA, 0x00FB, 0x00FC, 0x00FD, 0x00FE, 0x00FF,
+	},
+	"iso-8859-2": {
+		0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000,
+		0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000,
+		0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000,
+		0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000,
+		0x00A0, 0x0104, 0x02D8, 0x0141, 0x00A4, 0x013D, 0x015A, 0x00A7,
+		0x00A8, 0x0160, 0x015E, 0x0164, 0x0179, 0x00AD, 0x017D, 0x017B,
+		0x00B0, 0x0105, 0x02DB, 0x0142, 0x00B4, 0x013E, 0x015B, 0x02C7,
+		0x00B8, 0x0161, 0x015F, 0x0165, 0x017A, 0x02DD, 0x017E, 0x017C,
+		0x0154, 0x00C1, 0x00C2, 0x0102, 0x00C4, 0x0139, 0x0106, 0x00C7,
+		0x010C, 0x00C9, 0x0118, 0x00CB, 0x011A, 0x00CD, 0x00CE, 0x010E,
+		0x0110, 0x0143, 0x0147, 0x00D3, 0x00D4, 0x0150, 0x00D6, 0x00D7,
+		0x0158, 0x016E, 0x00DA, 0x0170, 0x00DC, 0x00DD, 0x0162, 0x00DF,
+		0x0155, 0x00E1, 0x00E2, 0x0103, 0x00E4, 0x013A, 0x0107, 0x00E7,
+		0x010D, 0x00E9, 0x0119, 0x00EB, 0x011B, 0x00ED, 0x00EE, 0x010F,
+		0x0111, 0x0144, 0x0148, 0x00F3, 0x00F4, 0x0151, 0x00F6, 0x00F7,
+		0x0159, 0x016F, 0x00FA, 0x0171, 0x00FC, 0x00FD, 0x0163, 0x02D9,
+	},
+	"iso-8859-15": {
+		0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000,
+		0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000,
+		0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000,
+		0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000,
+		0x00A0, 0x00A1, 0x00A2, 0x00A3, 0x20AC, 0x00A5, 0x0160, 0x00A7,
+		0x0161, 0x00A9, 0x00AA, 0x00AB, 0x00AC, 0x00AD, 0x00AE, 0x00AF,
+		0x00B0, 0x00B1, 0x00B2, 0x00B3, 0x017D, 0x00B5, 0x00B6, 0x00B7,
+		0x017E, 0x00B9, 0x00BA, 0x00BB, 0x0152, 0x0153, 0x0178, 0x00BF,
+		0x00C0, 0x00C1, 0x00C2, 0x00C3, 0x00C4, 0x00C5, 0x00C6, 0x00C7,
+		0x00C8, 0x00C9, 0x00CA, 0x00CB, 0x00CC, 0x00CD, 0x00CE, 0x00CF,
+		0x00D0, 0x00D1, 0x00D2, 0x00D3, 0x00D4, 0x00D5, 0x00D6, 0x00D7,
+		0x00D8, 0x00D9, 0x00DA, 0x00DB, 0x00DC, 0x00DD, 0x00DE, 0x00DF,
+		0x00E0, 0x00E1, 0x00E2, 0x00E3, 0x00E4, 0x00E5, 0x00E6, 0x00E7,
+		0x00E8, 0x00E9, 0x00EA, 0x00EB, 0x00EC, 0x00ED, 0x00EE, 0x00EF,
+		0x00F0, 0x00F1, 0x00F2, 0x00F3, 0x00F4, 0x00F5, 0x00F6, 0x00F7,
+		0x00F8, 0x00F9, 0x00FA, 0x00FB, 0x00FC, 0x00FD, 0x00FE, 0x00FF,
+	},
+}
+
+// glyphNames holds the PostScript glyph names of the non-ASCII characters
+// found in the code pages.
+var glyphNames = map[rune]string{
+	0x00A0: "space",
+	0x00A1: "exclamdown",
+	0x00A2: "cent",
+	0x00A3: "sterling",
+	0x00A4: "currency",
+	0x00A5: "yen",
+	0x00A6: "brokenbar",
+	0x00A7: "section",
+	0x00A8: "dieresis",
+	0x00A9: "copyright",
+	0x00AA: "ordfeminine",
+	0x00AB: "guillemotleft",
+	0x00AC: "logicalnot",
+	0x00AD: "hyphen",
+	0x00AE: "registered",
+	0x00AF: "macron",
+	0x00B0: "degree",
+	0x00B1: "plusminus",
+	0x00B2: "twosuperior",
+	0x00B3: "threesuperior",
+	0x00B4: "acute",
+	0x00B5: "mu",
+	0x00B6: "paragraph",
+	0x00B7: "periodcentered",
+	0x00B8: "cedilla",
+	0x00B9: "onesuperior",
+	0x00BA: "ordmasculine",
+	0x00BB: "guillemotright",
+	0x00BC: "onequarter",
+	0x00BD: "onehalf",
+	0x00BE: "threequarters",
+	0x00BF: "questiondown",
+	0x00C0: "Agrave",
+	0x00C1: "Aacute",
+	0x00C2: "Acircumflex",
+	0x00C3: "Atilde",
+	0x00C4: "Adieresis",
+	0x00C5: "Aring",
+	0x00C6: "AE",
+	0x00C7: "Ccedilla",
+	0x00C8: "Egrave",
+	0x00C9: "Eacute",
+	0x00CA: "Ecircumflex",
+	0x00CB: "Edieresis",
+	0x00CC: "Igrave",
+	0x00CD: "Iacute",
+	0x00CE: "Icircumflex",
+	0x00CF: "Idieresis",
+	0x00D0: "Eth",
+	0x00D1: "Ntilde",
+	0x00D2: "Ograve",
+	0x00D3: "Oacute",
+	0x00D4: "Ocircumflex",
+	0x00D5: "Otilde",
+	0x00D6: "Odieresis",
+	0x00D7: "multiply",
+	0x00D8: "Oslash",
+	0x00D9: "Ugrave",
+	0x00DA: "Uacute",
+	0x00DB: "Ucircumflex",
+	0x00DC: "Udieresis",
+	0x00DD: "Yacute",
+	0x00DE: "Thorn",
+	0x00DF: "germandbls",
+	0x00E0: "agrave",
+	0x00E1: "aacute",
+	0x00E2: "acircumflex",
+	0x00E3: "atilde",
+	0x00E4: "adieresis",
+	0x00E5: "aring",
+	0x00E6: "ae",
+	0x00E7: "ccedilla",
+	0x00E8: "egrave",
+	0x00E9: "eacute",
+	0x00EA: "ecircumflex",
+	0x00EB: "edieresis",
+	0x00EC: "igrave",
+	0x00ED: "iacute",
+	0x00EE: "icircumflex",
+	0x00EF: "idieresis",
+	0x00F0: "eth",
+	0x00F1: "ntilde",
+	0x00F2: "ograve",
+	0x00F3: "oacute",
+	0x00F4: "ocircumflex",
+	0x00F5: "otilde",
+	0x00F6: "odieresis",
+	0x00F7: "divide",
+	0x00F8: "oslash",
+	0x00F9: "ugrave",
+	0x00FA: "uacute",
+	0x00FB: "ucircumflex",
+	0x00FC: "udieresis",
+	0x00FD: "yacute",
+	0x00FE: "thorn",
+	0x00FF: "ydieresis",
+	0x0100: "Amacron",
+	0x0101: "amacron",
+	0x0102: "Abreve",
+	0x0103: "abreve",
+	0x0104: "Aogonek",
+	0x0105: "aogonek",
+	0x0106: "Cacute",
+	0x0107: "cacute",
+	0x010C: "Ccaron",
+	0x010D: "ccaron",
+	0x010E: "Dcaron",
+	0x010F: "dcaron",
+	0x0110: "Dcroat",
+	0x0111: "dcroat",
+	0x0112: "Emacron",
+	0x0113: "emacron",
+	0x0116: "Edotaccent",
+	0x0117: "edotaccent",
+	0x0118: "Eogonek",
+	0x0119: "eogonek",
+	0x011A: "Ecaron",
+	0x011B: "ecaron",
+	0x011E: "Gbreve",
+	0x011F: "gbreve",
+	0x0122: "Gcommaaccent",
+	0x0123: "gcommaaccent",
+	0x012A: "Imacron",
+	0x012B: "imacron",
+	0x012E: "Iogonek",
+	0x012F: "iogonek",
+	0x0130: "Idotaccent",
+	0x0131: "dotlessi",
+	0x0136: "Kcommaaccent",
+	0x0137: "kcommaaccent",
+	0x0139: "Lacute",
+	0x013A: "lacute",
+	0x013B: "Lcommaaccent",
+	0x013C: "lcommaaccent",
+	0x013D: "Lcaron",
+	0x013E: "lcaron",
+	0x0141: "Lslash",
+	0x0142: "lslash",
+	0x0143: "Nacute",
+	0x0144: "nacute",
+	0x0145: "Ncommaaccent",
+	0x0146: "ncommaaccent",
+	0x0147: "Ncaron",
+	0x0148: "ncaron",
+	0x014C: "Omacron",
+	0x014D: "omacron",
+	0x0150: "Ohungarumlaut",
+	0x0151: "ohungarumlaut",
+	0x0152: "OE",
+	0x0153: "oe",
+	0x0154: "Racute",
+	0x0155: "racute",
+	0x0156: "Rcommaaccent",
+	0x0157: "rcommaaccent",
+	0x0158: "Rcaron",
+	0x0159: "rcaron",
+	0x015A: "Sacute",
+	0x015B: "sacute",
+	0x015E: "Scedilla",
+	0x015F: "scedilla",
+	0x0160: "Scaron",
+	0x0161: "scaron",
+	0x0162: "Tcommaaccent",
+	0x0163: "tcommaaccent",
+	0x0164: "Tcaron",
+	0x0165: "tcaron",
+	0x016A: "Umacron",
+	0x016B: "umacron",
+	0x016E: "Uring",
+	0x016F: "uring",
+	0x0170: "Uhungarumlaut",
+	0x0171: "uhungarumlaut",
+	0x0172: "Uogonek",
+	0x0173: "uogonek",
+	0x0178: "Ydieresis",
+	0x0179: "Zacute",
+	0x017A: "zacute",
+	0x017B: "Zdotaccent",
+	0x017C: "zdotaccent",
+	0x017D: "Zcaron",
+	0x017E: "zcaron",
+	0x0192: "florin",
+	0x02C6: "circumflex",
+	0x02C7: "caron",
+	0x02D8: "breve",
+	0x02D9: "dotaccent",
+	0x02DB: "ogonek",
+	0x02DC: "tilde",
+	0x02DD: "hungarumlaut",
+	0x0384: "tonos",
+	0x0385: "dieresistonos",
+	0x0386: "Alphatonos",
+	0x0388: "Epsilontonos",
+	0x0389: "Etatonos",
+	0x038A: "Iotatonos",
+	0x038C: "Omicrontonos",
+	0x038E: "Upsilontonos",
+	0x038F: "Omegatonos",
+	0x0390: "iotadieresistonos",
+	0x0391: "Alpha",
+	0x0392: "Beta",
+	0x0393: "Gamma",
+	0x0394: "Delta",
+	0x0395: "Epsilon",
+	0x0396: "Zeta",
+	0x0397: "Eta",
+	0x0398: "Theta",
+	0x0399: "Iota",
+	0x039A: "Kappa",
+	0x039B: "Lambda",
+	0x039C: "Mu",
+	0x039D: "Nu",
+	0x039E: "Xi",
+	0x039F: "Omicron",
+	0x03A0: "Pi",
+	0x03A1: "Rho",
+	0x03A3: "Sigma",
+	0x03A4: "Tau",
+	0x03A5: "Upsilon",
+	0x03A6: "Phi",
+	0x03A7: "Chi",
+	0x03A8: "Psi",
+	0x03A9: "Omega",
+	0x03AA: "Iotadieresis",
+	0x03AB: "Upsilondieresis",
+	0x03AC: "alphatonos",
+	0x03AD: "epsilontonos",
+	0x03AE: "etatonos",
+	0x03AF: "iotatonos",
+	0x03B0: "upsilondieresistonos",
+	0x03B1: "alpha",
+	0x03B2: "beta",
+	0x03B3: "gamma",
+	0x03B4: "delta",
+	0x03B5: "epsilon",
+	0x03B6: "zeta",
+	0x03B7: "eta",
+	0x03B8: "theta",
+	0x03B9: "iota",
+	0x03BA: "kappa",
+	0x03BB: "lambda",
+	0x03BC: "mu",
+	0x03BD: "nu",
+	0x03BE: "xi",
+	0x03BF: "omicron",
+	0x03C0: "pi",
+	0x03C1: "rho",
+	0x03C2: "sigma1",
+	0x03C3: "sigma",
+	0x03C4: "tau",
+	0x03C5: "upsilon",
+	0x03C6: "phi",
+	0x03C7: "chi",
+	0x03C8: "psi",
+	0x03C9: "omega",
+	0x03CA: "iotadieresis",
+	0x03CB: "upsilondieresis",
+	0x03CC: "omicrontonos",
+	0x03CD: "upsilontonos",
+	0x03CE: "omegatonos",
+	0x0401: "afii10023",
+	0x0402: "afii10051",
+	0x0403: "afii10052",
+	0x0404: "afii10053",
+	0x0405: "afii10054",
+	0x0406: "afii10055",
+	0x0407: "afii10056",
+	0x0408: "afii10057",
+	0x0409: "afii10058",
+	0x040A: "afii10059",
+	0x040B: "afii10060",
+	0x040C: "afii10061",
+	0x040E: "afii10062",
+	0x040F: "afii10145",
+	0x0410: "afii10017",
+	0x0411: "afii10018",
+	0x0412: "afii10019",
+	0x0413: "afii10020",
+	0x0414: "afii10021",
+	0x0415: "afii10022",
+	0x0416: "afii10024",
+	0x0417: "afii10025",
+	0x0418: "afii10026",
+	0x0419: "afii10027",
+	0x041A: "afii10028",
+	0x041B: "afii10029",
+	0x041C: "afii10030",
+	0x041D: "afii10031",
+	0x041E: "afii10032",
+	0x041F: "afii10033",
+	0x0420: "afii10034",
+	0x0421: "afii10035",
+	0x0422: "afii10036",
+	0x0423: "afii10037",
+	0x0424: "afii10038",
+	0x0425: "afii10039",
+	0x0426: "afii10040",
+	0x0427: "afii10041",
+	0x0428: "afii10042",
+	0x0429: "afii10043",
+	0x042A: "afii10044",
+	0x042B: "afii10045",
+	0x042C: "afii10046",
+	0x042D: "afii10047",
+	0x042E: "afii10048",
+	0x042F: "afii10049",
+	0x0430: "afii10065",
+	0x0431: "afii10066",
+	0x0432: "afii10067",
+	0x0433: "afii10068",
+	0x0434: "afii10069",
+	0x0435: "afii10070",
+	0x0436: "afii10072",
+	0x0437: "afii10073",
+	0x0438: "afii10074",
+	0x0439: "afii10075",
+	0x043A: "afii10076",
+	0x043B: "afii10077",
+	0x043C: "afii10078",
+	0x043D: "afii10079",
+	0x043E: "afii10080",
+	0x043F: "afii10081",
+	0x0440: "afii10082",
+	0x0441: "afii10083",
+	0x0442: "afii10084",
+	0x0443: "afii10085",
+	0x0444: "afii10086",
+	0x0445: "afii10087",
+	0x0446: "afii10088",
+	0x0447: "afii10089",
+	0x0448: "afii10090",
+	0x0449: "afii10091",
+	0x044A: "afii10092",
+	0x044B: "afii10093",
+	0x044C: "afii10094",
+	0x044D: "afii10095",
+	0x044E: "afii10096",
+	0x044F: "afii10097",
+	0x0451: "afii10071",
+	0x0452: "afii10099",
+	0x0453: "afii10100",
+	0x0454: "afii10101",
+	0x0455: "afii10102",
+	0x0456: "afii10103",
+	0x0457: "afii10104",
+	0x0458: "afii10105",
+	0x0459: "afii10106",
+	0x045A: "afii10107",
+	0x045B: "afii10108",
+	0x045C: "afii10109",
+	0x045E: "afii10110",
+	0x045F: "afii10193",
+	0x0490: "afii10050",
+	0x0491: "afii10098",
+	0x2013: "endash",
+	0x2014: "emdash",
+	0x2015: "afii00208",
+	0x2018: "quoteleft",
+	0x2019: "quoteright",
+	0x201A: "quotesinglbase",
+	0x201C: "quotedblleft",
+	0x201D: "quotedblright",
+	0x201E: "quotedblbase",
+	0x2020: "dagger",
+	0x2021: "daggerdbl",
+	0x2022: "bullet",
+	0x2026: "ellipsis",
+	0x2030: "perthousand",
+	0x2039: "guilsinglleft",
+	0x203A: "guilsinglright",
+	0x20AC: "Euro",
+	0x2116: "afii61352",
+	0x2122: "trademark",
+}
+
+// glyphBase maps accented letters missing from cp1252 to the ASCII letter
+// whose width approximates theirs.
+var glyphBase = map[rune]byte{
+	0x0100: 'A',
+	0x0101: 'a',
+	0x0102: 'A',
+	0x0103: 'a',
+	0x0104: 'A',
+	0x0105: 'a',
+	0x0106: 'C',
+	0x0107: 'c',
+	0x010C: 'C',
+	0x010D: 'c',
+	0x010E: 'D',
+	0x010F: 'd',
+	0x0112: 'E',
+	0x0113: 'e',
+	0x0116: 'E',
+	0x0117: 'e',
+	0x0118: 'E',
+	0x0119: 'e',
+	0x011A: 'E',
+	0x011B: 'e',
+	0x011E: 'G',
+	0x011F: 'g',
+	0x0122: 'G',
+	0x0123: 'g',
+	0x012A: 'I',
+	0x012B: 'i',
+	0x012E: 'I',
+	0x012F: 'i',
+	0x0130: 'I',
+	0x0136: 'K',
+	0x0137: 'k',
+	0x0139: 'L',
+	0x013A: 'l',
+	0x013B: 'L',
+	0x013C: 'l',
+	0x013D: 'L',
+	0x013E: 'l',
+	0x0143: 'N',
+	0x0144: 'n',
+	0x0145: 'N',
+	0x0146: 'n',
+	0x0147: 'N',
+	0x0148: 'n',
+	0x014C: 'O',
+	0x014D: 'o',
+	0x0150: 'O',
+	0x0151: 'o',
+	0x0154: 'R',
+	0x0155: 'r',
+	0x0156: 'R',
+	0x0157: 'r',
+	0x0158: 'R',
+	0x0159: 'r',
+	0x015A: 'S',
+	0x015B: 's',
+	0x015E: 'S',
+	0x015F: 's',
+	0x0162: 'T',
+	0x0163: 't',
+	0x0164: 'T',
+	0x0165: 't',
+	0x016A: 'U',
+	0x016B: 'u',
+	0x016E: 'U',
+	0x016F: 'u',
+	0x0170: 'U',
+	0x0171: 'u',
+	0x0172: 'U',
+	0x0173: 'u',
+	0x0179: 'Z',
+	0x017A: 'z',
+	0x017B: 'Z',
+	0x017C: 'z',
+}
@@ -1,5 +1,10 @@
 package gofpdf
 
+import (
+	"strconv"
+	"strings"
+)
+
 // translatedFPDFFonts contains font definitions for standard PDF fonts.
 func translatedFPDFFonts() map[string]*pdfFont {
 	fonts := map[string]*pdfFont{}
@@ -72,6 +77,92 @@ func translatedFPDFFonts() map[string]*pdfFont {
 		}
 		fonts["helvetica.php"] = font
 	}
+	// Remaining core fonts, simplified the same way as helvetica.php.
+	for file, name := range map[string]string{
+		"courieri.php":     "Courier-Oblique",
+		"courierbi.php":    "Courier-BoldOblique",
+		"helveticab.php":   "Helvetica-Bold",
+		"helveticai.php":   "Helvetica-Oblique",
+		"helveticabi.php":  "Helvetica-BoldOblique",
+		"times.php":        "Times-Roman",
+		"timesb.php":       "Times-Bold",
+		"timesi.php":       "Times-Italic",
+		"timesbi.php":      "Times-BoldItalic",
+		"symbol.php":       "Symbol",
+		"zapfdingbats.php": "ZapfDingbats",
+	} {
+		font := &pdfFont{
+			typ:  "Core",
+			name: name,
+			up:   -100,
+			ut:   50,
+			enc:  "cp1252",
+			uv:   map[int]interface{}{},
+		}
+		if name == "Symbol" || name == "ZapfDingbats" {
+			font.enc = ""
+		}
+		width := 500
+		if strings.HasPrefix(name, "Courier") {
+			width = 600
+		}
+		for i := 0; i < 256; i++ {
+			font.cw[i] = width
+		}
+		fonts[file] = font
+	}
 	// Note: In a real production standalone, all 14 core fonts would be fully populated here.
 	return fonts
 }
+
+// coreKernPairs holds the most significant kerning pairs of the AFM metrics of
+// the core fonts in thousandths of the font size, keyed by cp1252 code pairs.
+// Only the regular faces of Helvetica and Times have pairs: the AFM pairs of
+// the bold and italic faces differ and are not included, and Courier, Symbol
+// and ZapfDingbats have none.
+var coreKernPairs = func() map[string]map[[2]byte]int {
+	helvetica := parseKernPairs("AC-30 AG-30 AO-30 AQ-30 AT-120 AU-50 AV-70 AW-50 AY-100 Au-30 Av-40 Aw-40 Ay-40 " +
+		"DA-40 DV-70 DW-40 DY-90 D,-70 D.-70 FA-80 F,-150 F.-150 Fa-50 Fe-30 Fo-30 Fr-45 " +
+		"J,-30 J.-30 JA-20 Ja-20 Ju-20 KO-50 Ke-40 Ko-40 Ku-30 Ky-50 " +
+		"LT-110 LV-110 LW-70 LY-140 Ly-30 L’-160 L”-140 " +
+		"OA-20 OT-40 OV-50 OW-30 OX-60 OY-70 O,-40 O.-40 PA-120 P,-180 P.-180 Pa-40 Pe-50 Po-50 " +
+		"RO-20 RT-30 RU-40 RV-50 RW-30 RY-50 " +
+		"TA-120 TO-40 T,-120 T.-120 T--140 T:-20 T;-20 Ta-120 Te-120 To-120 Tr-120 Tu-120 Tw-120 Ty-120 " +
+		"UA-50 U,-30 U.-30 VA-80 VG-40 VO-40 V,-125 V.-125 V--80 V:-40 V;-40 Va-70 Ve-80 Vo-80 Vu-70 " +
+		"WA-50 WO-20 W,-80 W.-80 W--40 Wa-40 We-30 Wo-30 Wu-30 Wy-20 " +
+		"YA-110 YO-85 Y,-140 Y.-140 Y--140 Y:-60 Y;-60 Ya-140 Ye-140 Yo-140 Yu-110 " +
+		"f’50 f,-30 f.-30 fa-30 fe-30 fo-30 r,-50 r.-50 ra-10 v,-80 v.-80 va-25 ve-25 vo-25 " +
+		"w,-60 w.-60 wa-15 we-10 wo-10 y,-100 y.-100 ya-20 ye-20 yo-20 " +
+		"‘‘-57 ’’-57 ’d-50 ’r-50 ’s-50 ,’-100 ,”-100 .’-100 .”-100")
+	times := parseKernPairs("AO-55 AQ-55 AT-111 AU-55 AV-135 AW-90 AY-105 Av-74 Aw-92 Ay-92 " +
+		"DA-40 DV-40 DW-30 DY-55 FA-74 F,-80 F.-80 Fa-15 Fo-15 " +
+		"LT-92 LV-100 LW-74 LY-100 Ly-55 L’-74 " +
+		"OA-35 OT-40 OV-50 OW-35 OX-40 OY-50 PA-92 P,-111 P.-111 Pa-15 " +
+		"RO-40 RT-60 RU-40 RV-80 RW-55 RY-65 " +
+		"TA-93 TO-18 T,-74 T.-74 T--92 T:-50 T;-55 Ta-80 Te-70 To-80 Tr-35 Tu-45 Tw-80 Ty-80 " +
+		"UA-40 VA-135 VG-15 VO-40 V,-129 V.-129 V--100 V:-74 V;-74 Va-111 Ve-111 Vo-129 Vu-75 " +
+		"WA-120 WO-10 W,-92 W.-92 W--65 W:-37 W;-37 Wa-80 We-80 Wo-80 Wu-50 Wy-73 " +
+		"YA-120 YO-30 Y,-129 Y.-129 Y--111 Y:-92 Y;-92 Ya-100 Ye-100 Yo-110 Yu-111 " +
+		"f’55 fa-10 r,-40 r.-55 r--20 v,-65 v.-65 ve-15 vo-20 w,-65 w.-65 wo-10 y,-65 y.-65 " +
+		"‘‘-74 ’’-74 ’d-50 ’s-55 ’t-18")
+	return map[string]map[[2]byte]int{
+		"Helvetica":   helvetica,
+		"Times-Roman": times,
+	}
+}()
+
+// parseKernPairs parses space separated entries made of the two characters of
+// a pair followed by their adjustment.
+func parseKernPairs(s string) map[[2]byte]int {
+	pairs := map[[2]byte]int{}
+	for _, entry := range strings.Fields(s) {
+		r := []rune(entry)
+		b := winAnsiText(string(r[:2]))
+		v, err := strconv.Atoi(string(r[2:]))
+		if err != nil {
+			panic("invalid kerning pair " + entry)
+		}
+		pairs[[2]byte{b[0], b[1]}] = v
+	}
+	return pairs
+}
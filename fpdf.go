@@ -23,12 +23,17 @@ package gofpdf
 import (
 	"bytes"
 	"compress/zlib"
+	"crypto/md5"
 	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	stdhtml "html"
 	"image"
+	"image/color"
 	_ "image/gif"
-	stdjpeg "image/jpeg"
+	"image/jpeg"
 	_ "image/png"
 	"io"
 	"math"
@@ -39,6 +44,7 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 type pdfUVRange struct {
@@ -59,6 +65,7 @@ type pdfFont struct {
 	i         int
 	file      string
 	diff      string
+	ttf       *ttfFont
 }
 
 type pdfImage struct {
@@ -72,8 +79,70 @@ type pdfImage struct {
 	trns []int
 	data []byte
 	smk  []byte
+	icc  []byte
 	n    int
 	i    int
+	// Pixel size before downscaling, which sets the size of the image on
+	// the page; 0 when it was not downscaled.
+	ow, oh int
+}
+
+// size returns the width and height of the image in pixels as it was
+// loaded, before any downscaling.
+func (info *pdfImage) size() (float64, float64) {
+	if info.ow > 0 {
+		return float64(info.ow), float64(info.oh)
+	}
+	return float64(info.w), float64(info.h)
+}
+
+type pdfTiling struct {
+	image  string
+	w, h   float64
+	ox, oy float64
+	n      int
+}
+
+type pdfOutline struct {
+	text  string
+	level int
+	y     float64
+	page  int
+}
+
+type pdfSymbol struct {
+	name string
+	data []byte
+	wPt  float64
+	hPt  float64
+	n    int
+}
+
+type pdfExtGState struct {
+	alpha     float64
+	blendMode string
+	n         int
+}
+
+type pdfGradient struct {
+	c1     [3]float64
+	c2     [3]float64
+	coords [4]float64
+	n      int
+}
+
+// pdfScript holds the font size and text rise to restore at the end of a
+// superscript or subscript.
+type pdfScript struct {
+	sizePt float64
+	rise   float64
+}
+
+// pdfShadingPattern is a pattern painting the shading of gradient, an index
+// in gradients starting at 1.
+type pdfShadingPattern struct {
+	gradient int
+	n        int
 }
 
 // Fpdf is the main structure for PDF generation.
@@ -107,6 +176,8 @@ type Fpdf struct {
 	bMargin float64
 	cMargin float64
 
+	marginDefaults *[4]float64
+
 	x     float64
 	y     float64
 	lasth float64
@@ -119,36 +190,103 @@ type Fpdf struct {
 	fontFiles map[string]map[string]int
 	encodings map[string]int
 	cmaps     map[string]int
+	usedFonts map[string]bool
 
 	fontFamily  string
 	fontStyle   string
 	underline   bool
+	strikeout   bool
 	currentFont *pdfFont
 	fontSizePt  float64
 	fontSize    float64
 
+	// The font ResetStyle goes back to: the first one set, unless given
+	// by SetDefaultFont.
+	defaultFamily string
+	defaultStyle  string
+	defaultSize   float64
+
 	drawColor string
 	fillColor string
 	textColor string
 	colorFlag bool
 	withAlpha bool
+	alpha     float64
+	blendMode string
 	ws        float64
-
-	images map[string]*pdfImage
-
-	pageLinks map[int][][]interface{}
-	links     map[int][2]float64
+	kerning   bool
+	rise      float64
+	scripts   []pdfScript
+	dashArray []float64
+	dashPhase float64
+	capStyle  int
+	joinStyle int
+
+	noExponents bool
+	noOrdinals  bool
+
+	fontFallbacks  []string
+	fontSubsetting bool
+
+	legendHorizontal bool
+
+	htmlHeadingSizes map[int]float64
+
+	hyphenator Hyphenator
+
+	images      map[string]*pdfImage
+	imageList   []*pdfImage // distinct images, by number
+	imageHashes map[[16]byte]*pdfImage
+	iccProfiles map[string][]byte
+	thumbnails  map[int]int
+	gradients   []*pdfGradient
+	tilings     []*pdfTiling
+	shadings    []*pdfShadingPattern
+	extGStates  []*pdfExtGState
+	symbols     []*pdfSymbol
+
+	pageLinks   map[int][][]interface{}
+	links       map[int][2]float64
+	outlines    []pdfOutline
+	outlineRoot int
+
+	linkBorderWidth float64
+	linkColor       [3]int
+	linkStyle       string
+
+	cellBorderJoin   string
+	defaultCellLn    int
+	defaultCellAlign string
+	trimAware        bool
+	imageErrorMode   string
+	maxImageDim      int
+	angle            float64
+	rotationState    []string // graphicsState when the rotation began
+	underlineStyle   string
 
 	autoPageBreak    bool
 	pageBreakTrigger float64
+	continuationText string
+	inBlock          bool
 	inHeader         bool
 	inFooter         bool
 	aliasNbPages     string
+	draftText        string
 	zoomMode         interface{}
 	layoutMode       string
 	metadata         map[string]string
+	xmp              string
+	xmpObj           int
+	javascript       string
+	javascriptObj    int
 	creationDate     time.Time
+	dateLoc          *time.Location
+	canonical        bool
+	linearized       bool
 	pdfVersion       string
+	protection       *pdfProtection
+	encryptionObj    int
+	curObj           int
 
 	assetFonts map[string]*pdfFont
 	lastError  string
@@ -181,7 +319,13 @@ func (p *Fpdf) Reset(orientation, unit, size string) {
 	p.fontFiles = map[string]map[string]int{}
 	p.encodings = map[string]int{}
 	p.cmaps = map[string]int{}
+	p.usedFonts = map[string]bool{}
 	p.images = map[string]*pdfImage{}
+	p.dateLoc = time.UTC
+	p.capStyle = 2
+	p.imageHashes = map[[16]byte]*pdfImage{}
+	p.iccProfiles = map[string][]byte{}
+	p.thumbnails = map[int]int{}
 	p.links = map[int][2]float64{}
 	p.pageLinks = map[int][][]interface{}{}
 	p.inHeader = false
@@ -191,13 +335,17 @@ func (p *Fpdf) Reset(orientation, unit, size string) {
 	p.fontStyle = ""
 	p.fontSizePt = 12
 	p.underline = false
+	p.strikeout = false
 	p.drawColor = "0 G"
 	p.fillColor = "0 g"
 	p.textColor = "0 g"
 	p.colorFlag = false
 	p.withAlpha = false
+	p.alpha = 1
+	p.blendMode = "Normal"
 	p.ws = 0
 	p.fontpath = ""
+	p.fontSubsetting = true
 	p.coreFonts = []string{"courier", "helvetica", "times", "symbol", "zapfdingbats"}
 	p.assetFonts = translatedFPDFFonts()
 
@@ -261,6 +409,19 @@ func (p *Fpdf) SetHeaderFunc(f func()) { p.headerFunc = f }
 // SetFooterFunc sets a custom footer function.
 func (p *Fpdf) SetFooterFunc(f func()) { p.footerFunc = f }
 
+// AliasNbPages defines an alias for the total number of pages, replaced in
+// the text of every page when the document is closed. An empty alias stands
+// for "{nb}".
+func (p *Fpdf) AliasNbPages(alias string) {
+	if alias == "" {
+		alias = "{nb}"
+	}
+	p.aliasNbPages = alias
+}
+
+// PageNo returns the number of the current page, 0 before the first page.
+func (p *Fpdf) PageNo() int { return p.page }
+
 // GetX returns the current X position.
 func (p *Fpdf) GetX() float64 { return p.x }
 
@@ -294,6 +455,24 @@ func (p *Fpdf) SetXY(x, y float64) {
 	p.SetY(y, false)
 }
 
+// SpaceToBottom returns the vertical space left between the current position
+// and the automatic page break trigger.
+func (p *Fpdf) SpaceToBottom() float64 {
+	return p.pageBreakTrigger - p.y
+}
+
+// DrawAtBottom moves the current position to the left margin, h user units
+// above the page break trigger, and calls draw with automatic page breaks
+// disabled. It is meant for blocks pinned to the page bottom such as
+// signature areas.
+func (p *Fpdf) DrawAtBottom(h float64, draw func()) {
+	p.SetXY(p.lMargin, p.pageBreakTrigger-h)
+	auto := p.autoPageBreak
+	p.autoPageBreak = false
+	draw()
+	p.autoPageBreak = auto
+}
+
 // AddPage adds a new page to the document.
 func (p *Fpdf) AddPage(orientation, size string, rotation int) {
 	if p.state == 3 {
@@ -304,25 +483,43 @@ func (p *Fpdf) AddPage(orientation, size string, rotation int) {
 	if p.underline {
 		style += "U"
 	}
+	if p.strikeout {
+		style += "S"
+	}
 	fontsize := p.fontSizePt
 	lw := p.lineWidth
 	dc := p.drawColor
 	fc := p.fillColor
 	tc := p.textColor
 	cf := p.colorFlag
-	if p.page > 0 {
+	alpha, blendMode := p.alpha, p.blendMode
+	if p.state == 2 {
 		p.inFooter = true
 		p.Footer()
 		p.inFooter = false
 		p.endPage()
 	}
 	p.beginPage(orientation, size, rotation)
-	p.out("2 J")
+	if p.draftText != "" {
+		p.putDraftMark()
+	}
+	capStyle, joinStyle := p.capStyle, p.joinStyle
+	p.out(sprintf("%d J", capStyle))
+	if joinStyle != 0 {
+		p.out(sprintf("%d j", joinStyle))
+	}
 	p.lineWidth = lw
 	p.out(sprintf("%.2F w", lw*p.k))
+	dashArray, dashPhase := p.dashArray, p.dashPhase
+	if len(dashArray) > 0 {
+		p.out(p.dashOperator())
+	}
 	if family != "" {
 		p.SetFont(family, style, fontsize)
 	}
+	if p.rise != 0 {
+		p.out(sprintf("BT %.2F Ts ET", p.rise*p.k))
+	}
 	p.drawColor = dc
 	if dc != "0 G" {
 		p.out(dc)
@@ -333,6 +530,10 @@ func (p *Fpdf) AddPage(orientation, size string, rotation int) {
 	}
 	p.textColor = tc
 	p.colorFlag = cf
+	p.alpha, p.blendMode = alpha, blendMode
+	if alpha != 1 || blendMode != "Normal" {
+		p.out(sprintf("/GS%d gs", p.extGState(alpha, blendMode)))
+	}
 
 	p.inHeader = true
 	p.Header()
@@ -342,6 +543,18 @@ func (p *Fpdf) AddPage(orientation, size string, rotation int) {
 		p.lineWidth = lw
 		p.out(sprintf("%.2F w", lw*p.k))
 	}
+	if p.capStyle != capStyle {
+		p.capStyle = capStyle
+		p.out(sprintf("%d J", capStyle))
+	}
+	if p.joinStyle != joinStyle {
+		p.joinStyle = joinStyle
+		p.out(sprintf("%d j", joinStyle))
+	}
+	if !equalFloats(p.dashArray, dashArray) || p.dashPhase != dashPhase {
+		p.dashArray, p.dashPhase = dashArray, dashPhase
+		p.out(p.dashOperator())
+	}
 	if family != "" {
 		p.SetFont(family, style, fontsize)
 	}
@@ -355,6 +568,45 @@ func (p *Fpdf) AddPage(orientation, size string, rotation int) {
 	}
 	p.textColor = tc
 	p.colorFlag = cf
+	if p.alpha != alpha || p.blendMode != blendMode {
+		p.alpha, p.blendMode = alpha, blendMode
+		p.out(sprintf("/GS%d gs", p.extGState(alpha, blendMode)))
+	}
+}
+
+// SetDraftMode stamps text (typically "DRAFT") diagonally across every page
+// added from now on, in translucent gray beneath the page content. An empty text
+// disables the stamp.
+func (p *Fpdf) SetDraftMode(text string) { p.draftText = text }
+
+// putDraftMark writes the draft stamp centered on the current page.
+func (p *Fpdf) putDraftMark() {
+	fontkey := "helvetica"
+	if _, ok := p.fonts[fontkey]; !ok {
+		p.AddFont("helvetica", "", "", "")
+	}
+	font := p.fonts[fontkey]
+	p.usedFonts[fontkey] = true
+	txt := font.singleByteText(p.draftText)
+	units := 0
+	for i := 0; i < len(txt); i++ {
+		units += font.cw[txt[i]]
+	}
+	if units == 0 {
+		return
+	}
+	const angle = 45 * math.Pi / 180
+	c, s := math.Cos(angle), math.Sin(angle)
+	size := 0.7 * math.Hypot(p.wPt, p.hPt) * 1000 / float64(units)
+	tw := float64(units) * size / 1000
+	x := p.wPt/2 - c*tw/2 + s*0.35*size
+	y := p.hPt/2 - s*tw/2 - c*0.35*size
+	current := p.currentFont
+	p.currentFont = font
+	show := p.showText(p.draftText)
+	p.currentFont = current
+	p.out(sprintf("q /GS%d gs 0.500 g BT /F%d %.2F Tf %.5F %.5F %.5F %.5F %.2F %.2F Tm %s ET Q",
+		p.extGState(0.3, ""), font.i, size, c, s, -s, c, x, y, show))
 }
 
 // Header is called automatically when a new page is added.
@@ -382,6 +634,14 @@ func (p *Fpdf) SetMargins(left, top float64, right *float64) {
 	}
 }
 
+// SetPageMarginDefaults sets the left, top, right and bottom margins that
+// every new page starts with, so that margins changed on one page do not
+// carry over to the next. The bottom margin is the automatic page break
+// margin. The defaults apply from the next AddPage.
+func (p *Fpdf) SetPageMarginDefaults(l, t, r, b float64) {
+	p.marginDefaults = &[4]float64{l, t, r, b}
+}
+
 // SetAutoPageBreak sets the auto page break mode and the bottom margin.
 func (p *Fpdf) SetAutoPageBreak(auto bool, margin float64) {
 	p.autoPageBreak = auto
@@ -389,7 +649,107 @@ func (p *Fpdf) SetAutoPageBreak(auto bool, margin float64) {
 	p.pageBreakTrigger = p.h - margin
 }
 
-// SetFont sets the font family, style and size.
+// SetContinuationText sets a note, such as "(continued)", printed at the
+// bottom right of a page, below the last line, when an automatic page break
+// splits a MultiCell or a table. The note is also appended to the caption of
+// an HTML table repeated on the next page. An empty string, the default,
+// prints nothing.
+func (p *Fpdf) SetContinuationText(txt string) { p.continuationText = txt }
+
+// putContinuation prints the continuation text on the current page, before an
+// automatic page break splitting a block that has begun on it.
+func (p *Fpdf) putContinuation() {
+	if p.continuationText == "" || p.currentFont == nil {
+		return
+	}
+	x, y, lasth := p.x, p.y, p.lasth
+	auto := p.autoPageBreak
+	p.autoPageBreak = false
+	p.SetXY(p.lMargin, p.pageBreakTrigger)
+	p.Cell(0, 1.5*p.fontSize, p.continuationText, 0, 0, "R", false, "")
+	p.autoPageBreak = auto
+	p.x, p.y, p.lasth = x, y, lasth
+}
+
+// SetPageBox sets a page boundary of the current page: boxType is "trim",
+// "bleed", "art" or "crop". The box is the w by h rectangle whose upper-left
+// corner is at (x, y), in user units. The page size itself is the media box.
+func (p *Fpdf) SetPageBox(boxType string, x, y, w, h float64) {
+	name, ok := map[string]string{"trim": "TrimBox", "bleed": "BleedBox", "art": "ArtBox", "crop": "CropBox"}[strings.ToLower(boxType)]
+	if !ok {
+		p.panicError("incorrect page box type: " + boxType)
+	}
+	if p.page == 0 {
+		p.panicError("no page has been added")
+	}
+	if p.pageInfo[p.page] == nil {
+		p.pageInfo[p.page] = map[string]interface{}{}
+	}
+	boxes, _ := p.pageInfo[p.page]["boxes"].(map[string][4]float64)
+	if boxes == nil {
+		boxes = map[string][4]float64{}
+		p.pageInfo[p.page]["boxes"] = boxes
+	}
+	boxes[name] = [4]float64{x * p.k, (p.h - y - h) * p.k, (x + w) * p.k, (p.h - y) * p.k}
+}
+
+// SetUserUnit sets the size of the default user space unit of the current
+// page to scale points, so that viewers show the page scale times larger
+// than its coordinates. It allows pages beyond the 14400 point limit of PDF
+// viewers. scale must be at least 1.
+func (p *Fpdf) SetUserUnit(scale float64) {
+	if scale < 1 {
+		p.panicError("user unit must be at least 1")
+	}
+	if p.page == 0 {
+		p.panicError("no page has been added")
+	}
+	if p.pageInfo[p.page] == nil {
+		p.pageInfo[p.page] = map[string]interface{}{}
+	}
+	p.pageInfo[p.page]["userunit"] = scale
+	p.requireVersion("1.6")
+}
+
+// SetUnderlineStyle sets how underlined text is underlined: "solid" (the
+// default), "dashed" or "dotted".
+func (p *Fpdf) SetUnderlineStyle(style string) {
+	switch style {
+	case "solid", "dashed", "dotted":
+		p.underlineStyle = style
+	default:
+		p.panicError("incorrect underline style: " + style)
+	}
+}
+
+// ResetStyle restores the default text styling: the default font (see
+// SetDefaultFont) without underline or strikeout, black text, fill and draw
+// colors, no word spacing and no text rise. It is handy after WriteHTML or a
+// run of styled text.
+func (p *Fpdf) ResetStyle() {
+	p.underlineStyle = ""
+	if p.defaultFamily == "" {
+		p.SetFont("helvetica", "", 12)
+	} else {
+		p.SetFont(p.defaultFamily, p.defaultStyle, p.defaultSize)
+	}
+	p.SetTextColor(0, 0, 0)
+	p.SetFillColor(0, 0, 0)
+	p.SetDrawColor(0, 0, 0)
+	if p.ws != 0 {
+		p.ws = 0
+		if p.page > 0 {
+			p.out("0 Tw")
+		}
+	}
+	if p.rise != 0 {
+		p.SetTextRise(0)
+	}
+}
+
+// SetFont sets the font family, style and size. The style may combine "B"
+// (bold), "I" (italic), "U" (underline) and "S" (strikeout). size is in
+// points whatever the unit of the document; 0 keeps the current size.
 func (p *Fpdf) SetFont(family, style string, size float64) {
 	if family == "" {
 		family = p.fontFamily
@@ -403,6 +763,12 @@ func (p *Fpdf) SetFont(family, style string, size float64) {
 	} else {
 		p.underline = false
 	}
+	if strings.Contains(style, "S") {
+		p.strikeout = true
+		style = strings.ReplaceAll(style, "S", "")
+	} else {
+		p.strikeout = false
+	}
 	if style == "IB" {
 		style = "BI"
 	}
@@ -412,22 +778,9 @@ func (p *Fpdf) SetFont(family, style string, size float64) {
 	if p.fontFamily == family && p.fontStyle == style && p.fontSizePt == size {
 		return
 	}
-	fontkey := family + style
-	if _, ok := p.fonts[fontkey]; !ok {
-		if family == "arial" {
-			family = "helvetica"
-		}
-		if containsString(p.coreFonts, family) {
-			if family == "symbol" || family == "zapfdingbats" {
-				style = ""
-			}
-			fontkey = family + style
-			if _, ok2 := p.fonts[fontkey]; !ok2 {
-				p.AddFont(family, style, "", "")
-			}
-		} else {
-			p.panicError("undefined font: " + family + " " + style)
-		}
+	family, style, fontkey := p.loadFont(family, style)
+	if p.defaultFamily == "" {
+		p.defaultFamily, p.defaultStyle, p.defaultSize = family, style, size
 	}
 	p.fontFamily = family
 	p.fontStyle = style
@@ -436,10 +789,24 @@ func (p *Fpdf) SetFont(family, style string, size float64) {
 	p.currentFont = p.fonts[fontkey]
 	if p.page > 0 {
 		p.out(sprintf("BT /F%d %.2F Tf ET", p.currentFont.i, p.fontSizePt))
+		p.usedFonts[fontkey] = true
+	}
+}
+
+// SetDefaultFont sets the font ResetStyle restores, with the same arguments
+// as SetFont; underline and strikeout are ignored. It defaults to the first
+// font set in the document, or regular 12 point Helvetica if there is none.
+func (p *Fpdf) SetDefaultFont(family, style string, size float64) {
+	style = strings.ToUpper(style)
+	style = strings.NewReplacer("U", "", "S", "").Replace(style)
+	if style == "IB" {
+		style = "BI"
 	}
+	p.defaultFamily, p.defaultStyle, p.defaultSize = strings.ToLower(family), style, size
 }
 
-// SetFontSize sets the font size.
+// SetFontSize sets the font size in points, whatever the unit of the
+// document. Use SetFontUnitSize to give it in user units.
 func (p *Fpdf) SetFontSize(size float64) {
 	if p.fontSizePt == size {
 		return
@@ -451,6 +818,38 @@ func (p *Fpdf) SetFontSize(size float64) {
 	}
 }
 
+// SetFontUnitSize sets the font size in user units (e.g. millimeters for a
+// document created with the "mm" unit) rather than in points.
+func (p *Fpdf) SetFontUnitSize(size float64) {
+	p.SetFontSize(size * p.k)
+}
+
+// GetFontSize returns the current font size in points and in user units.
+func (p *Fpdf) GetFontSize() (ptSize, unitSize float64) {
+	return p.fontSizePt, p.fontSize
+}
+
+// UsedFonts returns the sorted keys (family and style, e.g. "helveticaB") of
+// the fonts referenced by the page content so far.
+func (p *Fpdf) UsedFonts() []string {
+	keys := make([]string, 0, len(p.usedFonts))
+	for k := range p.usedFonts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// UsedImages returns the sorted keys of the images embedded in the document.
+func (p *Fpdf) UsedImages() []string {
+	keys := make([]string, 0, len(p.images))
+	for k := range p.images {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // SetTextColor sets the text color (RGB).
 func (p *Fpdf) SetTextColor(r, g, b float64) {
 	if math.IsNaN(g) || (r == 0 && g == 0 && b == 0) {
@@ -486,6 +885,32 @@ func (p *Fpdf) SetDrawColor(r, g, b float64) {
 	}
 }
 
+// SetTextColorCMYK sets the text color (CMYK). Each component ranges from 0
+// to 100.
+func (p *Fpdf) SetTextColorCMYK(c, m, y, k float64) {
+	p.textColor = sprintf("%.3F %.3F %.3F %.3F k", c/100, m/100, y/100, k/100)
+	p.colorFlag = p.fillColor != p.textColor
+}
+
+// SetFillColorCMYK sets the fill color (CMYK). Each component ranges from 0
+// to 100.
+func (p *Fpdf) SetFillColorCMYK(c, m, y, k float64) {
+	p.fillColor = sprintf("%.3F %.3F %.3F %.3F k", c/100, m/100, y/100, k/100)
+	p.colorFlag = p.fillColor != p.textColor
+	if p.page > 0 {
+		p.out(p.fillColor)
+	}
+}
+
+// SetDrawColorCMYK sets the draw color (CMYK). Each component ranges from 0
+// to 100.
+func (p *Fpdf) SetDrawColorCMYK(c, m, y, k float64) {
+	p.drawColor = sprintf("%.3F %.3F %.3F %.3F K", c/100, m/100, y/100, k/100)
+	if p.page > 0 {
+		p.out(p.drawColor)
+	}
+}
+
 // SetLineWidth sets the line width.
 func (p *Fpdf) SetLineWidth(width float64) {
 	p.lineWidth = width
@@ -494,11 +919,113 @@ func (p *Fpdf) SetLineWidth(width float64) {
 	}
 }
 
+// SetLineCapStyle sets how the ends of lines drawn from now on are shaped: 0
+// for butt caps, 1 for round caps and 2 for projecting square caps, the
+// default. The style carries over to new pages.
+func (p *Fpdf) SetLineCapStyle(style int) {
+	if style < 0 || style > 2 {
+		p.panicError("incorrect line cap style: " + strconv.Itoa(style))
+	}
+	p.capStyle = style
+	if p.page > 0 {
+		p.out(sprintf("%d J", style))
+	}
+}
+
+// SetLineJoinStyle sets how the segments of paths drawn from now on are
+// joined: 0 for miter joins, the default, 1 for round joins and 2 for bevel
+// joins. The style carries over to new pages.
+func (p *Fpdf) SetLineJoinStyle(style int) {
+	if style < 0 || style > 2 {
+		p.panicError("incorrect line join style: " + strconv.Itoa(style))
+	}
+	p.joinStyle = style
+	if p.page > 0 {
+		p.out(sprintf("%d j", style))
+	}
+}
+
+// SetDashPattern sets the dash pattern of the lines drawn from now on, on this
+// page and the following ones. dashArray alternates the lengths of dashes and
+// gaps in user units, and phase is the distance into the pattern at which
+// lines start. A nil or empty dashArray, or one of zeros only, restores solid
+// lines.
+func (p *Fpdf) SetDashPattern(dashArray []float64, phase float64) {
+	solid := true
+	for _, v := range dashArray {
+		if v < 0 {
+			p.panicError("dash lengths must not be negative")
+		}
+		if v > 0 {
+			solid = false
+		}
+	}
+	// PDF readers reject a pattern whose lengths are all zero.
+	if solid {
+		dashArray, phase = nil, 0
+	}
+	p.dashArray = append([]float64(nil), dashArray...)
+	p.dashPhase = phase
+	if p.page > 0 {
+		p.out(p.dashOperator())
+	}
+}
+
+// dashOperator returns the operator setting the current dash pattern.
+func (p *Fpdf) dashOperator() string {
+	parts := make([]string, len(p.dashArray))
+	for i, v := range p.dashArray {
+		parts[i] = sprintf("%.2F", v*p.k)
+	}
+	return sprintf("[%s] %.2F d", strings.Join(parts, " "), p.dashPhase*p.k)
+}
+
 // Line draws a line.
 func (p *Fpdf) Line(x1, y1, x2, y2 float64) {
 	p.out(sprintf("%.2F %.2F m %.2F %.2F l S", x1*p.k, (p.h-y1)*p.k, x2*p.k, (p.h-y2)*p.k))
 }
 
+// Arrow draws a line from (x1, y1) to (x2, y2) ending with a triangular
+// arrowhead whose length and width are headSize. style applies to the
+// arrowhead like in Rect: "F" (the default when empty) fills it, "D" outlines
+// it and "DF" does both.
+func (p *Fpdf) Arrow(x1, y1, x2, y2 float64, headSize float64, style string) {
+	l := math.Hypot(x2-x1, y2-y1)
+	if l == 0 {
+		return
+	}
+	ux, uy := (x2-x1)/l, (y2-y1)/l
+	p.Line(x1, y1, x2-ux*headSize, y2-uy*headSize)
+	p.arrowHead(x2, y2, ux, uy, headSize, style)
+}
+
+// DoubleArrow draws a line like Arrow with an arrowhead at both ends.
+func (p *Fpdf) DoubleArrow(x1, y1, x2, y2 float64, headSize float64, style string) {
+	l := math.Hypot(x2-x1, y2-y1)
+	if l == 0 {
+		return
+	}
+	ux, uy := (x2-x1)/l, (y2-y1)/l
+	p.Line(x1+ux*headSize, y1+uy*headSize, x2-ux*headSize, y2-uy*headSize)
+	p.arrowHead(x2, y2, ux, uy, headSize, style)
+	p.arrowHead(x1, y1, -ux, -uy, headSize, style)
+}
+
+// arrowHead draws an arrowhead with its tip at (x, y) pointing in the unit
+// direction (ux, uy).
+func (p *Fpdf) arrowHead(x, y, ux, uy, size float64, style string) {
+	bx, by := x-ux*size, y-uy*size
+	nx, ny := -uy*size/2, ux*size/2
+	op := "f"
+	switch style {
+	case "D":
+		op = "s"
+	case "FD", "DF":
+		op = "b"
+	}
+	p.out(sprintf("%.2F %.2F m %.2F %.2F l %.2F %.2F l %s", x*p.k, (p.h-y)*p.k, (bx+nx)*p.k, (p.h-(by+ny))*p.k, (bx-nx)*p.k, (p.h-(by-ny))*p.k, op))
+}
+
 // Rect draws a rectangle. style: "D" or empty for draw, "F" for fill, "DF" or "FD" for both.
 func (p *Fpdf) Rect(x, y, w, h float64, style string) {
 	op := "S"
@@ -511,69 +1038,614 @@ func (p *Fpdf) Rect(x, y, w, h float64, style string) {
 	p.out(sprintf("%.2F %.2F %.2F %.2F re %s", x*p.k, (p.h-y)*p.k, w*p.k, -h*p.k, op))
 }
 
-// Text prints a string at a specific position.
-func (p *Fpdf) Text(x, y float64, txt string) {
-	if p.currentFont == nil {
-		p.panicError("no font has been set")
+// Curve draws a quadratic Bézier curve from (x0, y0) to (x1, y1) with
+// control point (cx, cy). style is as for Rect.
+func (p *Fpdf) Curve(x0, y0, cx, cy, x1, y1 float64, style string) {
+	// A quadratic curve is the cubic curve whose control points lie two
+	// thirds of the way from each end point to the quadratic control point.
+	p.CurveBezier(x0, y0, x0+2*(cx-x0)/3, y0+2*(cy-y0)/3, x1+2*(cx-x1)/3, y1+2*(cy-y1)/3, x1, y1, style)
+}
+
+// CurveBezier draws a cubic Bézier curve from (x0, y0) to (x1, y1) with
+// control points (cx0, cy0) and (cx1, cy1). style is as for Rect; a filled
+// curve is closed by a straight line back to its start.
+func (p *Fpdf) CurveBezier(x0, y0, cx0, cy0, cx1, cy1, x1, y1 float64, style string) {
+	op := "S"
+	switch style {
+	case "F":
+		op = "f"
+	case "FD", "DF":
+		op = "B"
 	}
-	s := sprintf("BT %.2F %.2F Td (%s) Tj ET", x*p.k, (p.h-y)*p.k, p.escape(txt))
-	if p.underline && txt != "" {
-		s += " " + p.doUnderline(x, y, txt)
+	p.out(sprintf("%.2F %.2F m %.2F %.2F %.2F %.2F %.2F %.2F c %s", x0*p.k, (p.h-y0)*p.k,
+		cx0*p.k, (p.h-cy0)*p.k, cx1*p.k, (p.h-cy1)*p.k, x1*p.k, (p.h-y1)*p.k, op))
+}
+
+// Polygon draws the closed polygon going through points, given in user
+// units. style is as for Rect. Nothing is drawn with fewer than two points.
+func (p *Fpdf) Polygon(points [][2]float64, style string) {
+	if len(points) < 2 {
+		return
 	}
-	if p.colorFlag {
-		s = "q " + p.textColor + " " + s + " Q"
+	op := "S"
+	switch style {
+	case "F":
+		op = "f"
+	case "FD", "DF":
+		op = "B"
 	}
-	p.out(s)
+	p.out(p.pointsPath(points) + " h " + op)
 }
 
-// Cell prints a cell (rectangular area) with optional borders and background.
-func (p *Fpdf) Cell(w, h float64, txt string, border interface{}, ln int, align string, fill bool, link interface{}) {
-	k := p.k
-	if p.y+h > p.pageBreakTrigger && !p.inHeader && !p.inFooter && p.AcceptPageBreak() {
-		x := p.x
-		ws := p.ws
-		if ws > 0 {
-			p.ws = 0
-			p.out("0 Tw")
-		}
-		p.AddPage(p.curOrientation, "", p.curRotation)
-		p.x = x
-		if ws > 0 {
-			p.ws = ws
-			p.out(sprintf("%.3F Tw", ws*k))
+// Polyline strokes the open path going through points, given in user units.
+// Nothing is drawn with fewer than two points.
+func (p *Fpdf) Polyline(points [][2]float64) {
+	if len(points) < 2 {
+		return
+	}
+	p.out(p.pointsPath(points) + " S")
+}
+
+// pointsPath returns the path operators joining points with straight lines.
+func (p *Fpdf) pointsPath(points [][2]float64) string {
+	var sb strings.Builder
+	for i, pt := range points {
+		op := "l"
+		if i == 0 {
+			op = "m"
+		} else {
+			sb.WriteString(" ")
 		}
+		sb.WriteString(sprintf("%.2F %.2F %s", pt[0]*p.k, (p.h-pt[1])*p.k, op))
 	}
-	if w == 0 {
-		w = p.w - p.rMargin - p.x
+	return sb.String()
+}
+
+// RoundedRect draws a rectangle whose four corners are rounded with radius
+// r. style is as for Rect.
+func (p *Fpdf) RoundedRect(x, y, w, h, r float64, style string) {
+	p.RoundedRectExt(x, y, w, h, r, r, r, r, style)
+}
+
+// RoundedRectExt draws a rectangle with its own radius for the top-left,
+// top-right, bottom-right and bottom-left corners. A radius of zero leaves
+// that corner square, and radii larger than half the width or height are
+// reduced to fit. style is as for Rect.
+func (p *Fpdf) RoundedRectExt(x, y, w, h, rTL, rTR, rBR, rBL float64, style string) {
+	op := "S"
+	switch style {
+	case "F":
+		op = "f"
+	case "FD", "DF":
+		op = "B"
 	}
-	s := ""
-	if fill || border == 1 || border == "1" {
-		op := "S"
-		if fill {
-			if border == 1 || border == "1" {
-				op = "B"
-			} else {
-				op = "f"
-			}
-		}
-		s = sprintf("%.2F %.2F %.2F %.2F re %s ", p.x*k, (p.h-p.y)*k, w*k, -h*k, op)
+	limit := math.Min(math.Abs(w), math.Abs(h)) / 2
+	clamp := func(r float64) float64 {
+		return math.Max(0, math.Min(r, limit))
 	}
-	if bs, ok := border.(string); ok {
-		x := p.x
-		y := p.y
-		if strings.Contains(bs, "L") {
-			s += sprintf("%.2F %.2F m %.2F %.2F l S ", x*k, (p.h-y)*k, x*k, (p.h-(y+h))*k)
-		}
-		if strings.Contains(bs, "T") {
-			s += sprintf("%.2F %.2F m %.2F %.2F l S ", x*k, (p.h-y)*k, (x+w)*k, (p.h-y)*k)
-		}
-		if strings.Contains(bs, "R") {
-			s += sprintf("%.2F %.2F m %.2F %.2F l S ", (x+w)*k, (p.h-y)*k, (x+w)*k, (p.h-(y+h))*k)
-		}
-		if strings.Contains(bs, "B") {
-			s += sprintf("%.2F %.2F m %.2F %.2F l S ", x*k, (p.h-(y+h))*k, (x+w)*k, (p.h-(y+h))*k)
+	rTL, rTR, rBR, rBL = clamp(rTL), clamp(rTR), clamp(rBR), clamp(rBL)
+	k := p.k
+	pt := func(px, py float64) string {
+		return sprintf("%.2F %.2F", px*k, (p.h-py)*k)
+	}
+	var sb strings.Builder
+	// corner appends the quarter arc of radius r from (x0, y0) to (x1, y1)
+	// around the corner point (cx, cy).
+	corner := func(x0, y0, cx, cy, x1, y1, r float64) {
+		sb.WriteString(" " + pt(x0, y0) + " l")
+		if r > 0 {
+			const kappa = 0.5523
+			sb.WriteString(sprintf(" %s %s %s c", pt(x0+(cx-x0)*kappa, y0+(cy-y0)*kappa), pt(x1+(cx-x1)*kappa, y1+(cy-y1)*kappa), pt(x1, y1)))
 		}
 	}
+	sb.WriteString(pt(x+rTL, y) + " m")
+	corner(x+w-rTR, y, x+w, y, x+w, y+rTR, rTR)
+	corner(x+w, y+h-rBR, x+w, y+h, x+w-rBR, y+h, rBR)
+	corner(x+rBL, y+h, x, y+h, x, y+h-rBL, rBL)
+	corner(x, y+rTL, x, y, x+rTL, y, rTL)
+	sb.WriteString(" h " + op)
+	p.out(sb.String())
+}
+
+// ProgressBar draws a w by h bar at (x, y) with rounded ends: the whole bar
+// is filled with bgColor and its first fraction (0 to 1, clamped) with
+// fillColor. Colors are RGB triples (0-255); the fill color in effect before
+// the call is restored.
+func (p *Fpdf) ProgressBar(x, y, w, h, fraction float64, fillColor, bgColor [3]int) {
+	fraction = math.Max(0, math.Min(1, fraction))
+	fc, cf := p.fillColor, p.colorFlag
+	p.SetFillColor(float64(bgColor[0]), float64(bgColor[1]), float64(bgColor[2]))
+	p.RoundedRect(x, y, w, h, h/2, "F")
+	if fraction > 0 {
+		p.SetFillColor(float64(fillColor[0]), float64(fillColor[1]), float64(fillColor[2]))
+		p.RoundedRect(x, y, fraction*w, h, h/2, "F")
+	}
+	p.fillColor, p.colorFlag = fc, cf
+	p.out(fc)
+}
+
+// Band fills a band of height h across the content width at the current
+// position with color, an RGB triple (0-255), and prints txt inside it with
+// the given alignment ("L", "C" or "R"). The position moves below the band,
+// after a page break if it does not fit. The fill color in effect before the
+// call is restored.
+func (p *Fpdf) Band(h float64, color [3]int, txt string, align string) {
+	fc, cf := p.fillColor, p.colorFlag
+	p.SetFillColor(float64(color[0]), float64(color[1]), float64(color[2]))
+	p.x = p.lMargin
+	p.Cell(p.w-p.lMargin-p.rMargin, h, txt, 0, 1, align, true, nil)
+	p.fillColor, p.colorFlag = fc, cf
+	p.out(fc)
+}
+
+// StarRating draws a row of max five-pointed stars, each size wide, whose
+// upper-left corner is at (x, y). The first value stars, rounded to the
+// nearest whole star, are filled with the fill color; the others are only
+// outlined with the draw color.
+func (p *Fpdf) StarRating(x, y, size float64, value, max float64) {
+	n := int(math.Ceil(max))
+	filled := int(math.Round(math.Max(0, math.Min(value, max))))
+	outer, inner := size/2, size/2*0.382
+	for i := 0; i < n; i++ {
+		cx, cy := x+float64(i)*size*1.2+outer, y+outer
+		points := make([][2]float64, 10)
+		for j := range points {
+			r := outer
+			if j%2 == 1 {
+				r = inner
+			}
+			a := float64(j)*math.Pi/5 - math.Pi/2
+			points[j] = [2]float64{cx + r*math.Cos(a), cy + r*math.Sin(a)}
+		}
+		style := "D"
+		if i < filled {
+			style = "DF"
+		}
+		p.Polygon(points, style)
+	}
+}
+
+// LegendEntry is an item of a chart legend drawn by Legend.
+type LegendEntry struct {
+	// Color is the RGB color (0-255) of the swatch.
+	Color [3]int
+	// Label is printed next to the swatch.
+	Label string
+}
+
+// Legend draws a chart legend whose upper-left corner is at (x, y): a square
+// color swatch followed by its label for each entry, stacked vertically or
+// laid out in a row depending on SetLegendLayout. Labels use the current font,
+// which sets the size of the swatches and the spacing. The legend is drawn
+// without automatic page breaks. The fill color and the current position in
+// effect before the call are restored.
+func (p *Fpdf) Legend(x, y float64, entries []LegendEntry) {
+	if p.currentFont == nil {
+		p.panicError("no font has been set")
+	}
+	fc, cf := p.fillColor, p.colorFlag
+	x0, y0 := p.x, p.y
+	auto := p.autoPageBreak
+	p.autoPageBreak = false
+	lh := p.fontSize * 1.5
+	size := p.fontSize * 0.8
+	for _, e := range entries {
+		p.SetFillColor(float64(e.Color[0]), float64(e.Color[1]), float64(e.Color[2]))
+		p.Rect(x, y+(lh-size)/2, size, size, "F")
+		w := p.GetStringWidth(e.Label) + 2*p.cMargin
+		p.SetXY(x+size*1.5, y)
+		p.Cell(w, lh, e.Label, 0, 0, "L", false, nil)
+		if p.legendHorizontal {
+			x += size*1.5 + w + size
+		} else {
+			y += lh
+		}
+	}
+	p.autoPageBreak = auto
+	p.fillColor, p.colorFlag = fc, cf
+	p.out(fc)
+	p.x, p.y = x0, y0
+}
+
+// SetLegendLayout sets how Legend lays out its entries: "vertical" (the
+// default) or "horizontal".
+func (p *Fpdf) SetLegendLayout(layout string) {
+	switch strings.ToLower(layout) {
+	case "vertical", "":
+		p.legendHorizontal = false
+	case "horizontal":
+		p.legendHorizontal = true
+	default:
+		p.panicError("invalid legend layout: " + layout)
+	}
+}
+
+// RectOptions holds the optional settings of RectWithOptions.
+type RectOptions struct {
+	// Style is "D" or empty for draw, "F" for fill, "DF" or "FD" for both.
+	Style string
+	// Opacity applies to both the stroke and the fill of the rectangle and
+	// ranges from 0 (invisible) to 1. Zero is treated as unset, leaving the
+	// rectangle opaque.
+	Opacity float64
+}
+
+// RectWithOptions draws a rectangle like Rect. A partial opacity is applied
+// through a graphics state that is pushed for this rectangle only, so later
+// drawing is not affected.
+func (p *Fpdf) RectWithOptions(x, y, w, h float64, opts RectOptions) {
+	if opts.Opacity < 0 || opts.Opacity > 1 {
+		p.panicError("opacity must be between 0 and 1")
+	}
+	if opts.Opacity == 0 || opts.Opacity == 1 {
+		p.Rect(x, y, w, h, opts.Style)
+		return
+	}
+	p.out(sprintf("q /GS%d gs", p.extGState(opts.Opacity, "")))
+	p.Rect(x, y, w, h, opts.Style)
+	p.out("Q")
+}
+
+// extGState returns the index of the graphics state with the given opacity
+// and blend mode (none if empty), registering it if needed.
+func (p *Fpdf) extGState(alpha float64, blendMode string) int {
+	for i, gs := range p.extGStates {
+		if gs.alpha == alpha && gs.blendMode == blendMode {
+			return i + 1
+		}
+	}
+	p.requireVersion("1.4")
+	p.extGStates = append(p.extGStates, &pdfExtGState{alpha: alpha, blendMode: blendMode})
+	return len(p.extGStates)
+}
+
+// SetAlpha sets the opacity, from 0 (invisible) to 1, of everything drawn
+// afterwards, both strokes and fills, and the blend mode used to paint it
+// over the page: "Normal" (the default when empty), "Multiply", "Screen",
+// "Overlay", "Darken", "Lighten", "ColorDodge", "ColorBurn", "HardLight",
+// "SoftLight", "Difference", "Exclusion", "Hue", "Saturation", "Color" or
+// "Luminosity".
+func (p *Fpdf) SetAlpha(alpha float64, blendMode string) {
+	if alpha < 0 || alpha > 1 {
+		p.panicError("alpha must be between 0 and 1")
+	}
+	if blendMode == "" {
+		blendMode = "Normal"
+	}
+	if !containsString(blendModes, blendMode) {
+		p.panicError("incorrect blend mode: " + blendMode)
+	}
+	p.alpha, p.blendMode = alpha, blendMode
+	if p.page > 0 {
+		p.out(sprintf("/GS%d gs", p.extGState(alpha, blendMode)))
+	}
+}
+
+// SetAlphaReset restores full opacity and the normal blend mode.
+func (p *Fpdf) SetAlphaReset() {
+	p.SetAlpha(1, "Normal")
+}
+
+var blendModes = []string{"Normal", "Multiply", "Screen", "Overlay", "Darken", "Lighten", "ColorDodge", "ColorBurn",
+	"HardLight", "SoftLight", "Difference", "Exclusion", "Hue", "Saturation", "Color", "Luminosity"}
+
+// Text prints a string at a specific position. Line breaks are not
+// interpreted; use MultiCell or Write for multi-line text.
+func (p *Fpdf) Text(x, y float64, txt string) {
+	if p.currentFont == nil {
+		p.panicError("no font has been set")
+	}
+	s := sprintf("BT %.2F %.2F Td %s ET", x*p.k, (p.h-y)*p.k, p.showText(txt))
+	if p.underline && txt != "" {
+		s += " " + p.doUnderline(x, y, txt)
+	}
+	if p.strikeout && txt != "" {
+		s += " " + p.doStrikeout(x, y, txt)
+	}
+	if p.colorFlag {
+		s = "q " + p.textColor + " " + s + " Q"
+	}
+	p.out(s)
+}
+
+// TextOnArc prints txt along a circle of radius r centered on (cx, cy). Each
+// character is rotated to follow the arc and advanced by its own width along
+// the circumference. startAngle gives, in degrees counter-clockwise from the
+// positive x axis, where the first character starts. With clockwise set the
+// text runs clockwise with its top facing outward, as on the upper edge of a
+// seal; otherwise it runs counter-clockwise with its top facing the center.
+func (p *Fpdf) TextOnArc(cx, cy, r float64, txt string, startAngle float64, clockwise bool) {
+	if p.currentFont == nil {
+		p.panicError("no font has been set")
+	}
+	if r <= 0 {
+		p.panicError("arc radius must be positive")
+	}
+	dir := 1.0
+	if clockwise {
+		dir = -1
+	}
+	var sb strings.Builder
+	angle := startAngle * math.Pi / 180
+	for i := 0; i < len(txt); {
+		ch := txt[i : i+p.charLen(txt, i)]
+		i += len(ch)
+		cw := p.GetStringWidth(ch)
+		mid := angle + dir*cw/2/r
+		angle += dir * cw / r
+		// Baseline direction, in PDF coordinates, at the middle of the glyph.
+		tx, ty := -dir*math.Sin(mid), dir*math.Cos(mid)
+		px, py := cx+r*math.Cos(mid), (p.h-cy)+r*math.Sin(mid)
+		ox, oy := px-tx*cw/2, py-ty*cw/2
+		if sb.Len() > 0 {
+			sb.WriteString(" ")
+		}
+		sb.WriteString(sprintf("BT %.5F %.5F %.5F %.5F %.2F %.2F Tm %s ET", tx, ty, -ty, tx, ox*p.k, oy*p.k, p.showText(ch)))
+	}
+	out := sb.String()
+	if out == "" {
+		return
+	}
+	if p.colorFlag {
+		out = "q " + p.textColor + " " + out + " Q"
+	}
+	p.out(out)
+}
+
+// Rotate rotates everything drawn afterwards by angle degrees
+// counter-clockwise around (x, y), until RotateReset or the end of the page.
+// Rotations do not nest: a new call replaces the rotation in effect.
+func (p *Fpdf) Rotate(angle, x, y float64) {
+	if p.angle != 0 {
+		// Q also undoes the styling changed during the rotation.
+		p.out("Q")
+		for i, op := range p.graphicsState() {
+			if i == len(p.rotationState) || op != p.rotationState[i] {
+				p.out(op)
+			}
+		}
+	}
+	p.angle = angle
+	if angle != 0 {
+		p.rotationState = p.graphicsState()
+		p.out("q " + p.rotation(angle, x, y))
+	}
+}
+
+// graphicsState returns the operators setting the current line style, font,
+// text rise, colors and opacity, which Q restores to their values at the
+// matching q.
+func (p *Fpdf) graphicsState() []string {
+	state := []string{
+		sprintf("%d J", p.capStyle),
+		sprintf("%d j", p.joinStyle),
+		sprintf("%.2F w", p.lineWidth*p.k),
+		p.dashOperator(),
+		"",
+		sprintf("BT %.2F Ts ET", p.rise*p.k),
+		p.drawColor,
+		p.fillColor,
+	}
+	if p.currentFont != nil {
+		state[4] = sprintf("BT /F%d %.2F Tf ET", p.currentFont.i, p.fontSizePt)
+	}
+	// Full opacity needs no graphics state until SetAlpha has been used.
+	if len(p.extGStates) > 0 {
+		state = append(state, sprintf("/GS%d gs", p.extGState(p.alpha, p.blendMode)))
+	}
+	return state
+}
+
+// RotateReset cancels the rotation set with Rotate.
+func (p *Fpdf) RotateReset() {
+	p.Rotate(0, 0, 0)
+}
+
+// TextWithRotation prints txt like Text, rotated by angle degrees
+// counter-clockwise around its starting point (x, y). It does not affect a
+// rotation set with Rotate.
+func (p *Fpdf) TextWithRotation(x, y float64, txt string, angle float64) {
+	p.out("q " + p.rotation(angle, x, y))
+	p.Text(x, y, txt)
+	p.out("Q")
+}
+
+// VerticalText prints txt as a column of characters stacked downward, each
+// centered on x. y is the baseline of the first character, and the baselines
+// of the next ones are the font size plus spacing apart. A line break starts
+// a new column the same distance to the left, as in East Asian vertical text.
+func (p *Fpdf) VerticalText(x, y float64, txt string, spacing float64) {
+	if p.currentFont == nil {
+		p.panicError("no font has been set")
+	}
+	step := p.fontSize + spacing
+	cy := y
+	for i := 0; i < len(txt); {
+		n := p.charLen(txt, i)
+		ch := txt[i : i+n]
+		i += n
+		if ch == "\n" {
+			x -= step
+			cy = y
+			continue
+		}
+		if ch != " " {
+			p.Text(x-p.GetStringWidth(ch)/2, cy, ch)
+		}
+		cy += step
+	}
+}
+
+// rotation returns the transformation rotating by angle degrees
+// counter-clockwise around (x, y).
+func (p *Fpdf) rotation(angle, x, y float64) string {
+	a := angle * math.Pi / 180
+	c, s := math.Cos(a), math.Sin(a)
+	cx, cy := x*p.k, (p.h-y)*p.k
+	return sprintf("%.5F %.5F %.5F %.5F %.2F %.2F cm 1 0 0 1 %.2F %.2F cm", c, s, -s, c, cx, cy, -cx, -cy)
+}
+
+// SetCellBorderJoin sets how the sides of cell borders given as a string
+// ("L", "T", "R", "B") meet. With "miter", "round" or "bevel", adjacent sides
+// are stroked as a single path using that line join, and open ends get
+// projecting caps, so corners are closed cleanly with thick lines. An empty
+// style restores the default of stroking each side separately.
+func (p *Fpdf) SetCellBorderJoin(style string) {
+	switch style {
+	case "", "miter", "round", "bevel":
+		p.cellBorderJoin = style
+	default:
+		p.panicError("incorrect border join style: " + style)
+	}
+}
+
+// joinedBorders returns the path operators of the given sides of the w by h
+// cell at (x, y), adjacent sides being joined into one subpath.
+func (p *Fpdf) joinedBorders(sides string, x, y, w, h float64) string {
+	k := p.k
+	// Corners clockwise from the top left; side i goes from corner i to i+1.
+	corners := [4][2]float64{{x, y}, {x + w, y}, {x + w, y + h}, {x, y + h}}
+	var present [4]bool
+	for i, c := range "TRBL" {
+		present[i] = strings.ContainsRune(sides, c)
+	}
+	pt := func(i int) string {
+		c := corners[i%4]
+		return sprintf("%.2F %.2F", c[0]*k, (p.h-c[1])*k)
+	}
+	path := ""
+	if present[0] && present[1] && present[2] && present[3] {
+		path = pt(0) + " m " + pt(1) + " l " + pt(2) + " l " + pt(3) + " l h "
+	} else {
+		for i := 0; i < 4; i++ {
+			if !present[i] || present[(i+3)%4] {
+				continue
+			}
+			path += pt(i) + " m "
+			for j := i; present[j%4] && j < i+4; j++ {
+				path += pt(j+1) + " l "
+			}
+		}
+	}
+	if path == "" {
+		return ""
+	}
+	join := map[string]int{"miter": 0, "round": 1, "bevel": 2}[p.cellBorderJoin]
+	return sprintf("q %d j 2 J ", join) + path + "S Q "
+}
+
+// CellOptions holds the optional settings accepted by CellWithOptions and
+// MultiCellWithOptions.
+type CellOptions struct {
+	// BorderStyle is "solid" (the default), "dashed" or "dotted". It only
+	// affects the borders of the cell being drawn.
+	BorderStyle string
+}
+
+// Sentinel arguments of Cell and CellWithOptions standing for the defaults set
+// with SetDefaultCellLn and SetDefaultCellAlign.
+const (
+	CellLnDefault    = -1
+	CellAlignDefault = "default"
+)
+
+// SetDefaultCellLn sets the line mode used by the cells printed with
+// CellLnDefault as ln argument. It is 0 initially.
+func (p *Fpdf) SetDefaultCellLn(ln int) {
+	if ln < 0 || ln > 2 {
+		p.panicError("incorrect cell line mode: " + strconv.Itoa(ln))
+	}
+	p.defaultCellLn = ln
+}
+
+// SetDefaultCellAlign sets the alignment used by the cells printed with
+// CellAlignDefault as align argument. It is "" (left) initially.
+func (p *Fpdf) SetDefaultCellAlign(align string) {
+	if align == CellAlignDefault {
+		p.panicError("incorrect cell alignment: " + align)
+	}
+	p.defaultCellAlign = align
+}
+
+// Cell prints a cell (rectangular area) with optional borders and background.
+// The text is printed on a single line; embedded newlines are not interpreted.
+func (p *Fpdf) Cell(w, h float64, txt string, border interface{}, ln int, align string, fill bool, link interface{}) {
+	p.CellWithOptions(w, h, txt, border, ln, align, fill, link, CellOptions{})
+}
+
+// CellHighlighted prints a borderless cell like Cell whose background is
+// filled with bgColor, an RGB triple (0-255). The position moves to the right
+// of the cell and the fill color in effect before the call is restored.
+func (p *Fpdf) CellHighlighted(w, h float64, txt string, bgColor [3]int, align string) {
+	fc, cf := p.fillColor, p.colorFlag
+	p.SetFillColor(float64(bgColor[0]), float64(bgColor[1]), float64(bgColor[2]))
+	p.Cell(w, h, txt, 0, 0, align, true, "")
+	p.fillColor, p.colorFlag = fc, cf
+	p.out(fc)
+}
+
+// CellWithOptions prints a cell like Cell, applying the given options.
+func (p *Fpdf) CellWithOptions(w, h float64, txt string, border interface{}, ln int, align string, fill bool, link interface{}, opts CellOptions) {
+	if ln == CellLnDefault {
+		ln = p.defaultCellLn
+	}
+	if align == CellAlignDefault {
+		align = p.defaultCellAlign
+	}
+	k := p.k
+	if p.y+h > p.pageBreakTrigger && !p.inHeader && !p.inFooter && p.AcceptPageBreak() {
+		x := p.x
+		ws := p.ws
+		if ws > 0 {
+			p.ws = 0
+			p.out("0 Tw")
+		}
+		if p.inBlock {
+			p.putContinuation()
+		}
+		p.AddPage(p.curOrientation, "", p.curRotation)
+		p.x = x
+		if ws > 0 {
+			p.ws = ws
+			p.out(sprintf("%.3F Tw", ws*k))
+		}
+	}
+	if w == 0 {
+		w = p.w - p.rMargin - p.x
+	}
+	s := ""
+	if fill || border == 1 || border == "1" {
+		op := "S"
+		if fill {
+			if border == 1 || border == "1" {
+				op = "B"
+			} else {
+				op = "f"
+			}
+		}
+		s = sprintf("%.2F %.2F %.2F %.2F re %s ", p.x*k, (p.h-p.y)*k, w*k, -h*k, op)
+	}
+	if bs, ok := border.(string); ok && p.cellBorderJoin != "" {
+		s += p.joinedBorders(bs, p.x, p.y, w, h)
+	} else if ok {
+		x := p.x
+		y := p.y
+		if strings.Contains(bs, "L") {
+			s += sprintf("%.2F %.2F m %.2F %.2F l S ", x*k, (p.h-y)*k, x*k, (p.h-(y+h))*k)
+		}
+		if strings.Contains(bs, "T") {
+			s += sprintf("%.2F %.2F m %.2F %.2F l S ", x*k, (p.h-y)*k, (x+w)*k, (p.h-y)*k)
+		}
+		if strings.Contains(bs, "R") {
+			s += sprintf("%.2F %.2F m %.2F %.2F l S ", (x+w)*k, (p.h-y)*k, (x+w)*k, (p.h-(y+h))*k)
+		}
+		if strings.Contains(bs, "B") {
+			s += sprintf("%.2F %.2F m %.2F %.2F l S ", x*k, (p.h-(y+h))*k, (x+w)*k, (p.h-(y+h))*k)
+		}
+	}
+	if dash := p.borderDash(opts.BorderStyle); dash != "" && s != "" {
+		s = "q " + dash + s + "Q "
+	}
 	if txt != "" {
 		if p.currentFont == nil {
 			p.panicError("no font has been set")
@@ -588,10 +1660,13 @@ func (p *Fpdf) Cell(w, h float64, txt string, border interface{}, ln int, align
 		if p.colorFlag {
 			s += "q " + p.textColor + " "
 		}
-		s += sprintf("BT %.2F %.2F Td (%s) Tj ET", (p.x+dx)*k, (p.h-(p.y+0.5*h+0.3*p.fontSize))*k, p.escape(txt))
+		s += sprintf("BT %.2F %.2F Td %s ET", (p.x+dx)*k, (p.h-(p.y+0.5*h+0.3*p.fontSize))*k, p.showText(txt))
 		if p.underline {
 			s += " " + p.doUnderline(p.x+dx, p.y+0.5*h+0.3*p.fontSize, txt)
 		}
+		if p.strikeout {
+			s += " " + p.doStrikeout(p.x+dx, p.y+0.5*h+0.3*p.fontSize, txt)
+		}
 		if p.colorFlag {
 			s += " Q"
 		}
@@ -615,6 +1690,12 @@ func (p *Fpdf) Cell(w, h float64, txt string, border interface{}, ln int, align
 
 // MultiCell prints text with line breaks.
 func (p *Fpdf) MultiCell(w, h float64, txt string, border interface{}, align string, fill bool) {
+	p.MultiCellWithOptions(w, h, txt, border, align, fill, CellOptions{})
+}
+
+// MultiCellWithOptions prints text with line breaks like MultiCell, applying
+// the given options to every line.
+func (p *Fpdf) MultiCellWithOptions(w, h float64, txt string, border interface{}, align string, fill bool, opts CellOptions) {
 	if p.currentFont == nil {
 		p.panicError("no font has been set")
 	}
@@ -647,6 +1728,14 @@ func (p *Fpdf) MultiCell(w, h float64, txt string, border interface{}, align str
 			}
 		}
 	}
+	// A page break before the first line does not split the text, so the
+	// continuation text is only printed for the following lines.
+	inBlock := p.inBlock
+	p.inBlock = false
+	line := func(txt string) {
+		p.CellWithOptions(w, h, txt, b, 2, align, fill, "", opts)
+		p.inBlock = true
+	}
 	sep := -1
 	i, j := 0, 0
 	l, ns, nl := 0, 0, 1
@@ -657,7 +1746,7 @@ func (p *Fpdf) MultiCell(w, h float64, txt string, border interface{}, align str
 				p.ws = 0
 				p.out("0 Tw")
 			}
-			p.Cell(w, h, s[j:i], b, 2, align, fill, "")
+			line(s[j:i])
 			i++
 			sep = -1
 			j = i
@@ -673,17 +1762,31 @@ func (p *Fpdf) MultiCell(w, h float64, txt string, border interface{}, align str
 			sep = i
 			ns++
 		}
-		l += p.charWidth(c)
+		l += p.charWidthAt(s, i)
 		if float64(l) > wmax {
-			if sep == -1 {
+			if k, ok := p.hyphenBreak(s[:nb], j, i, wmax); ok {
+				text := s[j:k] + "-"
+				if align == "J" {
+					if spaces := strings.Count(text, " "); spaces > 0 {
+						p.ws = (w - 2*p.cMargin - p.GetStringWidth(text)) / float64(spaces)
+						p.out(sprintf("%.3F Tw", p.ws*p.k))
+					}
+				}
+				line(text)
+				if p.ws > 0 {
+					p.ws = 0
+					p.out("0 Tw")
+				}
+				i = k
+			} else if sep == -1 {
 				if i == j {
-					i++
+					i += p.charLen(s, i)
 				}
 				if p.ws > 0 {
 					p.ws = 0
 					p.out("0 Tw")
 				}
-				p.Cell(w, h, s[j:i], b, 2, align, fill, "")
+				line(s[j:i])
 			} else {
 				if align == "J" {
 					spaces := strings.Count(s[j:sep], " ")
@@ -693,7 +1796,7 @@ func (p *Fpdf) MultiCell(w, h float64, txt string, border interface{}, align str
 						p.out(sprintf("%.3F Tw", p.ws*p.k))
 					}
 				}
-				p.Cell(w, h, s[j:sep], b, 2, align, fill, "")
+				line(s[j:sep])
 				i = sep + 1
 			}
 			sep = -1
@@ -717,10 +1820,123 @@ func (p *Fpdf) MultiCell(w, h float64, txt string, border interface{}, align str
 	} else if bs, ok := border.(string); ok && strings.Contains(bs, "B") {
 		b += "B"
 	}
-	p.Cell(w, h, s[j:i], b, 2, align, fill, "")
+	line(s[j:i])
+	p.inBlock = inBlock
+	p.x = p.lMargin
+}
+
+// MultiCellStriped prints txt like a borderless, left-aligned MultiCell and
+// fills the background of the wrapped lines alternately with colorA and
+// colorB, RGB triples (0-255). The fill color in effect before the call is
+// restored.
+func (p *Fpdf) MultiCellStriped(w, h float64, txt string, colorA, colorB [3]int) {
+	if p.currentFont == nil {
+		p.panicError("no font has been set")
+	}
+	if w == 0 {
+		w = p.w - p.rMargin - p.x
+	}
+	fc, cf := p.fillColor, p.colorFlag
+	s := strings.ReplaceAll(txt, "\r", "")
+	for i, r := range p.lineRanges(s, w) {
+		c := colorA
+		if i%2 == 1 {
+			c = colorB
+		}
+		p.SetFillColor(float64(c[0]), float64(c[1]), float64(c[2]))
+		p.Cell(w, h, r.text(s), 0, 2, "L", true, "")
+	}
+	p.fillColor, p.colorFlag = fc, cf
+	p.out(fc)
 	p.x = p.lMargin
 }
 
+// Hyphenator finds the places where a word may be hyphenated. Hyphenate
+// returns the word split at every legal break point; the parts joined
+// together must give back the word.
+type Hyphenator interface {
+	Hyphenate(word string) []string
+}
+
+// SetHyphenator sets the hyphenator that MultiCell, SplitLines and TextBox
+// consult when a word does not fit at the end of a line. The word is broken
+// at the last point that fits and a hyphen is printed there. By default, or
+// when h is nil, words are never hyphenated.
+func (p *Fpdf) SetHyphenator(h Hyphenator) { p.hyphenator = h }
+
+// SplitLines returns the lines MultiCell would print for txt in a cell of
+// width w using the current font.
+func (p *Fpdf) SplitLines(txt string, w float64) []string {
+	s := strings.ReplaceAll(txt, "\r", "")
+	ranges := p.lineRanges(s, w)
+	lines := make([]string, len(ranges))
+	for i, r := range ranges {
+		lines[i] = r.text(s)
+	}
+	return lines
+}
+
+// ClampText returns txt wrapped like SplitLines for a cell of width w and
+// limited to maxLines lines, joined with newlines. When lines are dropped, the
+// last line kept is shortened as needed to end with an ellipsis within w.
+func (p *Fpdf) ClampText(w float64, maxLines int, txt string) string {
+	if maxLines <= 0 {
+		return ""
+	}
+	lines := p.SplitLines(txt, w)
+	if len(lines) <= maxLines {
+		return strings.Join(lines, "\n")
+	}
+	lines = lines[:maxLines]
+	last := []rune(strings.TrimRight(lines[maxLines-1], " "))
+	for len(last) > 0 && p.GetStringWidth(string(last)+"\u2026") > w-2*p.cMargin {
+		last = []rune(strings.TrimRight(string(last[:len(last)-1]), " "))
+	}
+	lines[maxLines-1] = string(last) + "\u2026"
+	return strings.Join(lines, "\n")
+}
+
+// TextBox fills the w by h rectangle at (x, y) with txt wrapped to the box
+// width, using a line height of 1.2 times the font size. align is "L", "C",
+// "R" or "J"; justified text keeps the last line of each paragraph left
+// aligned. The text that does not fit is returned so that it can be continued
+// in another box; it is empty when everything was printed.
+func (p *Fpdf) TextBox(x, y, w, h float64, txt string, align string) (overflow string) {
+	if p.currentFont == nil {
+		p.panicError("no font has been set")
+	}
+	s := strings.ReplaceAll(txt, "\r", "")
+	lh := p.fontSize * 1.2
+	lines := p.lineRanges(s, w)
+	n := minInt(len(lines), int(h/lh+1e-9))
+	auto := p.autoPageBreak
+	p.autoPageBreak = false
+	for i := 0; i < n; i++ {
+		r := lines[i]
+		line := r.text(s)
+		lineAlign := align
+		if align == "J" {
+			lineAlign = "L"
+			if spaces := strings.Count(line, " "); spaces > 0 && r.end < len(s) && s[r.end] != '\n' {
+				p.ws = (w - 2*p.cMargin - p.GetStringWidth(line)) / float64(spaces)
+				p.out(sprintf("%.3F Tw", p.ws*p.k))
+			}
+		}
+		p.SetXY(x, y+float64(i)*lh)
+		p.Cell(w, lh, line, 0, 0, lineAlign, false, "")
+		if p.ws > 0 {
+			p.ws = 0
+			p.out("0 Tw")
+		}
+	}
+	p.autoPageBreak = auto
+	p.SetXY(x, y+float64(n)*lh)
+	if n < len(lines) {
+		return s[lines[n].start:]
+	}
+	return ""
+}
+
 // Write prints text from the current position.
 func (p *Fpdf) Write(h float64, txt string, link interface{}) {
 	if p.currentFont == nil {
@@ -751,7 +1967,7 @@ func (p *Fpdf) Write(h float64, txt string, link interface{}) {
 		if c == ' ' {
 			sep = i
 		}
-		l += p.charWidth(c)
+		l += p.charWidthAt(s, i)
 		if float64(l) > wmax {
 			if sep == -1 {
 				if p.x > p.lMargin {
@@ -764,7 +1980,7 @@ func (p *Fpdf) Write(h float64, txt string, link interface{}) {
 					continue
 				}
 				if i == j {
-					i++
+					i += p.charLen(s, i)
 				}
 				p.Cell(w, h, s[j:i], 0, 2, "", false, link)
 			} else {
@@ -789,49 +2005,326 @@ func (p *Fpdf) Write(h float64, txt string, link interface{}) {
 	}
 }
 
-// Image inserts an image into the document.
-func (p *Fpdf) Image(file string, x, y, w, h float64, typ string, link interface{}) {
-	if file == "" {
-		p.panicError("image file name is empty")
+// TextRun is a piece of text printed by WriteColored in its own color and
+// style.
+type TextRun struct {
+	// Text is the text of the run. A newline starts a new line.
+	Text string
+	// Color is the RGB color (0-255) of the text.
+	Color [3]int
+	// Style combines "B", "I", "U" and "S" like the style of SetFont. It
+	// applies to the current font family and size.
+	Style string
+}
+
+// WriteColored prints runs of text one after the other from the current
+// position, like successive calls to Write, each in its own color and style.
+// Lines are wrapped at the spaces of all the runs together, so a word split
+// across runs is kept whole unless it is wider than a line, in which case it
+// is broken between characters. The font style and text color in effect
+// before the call are restored.
+func (p *Fpdf) WriteColored(h float64, runs []TextRun) {
+	if p.currentFont == nil {
+		p.panicError("no font has been set")
 	}
-	info, ok := p.images[file]
-	if !ok {
-		if typ == "" {
-			ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(file), "."))
-			if ext == "" {
-				p.panicError("image file has no extension and no type was specified: " + file)
+	style := p.fontStyle
+	if p.underline {
+		style += "U"
+	}
+	if p.strikeout {
+		style += "S"
+	}
+	textColor := p.textColor
+
+	// Words are made of the pieces of consecutive runs that are not
+	// separated by a space or a line break, which are words of their own.
+	type piece struct {
+		run  int
+		text string
+		w    float64
+	}
+	var words [][]piece
+	glue := false
+	for i, r := range runs {
+		p.SetFont("", r.Style, 0)
+		for _, t := range splitWords(strings.ReplaceAll(r.Text, "\r", "")) {
+			pc := piece{run: i, text: t, w: p.GetStringWidth(t)}
+			if glue && t != " " && t != "\n" {
+				words[len(words)-1] = append(words[len(words)-1], pc)
+				continue
 			}
-			typ = ext
-		}
-		typ = strings.ToLower(typ)
-		if typ == "jpeg" {
-			typ = "jpg"
+			words = append(words, []piece{pc})
+			glue = t != " " && t != "\n"
 		}
-		switch typ {
-		case "jpg", "png", "gif":
-			info = p.parseImageFile(file)
-		default:
-			p.panicError("unsupported image type: " + typ)
-		}
-		info.i = len(p.images) + 1
-		p.images[file] = info
 	}
 
-	if w == 0 && h == 0 {
-		w = -96
-		h = -96
-	}
-	if w < 0 {
-		w = -float64(info.w) * 72 / w / p.k
-	}
-	if h < 0 {
-		h = -float64(info.h) * 72 / h / p.k
-	}
-	if w == 0 {
-		w = h * float64(info.w) / float64(info.h)
+	// Pieces of the same run printed next to each other share one cell.
+	cur := piece{run: -1}
+	flush := func() {
+		if cur.text != "" {
+			r := runs[cur.run]
+			p.SetFont("", r.Style, 0)
+			p.SetTextColor(float64(r.Color[0]), float64(r.Color[1]), float64(r.Color[2]))
+			p.Cell(cur.w, h, cur.text, 0, 0, "", false, "")
+		}
+		cur = piece{run: -1}
+	}
+	add := func(pc piece) {
+		if pc.run != cur.run {
+			flush()
+			cur = pc
+			return
+		}
+		cur.text += pc.text
+		cur.w += pc.w
+	}
+	limit := p.w - p.rMargin - 2*p.cMargin
+	var spaces []piece
+	for _, word := range words {
+		switch word[0].text {
+		case "\n":
+			flush()
+			spaces = nil
+			p.Ln(h)
+			continue
+		case " ":
+			if p.x > p.lMargin || cur.text != "" {
+				spaces = append(spaces, word[0])
+			}
+			continue
+		}
+		w := 0.0
+		for _, pc := range append(spaces, word...) {
+			w += pc.w
+		}
+		if (p.x > p.lMargin || cur.text != "") && p.x+cur.w+w > limit {
+			flush()
+			spaces = nil
+			p.Ln(h)
+		}
+		for _, pc := range append(spaces, word...) {
+			if p.x+cur.w+pc.w <= limit {
+				add(pc)
+				continue
+			}
+			// Only a word wider than a whole line gets here. It is broken
+			// between characters, as Write does.
+			for i := 0; i < len(pc.text); {
+				n := p.charLen(pc.text, i)
+				p.SetFont("", runs[pc.run].Style, 0)
+				c := piece{run: pc.run, text: pc.text[i : i+n], w: p.GetStringWidth(pc.text[i : i+n])}
+				if (p.x > p.lMargin || cur.text != "") && p.x+cur.w+c.w > limit {
+					flush()
+					p.Ln(h)
+				}
+				add(c)
+				i += n
+			}
+		}
+		spaces = nil
+	}
+	flush()
+	p.SetFont("", style, 0)
+	p.textColor = textColor
+	p.colorFlag = p.fillColor != p.textColor
+}
+
+// superscriptRe matches the exponents (group 1 or 2, e.g. "m^2" or
+// "x^{10}") and ordinal suffixes (group 4, after the number of group 3)
+// printed raised by WriteWithSuperscripts.
+var superscriptRe = regexp.MustCompile(`\^(?:\{([^}]*)\}|(-?[0-9A-Za-z]+))|\b([0-9]+)(st|nd|rd|th)\b`)
+
+// WriteWithSuperscripts prints text like Write, raising exponents written
+// "^2" or "^{10}" and the suffixes of ordinals such as "1st" as superscripts
+// in a smaller size. The "^" and braces are not printed. The patterns that
+// are recognized are chosen with SetSuperscriptPatterns.
+func (p *Fpdf) WriteWithSuperscripts(h float64, txt string) {
+	if p.currentFont == nil {
+		p.panicError("no font has been set")
+	}
+	pos := 0
+	for _, m := range superscriptRe.FindAllStringSubmatchIndex(txt, -1) {
+		var start, sup string
+		switch {
+		case m[2] >= 0 && !p.noExponents:
+			sup = txt[m[2]:m[3]]
+		case m[4] >= 0 && !p.noExponents:
+			sup = txt[m[4]:m[5]]
+		case m[6] >= 0 && !p.noOrdinals:
+			start, sup = txt[m[6]:m[7]], txt[m[8]:m[9]]
+		default:
+			continue
+		}
+		p.Write(h, txt[pos:m[0]]+start, "")
+		p.writeSuperscript(h, sup)
+		pos = m[1]
+	}
+	if pos < len(txt) {
+		p.Write(h, txt[pos:], "")
+	}
+}
+
+// writeSuperscript prints txt raised, in a smaller size.
+func (p *Fpdf) writeSuperscript(h float64, txt string) {
+	if txt == "" {
+		return
+	}
+	p.SetSuperscript(true)
+	p.Write(h, txt, "")
+	p.SetSuperscript(false)
+}
+
+// SetSuperscript starts printing text as a superscript, raised and at 70% of
+// the current size, when on is true. When it is false, it ends the latest
+// superscript or subscript, restoring the size and baseline in effect before
+// it. Scripts nest, so a superscript of a superscript is raised further.
+func (p *Fpdf) SetSuperscript(on bool) {
+	p.setScript(on, 0.35)
+}
+
+// SetSubscript starts printing text as a subscript, lowered and at 70% of the
+// current size, when on is true. When it is false, it ends the latest
+// superscript or subscript like SetSuperscript(false).
+func (p *Fpdf) SetSubscript(on bool) {
+	p.setScript(on, -0.2)
+}
+
+// setScript starts a script whose baseline is shifted by shift times the
+// current font size, or ends the latest one.
+func (p *Fpdf) setScript(on bool, shift float64) {
+	if !on {
+		n := len(p.scripts)
+		if n == 0 {
+			return
+		}
+		sc := p.scripts[n-1]
+		p.scripts = p.scripts[:n-1]
+		p.SetFontSize(sc.sizePt)
+		p.SetTextRise(sc.rise)
+		return
+	}
+	p.scripts = append(p.scripts, pdfScript{sizePt: p.fontSizePt, rise: p.rise})
+	p.SetTextRise(p.rise + shift*p.fontSize)
+	p.SetFontSize(p.fontSizePt * 0.7)
+}
+
+// SetSuperscriptPatterns chooses the patterns WriteWithSuperscripts raises:
+// exponents written with "^" and ordinal suffixes. Both are on by default.
+func (p *Fpdf) SetSuperscriptPatterns(exponents, ordinals bool) {
+	p.noExponents = !exponents
+	p.noOrdinals = !ordinals
+}
+
+// SetTextRise moves the baseline of the text printed from now on up by rise
+// user units, or down when it is negative. 0 restores the normal baseline.
+func (p *Fpdf) SetTextRise(rise float64) {
+	p.rise = rise
+	if p.page > 0 {
+		p.out(sprintf("BT %.2F Ts ET", rise*p.k))
+	}
+}
+
+// AddImagePage adds a page of the default size showing the image key (a file
+// name as passed to Image), as when turning scans into a document. The page
+// is landscape for images wider than tall and portrait otherwise. fit is
+// "contain" (the default when empty) to show the whole image centered,
+// "cover" to fill the page and crop what overflows, or "stretch" to fill the
+// page without keeping the aspect ratio.
+func (p *Fpdf) AddImagePage(key string, fit string) {
+	if fit != "" && fit != "contain" && fit != "cover" && fit != "stretch" {
+		p.panicError("incorrect image fit: " + fit)
+	}
+	iw, ih := p.registerImage(key, "").size()
+	orientation := "P"
+	if iw > ih {
+		orientation = "L"
+	}
+	p.AddPage(orientation, "", 0)
+	switch fit {
+	case "", "contain":
+		scale := math.Min(p.w/iw, p.h/ih)
+		w, h := iw*scale, ih*scale
+		p.Image(key, (p.w-w)/2, (p.h-h)/2, w, h, "", nil)
+	case "cover":
+		scale := math.Max(p.w/iw, p.h/ih)
+		w, h := iw*scale, ih*scale
+		p.out(sprintf("q 0 0 %.2F %.2F re W n", p.wPt, p.hPt))
+		p.Image(key, (p.w-w)/2, (p.h-h)/2, w, h, "", nil)
+		p.out("Q")
+	case "stretch":
+		p.Image(key, 0, 0, p.w, p.h, "", nil)
+	}
+}
+
+// ContactSheet lays out the images keys (file names as passed to Image) in a
+// grid of cols by rows cells separated by gap user units, on as many new
+// pages as needed. Each image is scaled to fit its cell, keeping its aspect
+// ratio. When a font is set, the file name is printed centered under each
+// image.
+func (p *Fpdf) ContactSheet(keys []string, cols, rows int, gap float64) {
+	if cols < 1 || rows < 1 {
+		p.panicError("contact sheet needs at least one column and one row")
+	}
+	lh := 0.0
+	if p.currentFont != nil {
+		lh = p.fontSize * 1.5
+	}
+	auto := p.autoPageBreak
+	p.autoPageBreak = false
+	for i, key := range keys {
+		if i%(cols*rows) == 0 {
+			p.AddPage(p.curOrientation, "", p.curRotation)
+		}
+		cw := (p.w - p.lMargin - p.rMargin - float64(cols-1)*gap) / float64(cols)
+		ch := (p.h - p.tMargin - p.bMargin - float64(rows-1)*gap) / float64(rows)
+		n := i % (cols * rows)
+		x := p.lMargin + float64(n%cols)*(cw+gap)
+		y := p.tMargin + float64(n/cols)*(ch+gap)
+		iw, ih := p.registerImage(key, "").size()
+		scale := math.Min(cw/iw, (ch-lh)/ih)
+		w, h := iw*scale, ih*scale
+		p.Image(key, x+(cw-w)/2, y+(ch-lh-h)/2, w, h, "", nil)
+		if lh > 0 {
+			p.SetXY(x, y+ch-lh)
+			p.Cell(cw, lh, filepath.Base(key), 0, 0, "C", false, "")
+		}
+	}
+	p.autoPageBreak = auto
+}
+
+// ImageAtDPI places the image key (a file name as passed to Image) with its
+// upper-left corner at (x, y), sized so that its pixels print at the given
+// resolution in dots per inch.
+func (p *Fpdf) ImageAtDPI(key string, x, y float64, dpi float64) {
+	if dpi <= 0 {
+		p.panicError("image resolution must be positive")
+	}
+	p.Image(key, x, y, -dpi, -dpi, "", nil)
+}
+
+// Image inserts an image into the document.
+func (p *Fpdf) Image(file string, x, y, w, h float64, typ string, link interface{}) {
+	info, err := p.tryRegisterImage(file, typ)
+	if err != nil {
+		p.imageFailed(err, x, y, w, h)
+		return
+	}
+	iw, ih := info.size()
+	if w == 0 && h == 0 {
+		w = -96
+		h = -96
+	}
+	if w < 0 {
+		w = -iw * 72 / w / p.k
+	}
+	if h < 0 {
+		h = -ih * 72 / h / p.k
+	}
+	if w == 0 {
+		w = h * iw / ih
 	}
 	if h == 0 {
-		h = w * float64(info.h) / float64(info.w)
+		h = w * ih / iw
 	}
 	if math.IsNaN(y) {
 		if p.y+h > p.pageBreakTrigger && !p.inHeader && !p.inFooter && p.AcceptPageBreak() {
@@ -851,6 +2344,530 @@ func (p *Fpdf) Image(file string, x, y, w, h float64, typ string, link interface
 	}
 }
 
+// SetImageErrorMode sets what Image does with an image that cannot be loaded:
+// "panic" (the default) aborts the document, "skip" leaves the image out and
+// "placeholder" draws a gray box labeled "image" in its place. In the last
+// two modes the failure is recorded and reported by Error.
+func (p *Fpdf) SetImageErrorMode(mode string) {
+	switch mode {
+	case "panic", "skip", "placeholder":
+		p.imageErrorMode = mode
+	default:
+		p.panicError("incorrect image error mode: " + mode)
+	}
+}
+
+// SetMaxImageDimension makes images registered from now on that are wider or
+// taller than px pixels be downscaled to fit within px by px pixels, keeping
+// their aspect ratio. 0, the default, embeds images at their full size.
+func (p *Fpdf) SetMaxImageDimension(px int) {
+	if px < 0 {
+		p.panicError("incorrect maximum image dimension: " + strconv.Itoa(px))
+	}
+	p.maxImageDim = px
+}
+
+// imagePlaceholder draws the box standing for an image that failed to load.
+// Missing dimensions default to one inch, or to the other dimension.
+func (p *Fpdf) imagePlaceholder(x, y, w, h float64) {
+	if w <= 0 && h <= 0 {
+		w, h = 72/p.k, 72/p.k
+	} else if w <= 0 {
+		w = h
+	} else if h <= 0 {
+		h = w
+	}
+	if math.IsNaN(y) {
+		if p.y+h > p.pageBreakTrigger && !p.inHeader && !p.inFooter && p.AcceptPageBreak() {
+			x2 := p.x
+			p.AddPage(p.curOrientation, "", p.curRotation)
+			p.x = x2
+		}
+		y = p.y
+		p.y += h
+	}
+	if math.IsNaN(x) {
+		x = p.x
+	}
+	out := sprintf("q 0.850 g 0.500 G %.2F %.2F %.2F %.2F re B", x*p.k, (p.h-y)*p.k, w*p.k, -h*p.k)
+	if p.currentFont != nil {
+		tw := p.GetStringWidth("image")
+		out += sprintf(" 0.400 g BT %.2F %.2F Td %s ET", (x+(w-tw)/2)*p.k, (p.h-(y+h/2+0.3*p.fontSize))*p.k, p.showText("image"))
+	}
+	p.out(out + " Q")
+}
+
+// Bookmark records a bookmark pointing at position y of the current page (the
+// current position if y is negative). level gives its depth, 0 being the top
+// level, and may exceed the level of the previous bookmark by one at most.
+// Bookmarks make up the document outline shown by viewers and are listed by
+// WriteTableOfContents.
+func (p *Fpdf) Bookmark(text string, level int, y float64) {
+	if p.page == 0 {
+		p.panicError("no page has been added")
+	}
+	if level < 0 {
+		p.panicError("bookmark level must not be negative")
+	}
+	if y < 0 {
+		y = p.y
+	}
+	p.outlines = append(p.outlines, pdfOutline{text: text, level: level, y: y, page: p.page})
+}
+
+// WriteTableOfContents adds a page headed by title listing the bookmarks
+// recorded so far, indented by level, each followed by dot leaders and its
+// page number and linked to its destination. As page numbers are only known
+// once the pages are laid out, call it after the content, typically at the
+// end of the document. It uses the current font. Use WriteTableOfContentsAt
+// to place the table before the content.
+func (p *Fpdf) WriteTableOfContents(title string) {
+	p.writeTableOfContents(title, func(page int) int { return page })
+}
+
+// WriteTableOfContentsAt is like WriteTableOfContents, but the pages of the
+// table are then moved to become page number page and the following ones,
+// e.g. 2 to put the table right after a cover page. The pages from there on
+// move back, and the page numbers listed, links and bookmarks follow them;
+// page numbers already printed by headers and footers do not. The last page
+// of the table is finished, so content can only be added again after the
+// next AddPage.
+func (p *Fpdf) WriteTableOfContentsAt(title string, page int) {
+	if p.state == 3 {
+		p.panicError("the document is closed")
+	}
+	if page < 1 || page > p.page+1 {
+		p.panicError("invalid page: " + strconv.Itoa(page))
+	}
+	// The numbers listed depend on the length of the table, which is only
+	// known once it is written: assume one page and write it again if
+	// needed.
+	first, n := p.page+1, 1
+	for {
+		p.writeTableOfContents(title, func(o int) int {
+			if o >= page {
+				return o + n
+			}
+			return o
+		})
+		if p.page-first+1 == n {
+			break
+		}
+		n = p.page - first + 1
+		for i := first; i <= p.page; i++ {
+			delete(p.pages, i)
+			delete(p.pageInfo, i)
+			delete(p.pageLinks, i)
+		}
+		p.page = first - 1
+		p.state = 1
+	}
+	p.inFooter = true
+	p.Footer()
+	p.inFooter = false
+	p.endPage()
+	p.renumberPages(func(o int) int {
+		switch {
+		case o >= first:
+			return page + o - first
+		case o >= page:
+			return o + n
+		}
+		return o
+	})
+}
+
+// writeTableOfContents adds the table of contents, listing the bookmarks
+// with the page numbers returned by number.
+func (p *Fpdf) writeTableOfContents(title string, number func(page int) int) {
+	if p.currentFont == nil {
+		p.panicError("no font has been set")
+	}
+	family, style, size := p.fontFamily, p.fontStyle, p.fontSizePt
+	if p.underline {
+		style += "U"
+	}
+	if p.strikeout {
+		style += "S"
+	}
+	p.AddPage(p.curOrientation, "", p.curRotation)
+	if title != "" {
+		p.SetFont(family, "B", size*1.5)
+		p.Cell(0, p.fontSize*1.5, title, 0, 1, "C", false, "")
+		p.Ln(p.fontSize)
+	}
+	p.SetFont(family, "", size)
+	h := p.fontSize * 1.5
+	dot := p.GetStringWidth(".")
+	for _, o := range p.outlines {
+		if p.y+h > p.pageBreakTrigger {
+			p.AddPage(p.curOrientation, "", p.curRotation)
+		}
+		link := p.AddLink()
+		p.SetLink(link, o.y, o.page)
+		indent := float64(o.level) * 2 * p.fontSize
+		width := p.w - p.lMargin - p.rMargin - indent
+		num := strconv.Itoa(number(o.page))
+		numW := p.GetStringWidth(num) + 2*p.cMargin
+		text := o.text
+		textW := p.GetStringWidth(text) + 2*p.cMargin
+		y := p.y
+		p.SetX(p.lMargin + indent)
+		p.Cell(textW, h, text, 0, 0, "", false, "")
+		if n := int((width - textW - numW) / dot); n > 0 {
+			p.Cell(width-textW-numW, h, strings.Repeat(".", n), 0, 0, "R", false, "")
+		} else {
+			p.SetX(p.lMargin + indent + width - numW)
+		}
+		p.Cell(numW, h, num, 0, 1, "R", false, "")
+		p.Link(p.lMargin+indent, y, width, h, link)
+	}
+	p.SetFont(family, style, size)
+}
+
+// renumberPages moves every page to the number given by move, which must be
+// a permutation of the page numbers, along with the links, bookmarks and
+// thumbnails referring to it.
+func (p *Fpdf) renumberPages(move func(page int) int) {
+	pages := make(map[int][]string, len(p.pages))
+	info := make(map[int]map[string]interface{}, len(p.pageInfo))
+	pageLinks := make(map[int][][]interface{}, len(p.pageLinks))
+	for n := 1; n <= p.page; n++ {
+		pages[move(n)] = p.pages[n]
+		if v, ok := p.pageInfo[n]; ok {
+			info[move(n)] = v
+		}
+		if v, ok := p.pageLinks[n]; ok {
+			pageLinks[move(n)] = v
+		}
+	}
+	p.pages, p.pageInfo, p.pageLinks = pages, info, pageLinks
+	for id, l := range p.links {
+		if l[0] > 0 {
+			p.links[id] = [2]float64{float64(move(int(l[0]))), l[1]}
+		}
+	}
+	for i := range p.outlines {
+		p.outlines[i].page = move(p.outlines[i].page)
+	}
+	if len(p.thumbnails) > 0 {
+		thumbnails := make(map[int]int, len(p.thumbnails))
+		for n := range p.thumbnails {
+			thumbnails[move(n)] = 0
+		}
+		p.thumbnails = thumbnails
+		re := regexp.MustCompile(`/PG(\d+) Do`)
+		for _, ops := range p.pages {
+			for i, op := range ops {
+				ops[i] = re.ReplaceAllStringFunc(op, func(m string) string {
+					n, _ := strconv.Atoi(m[3 : len(m)-3])
+					return "/PG" + strconv.Itoa(move(n)) + " Do"
+				})
+			}
+		}
+	}
+}
+
+// SignatureLine draws a horizontal line of width w starting at (x, y) and
+// prints label (e.g. "Signature" or "Date") below it in an 8 point font,
+// without an automatic page break. The current font and position are restored
+// afterwards.
+func (p *Fpdf) SignatureLine(x, y, w float64, label string) {
+	p.Line(x, y, x+w, y)
+	if label == "" {
+		return
+	}
+	if p.currentFont == nil {
+		p.panicError("no font has been set")
+	}
+	family, style, size := p.fontFamily, p.fontStyle, p.fontSizePt
+	if p.underline {
+		style += "U"
+	}
+	if p.strikeout {
+		style += "S"
+	}
+	cx, cy := p.x, p.y
+	auto := p.autoPageBreak
+	p.autoPageBreak = false
+	p.SetFont(family, "", 8)
+	p.SetXY(x, y)
+	p.Cell(w, p.fontSize*1.5, label, 0, 0, "L", false, "")
+	p.SetFont(family, style, size)
+	p.SetXY(cx, cy)
+	p.autoPageBreak = auto
+}
+
+// DrawCropMarks draws crop marks at the corners of the trim box of the current
+// page, set with SetPageBox, and registration marks at the middle of its
+// sides. The marks are placed outside the trim box, bleed user units away
+// from it, so the media box must leave room for them.
+func (p *Fpdf) DrawCropMarks(bleed float64) {
+	boxes, _ := p.pageInfo[p.page]["boxes"].(map[string][4]float64)
+	trim, ok := boxes["TrimBox"]
+	if !ok {
+		p.panicError("crop marks need a trim box on the current page")
+	}
+	off := bleed * p.k
+	l := 12.0
+	x1, y1, x2, y2 := trim[0], trim[1], trim[2], trim[3]
+	s := "q 0.25 w 0 J [] 0 d 0 G"
+	for _, c := range [][2]float64{{x1, y1}, {x2, y1}, {x1, y2}, {x2, y2}} {
+		dx, dy := -1.0, -1.0
+		if c[0] == x2 {
+			dx = 1
+		}
+		if c[1] == y2 {
+			dy = 1
+		}
+		s += sprintf(" %.2F %.2F m %.2F %.2F l S", c[0]+dx*off, c[1], c[0]+dx*(off+l), c[1])
+		s += sprintf(" %.2F %.2F m %.2F %.2F l S", c[0], c[1]+dy*off, c[0], c[1]+dy*(off+l))
+	}
+	r := l / 3
+	for _, c := range [][2]float64{{(x1 + x2) / 2, y2 + off + l/2}, {(x1 + x2) / 2, y1 - off - l/2}, {x1 - off - l/2, (y1 + y2) / 2}, {x2 + off + l/2, (y1 + y2) / 2}} {
+		s += " " + circlePath(c[0], c[1], r) + " S"
+		s += sprintf(" %.2F %.2F m %.2F %.2F l %.2F %.2F m %.2F %.2F l S", c[0]-l/2, c[1], c[0]+l/2, c[1], c[0], c[1]-l/2, c[0], c[1]+l/2)
+	}
+	p.out(s + " Q")
+}
+
+// circlePath returns the path operators of a circle of radius r centered on
+// (x, y), in points.
+func circlePath(x, y, r float64) string {
+	k := 0.5523 * r
+	return sprintf("%.2F %.2F m %.2F %.2F %.2F %.2F %.2F %.2F c %.2F %.2F %.2F %.2F %.2F %.2F c %.2F %.2F %.2F %.2F %.2F %.2F c %.2F %.2F %.2F %.2F %.2F %.2F c",
+		x+r, y,
+		x+r, y+k, x+k, y+r, x, y+r,
+		x-k, y+r, x-r, y+k, x-r, y,
+		x-r, y-k, x-k, y-r, x, y-r,
+		x+k, y-r, x+r, y-k, x+r, y)
+}
+
+// Barcode128 draws text as a Code 128 (code set B) barcode in the w by h
+// rectangle whose upper-left corner is at (x, y). Only printable ASCII
+// characters can be encoded. When link is a URL or an internal link id, the
+// barcode area is also made clickable.
+func (p *Fpdf) Barcode128(x, y, w, h float64, code string, link interface{}) {
+	values := []int{104}
+	for i := 0; i < len(code); i++ {
+		c := code[i]
+		if c < 32 || c > 127 {
+			p.panicError("invalid character in Code 128 barcode: " + code)
+		}
+		values = append(values, int(c)-32)
+	}
+	sum := values[0]
+	for i := 1; i < len(values); i++ {
+		sum += i * values[i]
+	}
+	values = append(values, sum%103)
+	pattern := ""
+	for _, v := range values {
+		pattern += code128Patterns[v]
+	}
+	pattern += "2331112"
+	modules := 0
+	for i := 0; i < len(pattern); i++ {
+		modules += int(pattern[i] - '0')
+	}
+	mw := w / float64(modules)
+	var b strings.Builder
+	b.WriteString("q 0 g ")
+	xp := x
+	for i := 0; i < len(pattern); i++ {
+		bw := float64(pattern[i]-'0') * mw
+		if i%2 == 0 {
+			b.WriteString(sprintf("%.2F %.2F %.2F %.2F re ", xp*p.k, (p.h-y)*p.k, bw*p.k, -h*p.k))
+		}
+		xp += bw
+	}
+	b.WriteString("f Q")
+	p.out(b.String())
+	if link != "" && link != nil {
+		p.Link(x, y, w, h, link)
+	}
+}
+
+// PageThumbnail draws a scaled-down copy of an earlier page in the w by h
+// rectangle at (x, y), turned clockwise by rotation degrees (a multiple of 90)
+// on top of the rotation of the source page. If w or h is zero it is computed
+// from the aspect ratio of the page as displayed. The page content is embedded
+// once as a form XObject, however many thumbnails of it are drawn.
+func (p *Fpdf) PageThumbnail(sourcePage int, x, y, w, h float64, rotation int) {
+	if sourcePage < 1 || sourcePage >= p.page {
+		p.panicError("thumbnail source must be an earlier page: " + strconv.Itoa(sourcePage))
+	}
+	if rotation%90 != 0 {
+		p.panicError("incorrect rotation value: " + strconv.Itoa(rotation))
+	}
+	if rot, ok := p.pageInfo[sourcePage]["rotation"].(int); ok {
+		rotation += rot
+	}
+	rotation = (rotation%360 + 360) % 360
+	sz := p.pageSizePt(sourcePage)
+	dw, dh := sz[0], sz[1]
+	if rotation == 90 || rotation == 270 {
+		dw, dh = dh, dw
+	}
+	if w == 0 && h == 0 {
+		w = dw / p.k / 4
+	}
+	if w == 0 {
+		w = h * dw / dh
+	}
+	if h == 0 {
+		h = w * dh / dw
+	}
+	p.thumbnails[sourcePage] = 0
+	sx, sy := w*p.k/dw, h*p.k/dh
+	x0, y0, x1, y1 := x*p.k, (p.h-(y+h))*p.k, (x+w)*p.k, (p.h-y)*p.k
+	var m [6]float64
+	switch rotation {
+	case 0:
+		m = [6]float64{sx, 0, 0, sy, x0, y0}
+	case 90:
+		m = [6]float64{0, -sy, sx, 0, x0, y1}
+	case 180:
+		m = [6]float64{-sx, 0, 0, -sy, x1, y1}
+	case 270:
+		m = [6]float64{0, sy, -sx, 0, x1, y0}
+	}
+	p.out(sprintf("q %.5F %.5F %.5F %.5F %.2F %.2F cm /PG%d Do Q", m[0], m[1], m[2], m[3], m[4], m[5], sourcePage))
+}
+
+// DefineSymbol records the drawing done by draw as a reusable symbol called
+// name, to be stamped with PlaceSymbol. draw uses the usual drawing methods
+// with coordinates relative to the symbol origin at (0, 0). Nothing is
+// printed on the current page, and the position, colors, line width and font
+// in effect before the call are restored afterwards.
+func (p *Fpdf) DefineSymbol(name string, draw func()) {
+	if name == "" {
+		p.panicError("symbol name is empty")
+	}
+	if p.symbol(name) > 0 {
+		p.panicError("symbol already defined: " + name)
+	}
+	if p.state != 2 {
+		p.panicError("no page has been added yet")
+	}
+	page, start := p.page, len(p.pages[p.page])
+	x, y, auto := p.x, p.y, p.autoPageBreak
+	drawColor, fillColor, textColor, colorFlag, lineWidth := p.drawColor, p.fillColor, p.textColor, p.colorFlag, p.lineWidth
+	family, style, sizePt, size, font := p.fontFamily, p.fontStyle, p.fontSizePt, p.fontSize, p.currentFont
+	underline, strikeout, ws := p.underline, p.strikeout, p.ws
+	p.autoPageBreak = false
+	draw()
+	if p.page != page {
+		p.panicError("symbol drawing must not add pages")
+	}
+	ops := p.pages[page][start:]
+	p.symbols = append(p.symbols, &pdfSymbol{name: name, data: []byte(strings.Join(ops, "\n")), wPt: p.wPt, hPt: p.hPt})
+	p.pages[page] = p.pages[page][:start]
+	p.x, p.y, p.autoPageBreak = x, y, auto
+	p.drawColor, p.fillColor, p.textColor, p.colorFlag, p.lineWidth = drawColor, fillColor, textColor, colorFlag, lineWidth
+	p.fontFamily, p.fontStyle, p.fontSizePt, p.fontSize, p.currentFont = family, style, sizePt, size, font
+	p.underline, p.strikeout, p.ws = underline, strikeout, ws
+}
+
+// PlaceSymbol stamps the symbol called name, defined with DefineSymbol, with
+// its origin at (x, y) and scaled by scale.
+func (p *Fpdf) PlaceSymbol(name string, x, y, scale float64) {
+	i := p.symbol(name)
+	if i == 0 {
+		p.panicError("undefined symbol: " + name)
+	}
+	p.out(sprintf("q %.5F 0 0 %.5F %.2F %.2F cm /SY%d Do Q", scale, scale, x*p.k, (p.h-y)*p.k, i))
+}
+
+// symbol returns the index of the symbol called name, or 0 if there is none.
+func (p *Fpdf) symbol(name string) int {
+	for i, sym := range p.symbols {
+		if sym.name == name {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// TileImage fills the w by h rectangle at (x, y) with copies of an image, each
+// tileW by tileH large, starting from the upper-left corner. key is the image
+// file name as passed to Image; the image is registered if needed. The whole
+// area is painted with a single tiling pattern instead of one image per tile.
+func (p *Fpdf) TileImage(key string, x, y, w, h, tileW, tileH float64) {
+	if tileW <= 0 || tileH <= 0 {
+		p.panicError("tile size must be positive")
+	}
+	p.registerImage(key, "")
+	p.tilings = append(p.tilings, &pdfTiling{image: key, w: tileW * p.k, h: tileH * p.k, ox: x * p.k, oy: (p.h - y - tileH) * p.k})
+	p.out(sprintf("q /Pattern cs /P%d scn %.2F %.2F %.2F %.2F re f Q", len(p.tilings), x*p.k, (p.h-y)*p.k, w*p.k, -h*p.k))
+}
+
+// LinearGradient paints the w by h rectangle at (x, y) with a gradient going
+// from color (r1, g1, b1) to color (r2, g2, b2) along the vector (x1, y1) to
+// (x2, y2). The vector is given in fractions of the rectangle, (0, 0) being
+// its top left corner and (1, 1) its bottom right corner, so 0, 0, 0, 1 gives
+// a vertical gradient. Painting is clipped to the rectangle.
+func (p *Fpdf) LinearGradient(x, y, w, h float64, r1, g1, b1, r2, g2, b2 int, x1, y1, x2, y2 float64) {
+	sh := p.gradient(r1, g1, b1, r2, g2, b2, [4]float64{x1, y1, x2, y2})
+	p.out(sprintf("q %.2F %.2F %.2F %.2F re W n", x*p.k, (p.h-y)*p.k, w*p.k, -h*p.k))
+	p.out(sprintf("%.5F 0 0 %.5F %.2F %.2F cm /Sh%d sh Q", w*p.k, -h*p.k, x*p.k, (p.h-y)*p.k, sh))
+}
+
+// gradient returns the number of the axial shading going from color (r1, g1,
+// b1) to color (r2, g2, b2) along coords, adding it unless an identical one
+// exists.
+func (p *Fpdf) gradient(r1, g1, b1, r2, g2, b2 int, coords [4]float64) int {
+	g := &pdfGradient{
+		c1:     [3]float64{float64(r1) / 255, float64(g1) / 255, float64(b1) / 255},
+		c2:     [3]float64{float64(r2) / 255, float64(g2) / 255, float64(b2) / 255},
+		coords: coords,
+	}
+	for i, old := range p.gradients {
+		if old.c1 == g.c1 && old.c2 == g.c2 && old.coords == g.coords {
+			return i + 1
+		}
+	}
+	p.gradients = append(p.gradients, g)
+	return len(p.gradients)
+}
+
+// AddGradientPattern defines a pattern painting a linear gradient from color
+// (r1, g1, b1) at (x1, y1) to color (r2, g2, b2) at (x2, y2) on the current
+// page, and returns its identifier for SetDrawPattern and SetFillPattern. The
+// gradient extends beyond its end points with their colors.
+func (p *Fpdf) AddGradientPattern(x1, y1, x2, y2 float64, r1, g1, b1, r2, g2, b2 int) int {
+	sh := p.gradient(r1, g1, b1, r2, g2, b2, [4]float64{x1 * p.k, (p.h - y1) * p.k, x2 * p.k, (p.h - y2) * p.k})
+	p.shadings = append(p.shadings, &pdfShadingPattern{gradient: sh})
+	return len(p.shadings)
+}
+
+// SetDrawPattern strokes the lines drawn from now on with the pattern id
+// returned by AddGradientPattern, instead of the draw color, until the next
+// call to SetDrawColor.
+func (p *Fpdf) SetDrawPattern(id int) {
+	if id < 1 || id > len(p.shadings) {
+		p.panicError("undefined pattern: " + strconv.Itoa(id))
+	}
+	p.drawColor = sprintf("/Pattern CS /SP%d SCN", id)
+	if p.page > 0 {
+		p.out(p.drawColor)
+	}
+}
+
+// SetFillPattern fills the shapes drawn from now on with the pattern id
+// returned by AddGradientPattern, instead of the fill color, until the next
+// call to SetFillColor.
+func (p *Fpdf) SetFillPattern(id int) {
+	if id < 1 || id > len(p.shadings) {
+		p.panicError("undefined pattern: " + strconv.Itoa(id))
+	}
+	p.fillColor = sprintf("/Pattern cs /SP%d scn", id)
+	p.colorFlag = p.fillColor != p.textColor
+	if p.page > 0 {
+		p.out(p.fillColor)
+	}
+}
+
 // Ln performs a line break.
 func (p *Fpdf) Ln(h float64) {
 	p.x = p.lMargin
@@ -861,45 +2878,481 @@ func (p *Fpdf) Ln(h float64) {
 	}
 }
 
-// GetStringWidth returns the width of a string in the current font.
-func (p *Fpdf) GetStringWidth(s string) float64 {
-	if p.currentFont == nil {
-		return 0
-	}
-	w := 0
-	for _, c := range []byte(s) {
-		w += p.currentFont.cw[c]
+// VSpace performs a line break of height h like Ln, except that it takes part
+// in automatic page breaking: if the space would go past the page break
+// trigger, a new page is added and the position is set to its top margin.
+func (p *Fpdf) VSpace(h float64) {
+	if p.y+h > p.pageBreakTrigger && !p.inHeader && !p.inFooter && p.AcceptPageBreak() {
+		p.AddPage(p.curOrientation, "", p.curRotation)
+		return
+	}
+	p.x = p.lMargin
+	p.y += h
+}
+
+// GetStringWidth returns the width of a string in the current font.
+func (p *Fpdf) GetStringWidth(s string) float64 {
+	if p.currentFont == nil {
+		return 0
+	}
+	w := 0
+	if p.currentFont.ttf != nil {
+		for _, r := range s {
+			w += p.ttfWidth(r)
+		}
+		return float64(w) * p.fontSize / 1000
+	}
+	b := []byte(p.currentFont.singleByteText(s))
+	kp := p.kernPairs()
+	for i, c := range b {
+		w += p.currentFont.cw[c]
+		if i > 0 && kp != nil {
+			w += kp[[2]byte{b[i-1], c}]
+		}
+	}
+	return float64(w) * p.fontSize / 1000
+}
+
+// GetStringWidthPt returns the width of a string in the current font in
+// points rather than user units.
+func (p *Fpdf) GetStringWidthPt(s string) float64 {
+	return p.GetStringWidth(s) * p.k
+}
+
+// CenterX returns the X position that centers an element of width w between
+// the left and right margins. When trim-aware centering is enabled with
+// SetTrimAwareCentering, the margins are measured from the edges of the trim
+// box of the current page instead of the page edges.
+func (p *Fpdf) CenterX(w float64) float64 {
+	if p.trimAware {
+		x, _, tw, _ := p.trimBox()
+		return x + p.lMargin + (tw-p.lMargin-p.rMargin-w)/2
+	}
+	return p.lMargin + (p.w-p.lMargin-p.rMargin-w)/2
+}
+
+// SetTrimAwareCentering makes CenterX center within the trim box set with
+// SetPageBox rather than within the whole page, for pages with bleed.
+func (p *Fpdf) SetTrimAwareCentering(enabled bool) {
+	p.trimAware = enabled
+}
+
+// TrimCenterX returns the X position of the center of the trim box of the
+// current page, or of the page itself if it has no trim box.
+func (p *Fpdf) TrimCenterX() float64 {
+	x, _, w, _ := p.trimBox()
+	return x + w/2
+}
+
+// TrimCenterY returns the Y position of the center of the trim box of the
+// current page, or of the page itself if it has no trim box.
+func (p *Fpdf) TrimCenterY() float64 {
+	_, y, _, h := p.trimBox()
+	return y + h/2
+}
+
+// trimBox returns the trim box of the current page in user units, falling
+// back to the whole page.
+func (p *Fpdf) trimBox() (x, y, w, h float64) {
+	boxes, _ := p.pageInfo[p.page]["boxes"].(map[string][4]float64)
+	b, ok := boxes["TrimBox"]
+	if !ok {
+		return 0, 0, p.w, p.h
+	}
+	return b[0] / p.k, p.h - b[3]/p.k, (b[2] - b[0]) / p.k, (b[3] - b[1]) / p.k
+}
+
+// AddFont adds a font to the document.
+func (p *Fpdf) AddFont(family, style, file, dir string) {
+	p.AddFontWithEncoding(family, style, file, dir, "")
+}
+
+// AddUTF8Font adds a TrueType font read from file, for printing UTF-8 text
+// beyond the reach of the single-byte code pages. Text printed with the font
+// must be UTF-8 encoded. Unless subsetting is turned off with
+// SetFontSubsetting, only the glyphs actually printed are embedded. A
+// ToUnicode map lets the text be searched and copied.
+func (p *Fpdf) AddUTF8Font(family, style, file string) {
+	family = strings.ToLower(strings.TrimSpace(family))
+	style = strings.ToUpper(style)
+	if style == "IB" {
+		style = "BI"
+	}
+	fontkey := family + style
+	if _, ok := p.fonts[fontkey]; ok {
+		return
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		p.panicError("can't open font file: " + file)
+	}
+	t, err := parseTTF(data)
+	if err != nil {
+		p.panicError("incorrect font file " + file + ": " + err.Error())
+	}
+	up, ut := t.underline()
+	p.fonts[fontkey] = &pdfFont{typ: "UTF8", name: t.postName, up: up, ut: ut, file: file, ttf: t, i: len(p.fonts) + 1, subsetted: p.fontSubsetting}
+}
+
+// SetFontSubsetting sets whether the fonts added afterwards with AddUTF8Font
+// embed only the glyphs used, which is the default. Turn it off for fonts
+// that do not subset cleanly; the whole font file is then embedded.
+func (p *Fpdf) SetFontSubsetting(subset bool) { p.fontSubsetting = subset }
+
+// AddFontWithEncoding adds a font like AddFont, re-encoding it with the given
+// code page: "cp1250", "cp1252", "cp1254", "cp1257", "iso-8859-1",
+// "iso-8859-2" or "iso-8859-15". The core fonts have no Cyrillic or Greek
+// glyphs, so "cp1251" and "cp1253" are rejected. An empty encoding keeps the
+// font's own one. Text printed with the font must use the same code page.
+// A family and style can only be added with one encoding; to use a font with
+// another one, add it again under a different family name.
+func (p *Fpdf) AddFontWithEncoding(family, style, file, dir, encoding string) {
+	family = strings.ToLower(strings.TrimSpace(family))
+	if file == "" {
+		file = strings.ReplaceAll(family, " ", "") + strings.ToLower(style) + ".php"
+	}
+	style = strings.ToUpper(style)
+	if style == "IB" {
+		style = "BI"
+	}
+	fontkey := family + style
+	if f, ok := p.fonts[fontkey]; ok {
+		if enc := strings.ToLower(encoding); enc != "" && enc != f.enc {
+			p.panicError("font " + fontkey + " has already been added with encoding " + f.enc)
+		}
+		return
+	}
+	if strings.Contains(file, "/") || strings.Contains(file, "\\") {
+		p.panicError("incorrect font definition file name: " + file)
+	}
+	if dir == "" {
+		dir = p.fontpath
+	}
+	info, ok := p.loadFontAsset(file)
+	if !ok {
+		p.panicError("could not load embedded font definition: " + file)
+	}
+	clone := *info
+	clone.i = len(p.fonts) + 1
+	if enc := strings.ToLower(encoding); enc != "" && enc != clone.enc {
+		p.setFontEncoding(&clone, enc)
+	}
+	p.fonts[fontkey] = &clone
+}
+
+// setFontEncoding re-encodes a core font with a code page: the widths follow
+// the new glyph positions, the glyphs that differ from the font's base
+// encoding go to the /Differences array and the ToUnicode map is rebuilt.
+func (p *Fpdf) setFontEncoding(f *pdfFont, enc string) {
+	table, ok := codePages[enc]
+	if !ok {
+		p.panicError("unsupported font encoding: " + enc)
+	}
+	base, ok := codePages[f.enc]
+	if !ok {
+		p.panicError("font " + f.name + " cannot be re-encoded")
+	}
+	if f.typ == "Core" && (enc == "cp1251" || enc == "cp1253") {
+		p.panicError("core font " + f.name + " has no glyphs for encoding " + enc)
+	}
+	baseCode := map[rune]int{}
+	for i, r := range base {
+		if r != 0 {
+			baseCode[r] = 128 + i
+		}
+	}
+	cw := f.cw
+	uv := map[int]interface{}{0: pdfUVRange{start: 0, count: 128}}
+	var diff strings.Builder
+	last := -2
+	for i, r := range table {
+		c := 128 + i
+		if r == 0 {
+			continue
+		}
+		uv[c] = int(r)
+		if bc, ok := baseCode[r]; ok {
+			f.cw[c] = cw[bc]
+		} else if b, ok := glyphBase[r]; ok {
+			f.cw[c] = cw[b]
+		}
+		if glyphNames[r] == glyphNames[base[i]] {
+			continue
+		}
+		if c != last+1 {
+			diff.WriteString(strconv.Itoa(c) + " ")
+		}
+		diff.WriteString("/" + glyphNames[r] + " ")
+		last = c
+	}
+	f.enc = enc
+	f.uv = uv
+	f.diff = strings.TrimSpace(diff.String())
+}
+
+// SetKerning turns on or off the kerning of text set in the regular
+// Helvetica and Times core fonts, using the kerning pairs of their AFM
+// metrics. Other fonts and faces, including bold and italic Helvetica and
+// Times, are not kerned. Kerned text is written with TJ arrays and measured
+// accordingly by GetStringWidth.
+func (p *Fpdf) SetKerning(enabled bool) {
+	p.kerning = enabled
+}
+
+// kernPairs returns the kerning pairs that apply to the current font, or nil
+// when kerning is off or the font is not a core font in its standard encoding.
+func (p *Fpdf) kernPairs() map[[2]byte]int {
+	f := p.currentFont
+	if !p.kerning || f == nil || f.ttf != nil || f.enc != "cp1252" || f.diff != "" {
+		return nil
+	}
+	return coreKernPairs[f.name]
+}
+
+// loadFont returns the family, style and key of the font selected by family
+// and style, adding core fonts to the document on first use.
+func (p *Fpdf) loadFont(family, style string) (string, string, string) {
+	fontkey := family + style
+	if _, ok := p.fonts[fontkey]; !ok {
+		if family == "arial" {
+			family = "helvetica"
+		}
+		if containsString(p.coreFonts, family) {
+			if family == "symbol" || family == "zapfdingbats" {
+				style = ""
+			}
+			fontkey = family + style
+			if _, ok2 := p.fonts[fontkey]; !ok2 {
+				p.AddFont(family, style, "", "")
+			}
+		} else {
+			p.panicError("undefined font: " + family + " " + style)
+		}
+	}
+	return family, style, fontkey
+}
+
+// SetFontFallback appends a font to the fallback chain of the UTF-8 fonts
+// added with AddUTF8Font. Characters missing from the current UTF-8 font are
+// printed with the first font of the chain that has them: a UTF-8 font
+// holding their glyph or a core font whose encoding includes them. An empty
+// family clears the chain.
+func (p *Fpdf) SetFontFallback(family, style string) {
+	if family == "" {
+		p.fontFallbacks = nil
+		return
+	}
+	_, _, fontkey := p.loadFont(strings.ToLower(family), fontStyleKey(style))
+	p.fontFallbacks = append(p.fontFallbacks, fontkey)
+}
+
+// fontStyleKey returns the part of the key of a font given by style, which
+// leaves out underline and strikeout.
+func fontStyleKey(style string) string {
+	style = strings.NewReplacer("U", "", "S", "").Replace(strings.ToUpper(style))
+	if style == "IB" {
+		style = "BI"
+	}
+	return style
+}
+
+// MeasureTextWidth returns the width of txt in user units when set in the
+// font of the given family and style at sizePt points, without changing the
+// current font. Core fonts are added to the document if needed.
+func (p *Fpdf) MeasureTextWidth(family, style string, sizePt float64, txt string) float64 {
+	_, _, fontkey := p.loadFont(strings.ToLower(family), fontStyleKey(style))
+	font, size := p.currentFont, p.fontSize
+	p.currentFont, p.fontSize = p.fonts[fontkey], sizePt/p.k
+	w := p.GetStringWidth(txt)
+	p.currentFont, p.fontSize = font, size
+	return w
+}
+
+// fallbackFont returns the key of the fallback font printing r when the
+// current font is a UTF-8 font without a glyph for it, or an empty string.
+func (p *Fpdf) fallbackFont(r rune) string {
+	f := p.currentFont
+	if f == nil || f.ttf == nil || f.ttf.glyph(r) != 0 {
+		return ""
+	}
+	for _, key := range p.fontFallbacks {
+		fb := p.fonts[key]
+		if fb.ttf != nil {
+			if fb.ttf.glyph(r) != 0 {
+				return key
+			}
+		} else if _, ok := winAnsiByte(r); ok && fb.enc == "cp1252" {
+			return key
+		}
+	}
+	return ""
+}
+
+// ttfWidth returns the width of r in the current UTF-8 font, or in the
+// fallback font printing it.
+func (p *Fpdf) ttfWidth(r rune) int {
+	key := p.fallbackFont(r)
+	if key == "" {
+		return p.currentFont.ttf.width(r)
+	}
+	fb := p.fonts[key]
+	if fb.ttf != nil {
+		return fb.ttf.width(r)
+	}
+	c, _ := winAnsiByte(r)
+	return fb.cw[c]
+}
+
+// SetFontDifferences maps codes of the font fontKey (the family followed by
+// the style, e.g. "helveticaB") to other glyphs by name, e.g. 128 to "a12".
+// The font must have been added or selected already. The font encoding gets a
+// Differences array combining diffs with any re-encoding done by
+// AddFontWithEncoding. Glyph widths are left unchanged. A ToUnicode map of the
+// font follows the glyph names it knows; codes mapped to other glyphs, such
+// as dingbats, are left out of it.
+func (p *Fpdf) SetFontDifferences(fontKey string, diffs map[int]string) {
+	f, ok := p.fonts[fontKey]
+	if !ok {
+		p.panicError("undefined font: " + fontKey)
+	}
+	if f.ttf != nil {
+		p.panicError("UTF-8 fonts have no encoding differences: " + fontKey)
+	}
+	m := parseDifferences(f.diff)
+	// The map may be shared with the font definition, so it is copied.
+	uv := make(map[int]interface{}, len(f.uv))
+	for c, v := range f.uv {
+		uv[c] = v
+	}
+	for c, name := range diffs {
+		name = strings.TrimPrefix(name, "/")
+		if c < 0 || c > 255 || name == "" {
+			p.panicError("incorrect font difference for code " + strconv.Itoa(c))
+		}
+		m[c] = name
+		if len(uv) == 0 {
+			// Without a ToUnicode map, text is extracted by glyph name.
+			continue
+		}
+		for start, v := range uv {
+			if r, ok := v.(pdfUVRange); ok && c >= start && c < start+r.count {
+				delete(uv, start)
+				if c > start {
+					uv[start] = pdfUVRange{start: r.start, count: c - start}
+				}
+				if end := start + r.count; c+1 < end {
+					uv[c+1] = pdfUVRange{start: r.start + c + 1 - start, count: end - c - 1}
+				}
+				break
+			}
+		}
+		if r, ok := glyphRune(name); ok {
+			uv[c] = int(r)
+		} else {
+			delete(uv, c)
+		}
+	}
+	f.uv = uv
+	f.diff = formatDifferences(m)
+}
+
+// glyphRune returns the character named by a glyph name of the code pages or
+// of the form "uniXXXX" or "uXXXX".
+func glyphRune(name string) (rune, bool) {
+	if len(name) == 1 && (name[0] >= 'A' && name[0] <= 'Z' || name[0] >= 'a' && name[0] <= 'z') {
+		return rune(name[0]), true
+	}
+	for _, prefix := range []string{"uni", "u"} {
+		if hexCode, ok := strings.CutPrefix(name, prefix); ok && len(hexCode) >= 4 && len(hexCode) <= 6 {
+			if v, err := strconv.ParseUint(hexCode, 16, 32); err == nil && utf8.ValidRune(rune(v)) {
+				return rune(v), true
+			}
+		}
+	}
+	found := rune(-1)
+	for r, n := range glyphNames {
+		if n == name && (found < 0 || r < found) {
+			found = r
+		}
+	}
+	return found, found >= 0
+}
+
+// parseDifferences reads a Differences array body such as "128 /a /b 140 /c".
+func parseDifferences(diff string) map[int]string {
+	m := map[int]string{}
+	c := 0
+	for _, tok := range strings.Fields(diff) {
+		if strings.HasPrefix(tok, "/") {
+			m[c] = tok[1:]
+			c++
+		} else {
+			c, _ = strconv.Atoi(tok)
+		}
 	}
-	return float64(w) * p.fontSize / 1000
+	return m
 }
 
-// AddFont adds a font to the document.
-func (p *Fpdf) AddFont(family, style, file, dir string) {
-	family = strings.ToLower(strings.TrimSpace(family))
-	if file == "" {
-		file = strings.ReplaceAll(family, " ", "") + strings.ToLower(style) + ".php"
+// formatDifferences writes m as a Differences array body, codes in order and
+// runs of consecutive codes sharing a single number.
+func formatDifferences(m map[int]string) string {
+	codes := make([]int, 0, len(m))
+	for c := range m {
+		codes = append(codes, c)
 	}
-	style = strings.ToUpper(style)
-	if style == "IB" {
-		style = "BI"
+	sort.Ints(codes)
+	var b strings.Builder
+	last := -2
+	for _, c := range codes {
+		if c != last+1 {
+			b.WriteString(strconv.Itoa(c) + " ")
+		}
+		b.WriteString("/" + m[c] + " ")
+		last = c
 	}
-	fontkey := family + style
-	if _, ok := p.fonts[fontkey]; ok {
-		return
+	return strings.TrimSpace(b.String())
+}
+
+// DuplicatePage appends count copies of page sourcePage, with its content
+// and links, to the document. Internal links of the page pointing to the page
+// itself point to the copy in each copy. The current page is finished first.
+// The last copy becomes the current page but is complete as it is, so content
+// can only be added again after the next AddPage.
+func (p *Fpdf) DuplicatePage(sourcePage int, count int) {
+	if p.state == 3 {
+		p.panicError("the document is closed")
 	}
-	if strings.Contains(file, "/") || strings.Contains(file, "\\") {
-		p.panicError("incorrect font definition file name: " + file)
+	if sourcePage < 1 || sourcePage > p.page {
+		p.panicError("invalid page: " + strconv.Itoa(sourcePage))
 	}
-	if dir == "" {
-		dir = p.fontpath
+	if p.state == 2 {
+		p.inFooter = true
+		p.Footer()
+		p.inFooter = false
+		p.endPage()
 	}
-	info, ok := p.loadFontAsset(file)
-	if !ok {
-		p.panicError("could not load embedded font definition: " + file)
+	for i := 0; i < count; i++ {
+		p.page++
+		p.pages[p.page] = append([]string{}, p.pages[sourcePage]...)
+		if info, ok := p.pageInfo[sourcePage]; ok {
+			copied := make(map[string]interface{}, len(info))
+			for k, v := range info {
+				copied[k] = v
+			}
+			p.pageInfo[p.page] = copied
+		}
+		links := make([][]interface{}, 0, len(p.pageLinks[sourcePage]))
+		for _, pl := range p.pageLinks[sourcePage] {
+			pl = append([]interface{}{}, pl...)
+			if id, ok := pl[4].(int); ok && int(p.links[id][0]) == sourcePage {
+				link := p.AddLink()
+				p.SetLink(link, p.links[id][1], p.page)
+				pl[4] = link
+			}
+			links = append(links, pl)
+		}
+		p.pageLinks[p.page] = links
 	}
-	clone := *info
-	clone.i = len(p.fonts) + 1
-	p.fonts[fontkey] = &clone
 }
 
 // Close closes the document.
@@ -910,10 +3363,12 @@ func (p *Fpdf) Close() {
 	if p.page == 0 {
 		p.AddPage("", "", 0)
 	}
-	p.inFooter = true
-	p.Footer()
-	p.inFooter = false
-	p.endPage()
+	if p.state == 2 {
+		p.inFooter = true
+		p.Footer()
+		p.inFooter = false
+		p.endPage()
+	}
 	p.endDoc()
 }
 
@@ -937,17 +3392,127 @@ func (p *Fpdf) Output(dest, name string) (string, error) {
 	}
 }
 
+// OutputTo closes the document and writes it to w without copying it into a
+// string first. It returns any error from w.
+func (p *Fpdf) OutputTo(w io.Writer) error {
+	p.Close()
+	_, err := w.Write(p.buffer.Bytes())
+	return err
+}
+
+// OutputMulti closes the document once and writes it to each of writers, for
+// instance to a response and a cache file. Every writer receives the document
+// even if another fails; the first error is returned.
+func (p *Fpdf) OutputMulti(writers ...io.Writer) error {
+	p.Close()
+	var first error
+	for _, w := range writers {
+		if _, err := w.Write(p.buffer.Bytes()); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
 // AcceptPageBreak is called automatically when a page break is needed.
 func (p *Fpdf) AcceptPageBreak() bool { return p.autoPageBreak }
 
+// AddLink creates an internal link and returns its identifier, which can be
+// passed as the link of Cell, Write, Image or Link. Its destination is set
+// with SetLink.
+func (p *Fpdf) AddLink() int {
+	n := len(p.links) + 1
+	p.links[n] = [2]float64{}
+	return n
+}
+
+// SetLink sets the destination of an internal link created by AddLink:
+// position y (the current position if negative) of page (the current page
+// if 0 or negative).
+func (p *Fpdf) SetLink(link int, y float64, page int) {
+	if _, ok := p.links[link]; !ok {
+		p.panicError("undefined link: " + strconv.Itoa(link))
+	}
+	if y < 0 {
+		y = p.y
+	}
+	if page <= 0 {
+		page = p.page
+	}
+	p.links[link] = [2]float64{float64(page), y}
+}
+
 // Link adds a clickable link to the document.
 func (p *Fpdf) Link(x, y, w, h float64, link interface{}) {
 	p.pageLinks[p.page] = append(p.pageLinks[p.page], []interface{}{x * p.k, p.hPt - y*p.k, w * p.k, h * p.k, link})
 }
 
+// RegisterCMYKImage registers pre-separated CMYK pixel data under name so it
+// can be placed with Image(name, ...). data holds w*h pixels of four bytes
+// each (cyan, magenta, yellow, black), row by row from the top. The pixels
+// are embedded losslessly with a DeviceCMYK color space. Registering other
+// data under the same name replaces the image for the following calls to
+// Image.
+func (p *Fpdf) RegisterCMYKImage(name string, w, h int, data []byte) {
+	if w <= 0 || h <= 0 || len(data) != w*h*4 {
+		p.panicError("CMYK image data does not match its size: " + name)
+	}
+	p.addImage(name, &pdfImage{w: w, h: h, cs: "DeviceCMYK", bpc: 8, f: "FlateDecode", data: flateCompress(data)})
+}
+
+// SetImageICCProfile attaches an ICC color profile to the image registered
+// under key (the file name passed to Image). The profile is embedded as a
+// stream and the image color space is written as ICCBased. An empty profile
+// removes a previously attached one. When the image is already registered
+// under other names too, they keep their own profile and the change only
+// applies to the following calls to Image with key.
+func (p *Fpdf) SetImageICCProfile(key string, profile []byte) {
+	if len(profile) == 0 {
+		delete(p.iccProfiles, key)
+	} else {
+		p.iccProfiles[key] = profile
+	}
+	info, ok := p.images[key]
+	if !ok || bytes.Equal(info.icc, profile) {
+		return
+	}
+	shared := false
+	for name, other := range p.images {
+		shared = shared || (other == info && name != key)
+	}
+	if shared {
+		img := *info
+		p.addImage(key, &img)
+		return
+	}
+	// Pages may already show the image, so it keeps its number.
+	if sum := info.hash(); p.imageHashes[sum] == info {
+		delete(p.imageHashes, sum)
+	}
+	info.icc = profile
+	if sum := info.hash(); p.imageHashes[sum] == nil {
+		p.imageHashes[sum] = info
+	}
+}
+
+// SetLinkStyle sets the appearance of the link annotations written for the
+// document. borderWidth is in points and a zero width keeps links invisible,
+// which is the default. color is an RGB triple (0-255) and style is "solid",
+// "dashed" or "underline".
+func (p *Fpdf) SetLinkStyle(borderWidth float64, color [3]int, style string) {
+	p.linkBorderWidth = borderWidth
+	p.linkColor = color
+	p.linkStyle = style
+}
+
 // SetCompression sets whether to compress PDF page streams.
 func (p *Fpdf) SetCompression(compress bool) { p.compress = compress }
 
+// SetCanonicalOutput makes the produced bytes depend only on the document
+// content, for snapshot tests: streams are left uncompressed, no creation date
+// is written and the file identifier is derived from the content.
+func (p *Fpdf) SetCanonicalOutput(canonical bool) { p.canonical = canonical }
+
 // SetTitle sets the document title.
 func (p *Fpdf) SetTitle(title string) { p.metadata["Title"] = p.metaText(title, false) }
 
@@ -960,9 +3525,45 @@ func (p *Fpdf) SetSubject(v string) { p.metadata["Subject"] = p.metaText(v, fals
 // SetKeywords sets the document keywords.
 func (p *Fpdf) SetKeywords(v string) { p.metadata["Keywords"] = p.metaText(v, false) }
 
+// SetXMPMetadata embeds xmlPacket, a complete XMP packet, as the document
+// metadata stream referenced from the catalog. The packet must be well-formed
+// XML. An empty packet removes a previously set one.
+func (p *Fpdf) SetXMPMetadata(xmlPacket string) {
+	d := xml.NewDecoder(strings.NewReader(xmlPacket))
+	for {
+		_, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			p.panicError("XMP metadata is not well-formed XML: " + err.Error())
+		}
+	}
+	p.xmp = xmlPacket
+}
+
+// SetJavaScript sets a document-level JavaScript action, run by viewers that
+// support it when the document is opened (e.g. "print(true);").
+func (p *Fpdf) SetJavaScript(script string) {
+	if strings.TrimSpace(script) == "" {
+		p.panicError("JavaScript script is empty")
+	}
+	p.javascript = script
+}
+
 // SetCreator sets the document creator.
 func (p *Fpdf) SetCreator(v string) { p.metadata["Creator"] = p.metaText(v, false) }
 
+// SetDateTimezone sets the time zone the creation date of the document is
+// written in. It defaults to UTC so that output does not depend on the
+// machine; nil also selects UTC.
+func (p *Fpdf) SetDateTimezone(loc *time.Location) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	p.dateLoc = loc
+}
+
 // SetDisplayMode sets the display mode of the PDF viewer.
 func (p *Fpdf) SetDisplayMode(zoom interface{}, layout string) {
 	p.zoomMode = zoom
@@ -993,6 +3594,34 @@ func (p *Fpdf) WriteHTML(htmlInput string) {
 	state.renderHTML(normalized)
 }
 
+// SetHTMLHeadingSizes sets the font sizes, in points, WriteHTML prints the
+// H1 to H6 headings with, keyed by level. Levels missing from sizes keep their
+// default size: 24, 20, 16, 14, 12 and 10 points from H1 down to H6.
+func (p *Fpdf) SetHTMLHeadingSizes(sizes map[int]float64) {
+	p.htmlHeadingSizes = map[int]float64{}
+	for level, size := range sizes {
+		if level < 1 || level > 6 || size <= 0 {
+			p.panicError(sprintf("incorrect heading size: h%d %.2F", level, size))
+		}
+		p.htmlHeadingSizes[level] = size
+	}
+}
+
+// htmlHeadingSize returns the font size in points of the heading of level.
+func (p *Fpdf) htmlHeadingSize(level int) float64 {
+	if size, ok := p.htmlHeadingSizes[level]; ok {
+		return size
+	}
+	return [...]float64{24, 20, 16, 14, 12, 10}[level-1]
+}
+
+// WriteHTMLReturn renders HTML like WriteHTML and returns the cursor
+// position reached, so that following content can be laid out after it.
+func (p *Fpdf) WriteHTMLReturn(htmlInput string) (endX, endY float64) {
+	p.WriteHTML(htmlInput)
+	return p.x, p.y
+}
+
 // Internal helpers follow (simplified for brevity)
 
 func (p *Fpdf) getPageSize(size string) [2]float64 {
@@ -1039,6 +3668,11 @@ func (p *Fpdf) beginPage(orientation, size string, rotation int) {
 		p.curOrientation = orientation
 		p.curPageSize = ps
 	}
+	if m := p.marginDefaults; m != nil {
+		p.lMargin, p.tMargin, p.rMargin, p.bMargin = m[0], m[1], m[2], m[3]
+		p.pageBreakTrigger = p.h - p.bMargin
+		p.x, p.y = p.lMargin, p.tMargin
+	}
 	if orientation != p.defOrientation || ps != p.defPageSize {
 		if p.pageInfo[p.page] == nil {
 			p.pageInfo[p.page] = map[string]interface{}{}
@@ -1054,7 +3688,13 @@ func (p *Fpdf) beginPage(orientation, size string, rotation int) {
 	p.curRotation = rotation
 }
 
-func (p *Fpdf) endPage() { p.state = 1 }
+func (p *Fpdf) endPage() {
+	if p.angle != 0 {
+		p.angle = 0
+		p.out("Q")
+	}
+	p.state = 1
+}
 
 func (p *Fpdf) out(s string) {
 	switch p.state {
@@ -1071,9 +3711,35 @@ func (p *Fpdf) out(s string) {
 
 func (p *Fpdf) endDoc() {
 	p.creationDate = time.Now()
+	for _, info := range p.images {
+		if info.smk != nil {
+			// Soft masks need PDF 1.4.
+			p.requireVersion("1.4")
+		}
+		if info.bpc == 16 {
+			// 16 bits per component need PDF 1.5.
+			p.requireVersion("1.5")
+		}
+	}
+	if p.xmp != "" {
+		// Metadata streams need PDF 1.4.
+		p.requireVersion("1.4")
+	}
+	if p.protection != nil {
+		id := md5.Sum([]byte(p.protection.userPass + p.protection.ownerPass + p.creationDate.String()))
+		p.protection.init(id[:])
+	}
 	p.putHeader()
 	p.putPages()
 	p.putResources()
+	p.putXMPMetadata()
+	p.putJavaScript()
+	if len(p.outlines) > 0 {
+		p.putOutlines()
+	}
+	if p.protection != nil {
+		p.putEncryption()
+	}
 	p.newObj()
 	p.put("<<")
 	p.putInfo()
@@ -1098,14 +3764,32 @@ func (p *Fpdf) endDoc() {
 	p.put("startxref")
 	p.put(strconv.Itoa(offset))
 	p.put("%%EOF")
+	if p.linearized {
+		p.linearize(offset)
+	}
 	p.state = 3
 }
 
 func (p *Fpdf) putHeader() { p.put("%PDF-" + p.pdfVersion) }
+
+// requireVersion raises the PDF version of the document to at least v.
+func (p *Fpdf) requireVersion(v string) {
+	if v > p.pdfVersion {
+		p.pdfVersion = v
+	}
+}
 func (p *Fpdf) putTrailer() {
 	p.put("/Size " + strconv.Itoa(p.n+1))
 	p.put("/Root " + strconv.Itoa(p.n) + " 0 R")
 	p.put("/Info " + strconv.Itoa(p.n-1) + " 0 R")
+	if p.protection != nil {
+		p.put("/Encrypt " + strconv.Itoa(p.encryptionObj) + " 0 R")
+		id := hex.EncodeToString(p.protection.id)
+		p.put("/ID [<" + id + "> <" + id + ">]")
+	} else if p.canonical {
+		id := sprintf("%x", md5.Sum(p.buffer.Bytes()))
+		p.put("/ID [<" + id + "> <" + id + ">]")
+	}
 }
 func (p *Fpdf) put(s string) {
 	p.buffer.WriteString(s)
@@ -1121,19 +3805,26 @@ func (p *Fpdf) newObj(forced ...int) {
 		p.n++
 		n = p.n
 	}
+	p.curObj = n
 	p.offsets[n] = p.getOffset()
 	p.put(strconv.Itoa(n) + " 0 obj")
 }
 func (p *Fpdf) putStream(data []byte) {
+	if p.protection != nil {
+		data = p.protection.encrypt(p.curObj, data)
+	}
 	p.put("stream")
 	p.buffer.Write(data)
 	p.buffer.WriteByte('\n')
 	p.put("endstream")
 }
-func (p *Fpdf) putStreamObject(data []byte) {
-	entries := ""
-	if p.compress {
-		entries = "/Filter /FlateDecode "
+func (p *Fpdf) putStreamObject(data []byte) { p.putStreamObjectDict("", data) }
+
+// putStreamObjectDict writes a stream object whose dictionary holds the given
+// entries in addition to the filter and length ones.
+func (p *Fpdf) putStreamObjectDict(entries string, data []byte) {
+	if p.compress && !p.canonical {
+		entries += "/Filter /FlateDecode "
 		data = flateCompress(data)
 	}
 	entries += "/Length " + strconv.Itoa(len(data))
@@ -1189,6 +3880,16 @@ func (p *Fpdf) putPage(n int) {
 		if rot, ok2 := pi["rotation"].(int); ok2 {
 			p.put("/Rotate " + strconv.Itoa(rot))
 		}
+		if uu, ok2 := pi["userunit"].(float64); ok2 {
+			p.put(sprintf("/UserUnit %.2F", uu))
+		}
+		if boxes, ok2 := pi["boxes"].(map[string][4]float64); ok2 {
+			for _, name := range []string{"CropBox", "BleedBox", "TrimBox", "ArtBox"} {
+				if b, ok3 := boxes[name]; ok3 {
+					p.put(sprintf("/%s [%.2F %.2F %.2F %.2F]", name, b[0], b[1], b[2], b[3]))
+				}
+			}
+		}
 	}
 	p.put("/Resources 2 0 R")
 	if len(p.pageLinks[n]) > 0 {
@@ -1205,12 +3906,36 @@ func (p *Fpdf) putPage(n int) {
 	p.put("/Contents " + strconv.Itoa(p.n+1) + " 0 R>>")
 	p.put("endobj")
 
+	p.putStreamObject(p.pageContent(n))
+	p.putLinks(n)
+}
+
+// pageContent returns the final content stream of page n.
+func (p *Fpdf) pageContent(n int) []byte {
 	content := strings.Join(p.pages[n], "\n") + "\n"
 	if p.aliasNbPages != "" {
-		content = strings.ReplaceAll(content, p.aliasNbPages, strconv.Itoa(p.page))
+		nb := strconv.Itoa(p.page)
+		content = strings.ReplaceAll(content, p.aliasNbPages, nb)
+		// Text in UTF-8 fonts is written as glyph ids; showUTF8Text puts the
+		// alias in a string of its own.
+		for _, k := range p.fontKeys() {
+			if t := p.fonts[k].ttf; t != nil {
+				content = strings.ReplaceAll(content, sprintf("<%X>", t.encode(p.aliasNbPages)), sprintf("<%X>", t.encode(nb)))
+			}
+		}
 	}
-	p.putStreamObject([]byte(content))
-	p.putLinks(n)
+	return []byte(content)
+}
+
+// pageSizePt returns the width and height of page n in points.
+func (p *Fpdf) pageSizePt(n int) [2]float64 {
+	if sz, ok := p.pageInfo[n]["size"].([2]float64); ok {
+		return sz
+	}
+	if p.defOrientation == "P" {
+		return [2]float64{p.defPageSize[0] * p.k, p.defPageSize[1] * p.k}
+	}
+	return [2]float64{p.defPageSize[1] * p.k, p.defPageSize[0] * p.k}
 }
 
 func (p *Fpdf) putLinks(n int) {
@@ -1221,32 +3946,126 @@ func (p *Fpdf) putLinks(n int) {
 		w := toFloat(pl[2])
 		h := toFloat(pl[3])
 		rect := sprintf("%.2F %.2F %.2F %.2F", x, y, x+w, y-h)
-		s := "<</Type /Annot /Subtype /Link /Rect [" + rect + "] /Border [0 0 0] "
+		s := "<</Type /Annot /Subtype /Link /Rect [" + rect + "] " + p.linkBorder()
 		switch v := pl[4].(type) {
 		case string:
 			s += "/A <</S /URI /URI " + p.textString(v) + ">>>>"
 		default:
-			lnk := toInt(v)
-			dst := p.links[lnk]
-			page := int(dst[0])
-			y2 := dst[1]
-			hPage := p.hPt
-			if pi, ok := p.pageInfo[page]; ok {
-				if sz, ok2 := pi["size"].([2]float64); ok2 {
-					hPage = sz[1]
-				}
-			}
-			nobj := p.pageInfo[page]["n"]
-			s += sprintf("/Dest [%d 0 R /XYZ 0 %.2F null]>>", toInt(nobj), hPage-y2*p.k)
+			dst := p.links[toInt(v)]
+			s += "/Dest " + p.pageDest(int(dst[0]), dst[1]) + ">>"
 		}
 		p.put(s)
 		p.put("endobj")
 	}
 }
 
+// pageDest returns the destination array pointing at position y of a page.
+func (p *Fpdf) pageDest(page int, y float64) string {
+	hPage := p.hPt
+	if pi, ok := p.pageInfo[page]; ok {
+		if sz, ok2 := pi["size"].([2]float64); ok2 {
+			hPage = sz[1]
+		}
+	}
+	nobj := p.pageInfo[page]["n"]
+	return sprintf("[%d 0 R /XYZ 0 %.2F null]", toInt(nobj), hPage-y*p.k)
+}
+
+// putOutlines writes the outline tree of the bookmarks, the entries followed
+// by the outline dictionary.
+func (p *Fpdf) putOutlines() {
+	nb := len(p.outlines)
+	parent := make([]int, nb)
+	first := make([]int, nb)
+	last := make([]int, nb)
+	prev := make([]int, nb)
+	next := make([]int, nb)
+	levels := make([]int, nb)
+	lru := map[int]int{}
+	level := 0
+	for i, o := range p.outlines {
+		first[i], last[i], prev[i], next[i] = -1, -1, -1, -1
+		// A bookmark can only be one level deeper than the previous one.
+		lv := o.level
+		if i == 0 {
+			lv = 0
+		} else if lv > level+1 {
+			lv = level + 1
+		}
+		levels[i] = lv
+		if lv > 0 {
+			parent[i] = lru[lv-1]
+			last[parent[i]] = i
+			if first[parent[i]] < 0 {
+				first[parent[i]] = i
+			}
+		} else {
+			parent[i] = nb
+		}
+		if lv <= level && i > 0 {
+			prev[i] = lru[lv]
+			next[prev[i]] = i
+		}
+		lru[lv] = i
+		level = lv
+	}
+	n := p.n + 1
+	ref := func(i int) string { return strconv.Itoa(n+i) + " 0 R" }
+	for i, o := range p.outlines {
+		p.newObj()
+		p.put("<</Title " + p.textString(o.text))
+		p.put("/Parent " + ref(parent[i]))
+		if prev[i] >= 0 {
+			p.put("/Prev " + ref(prev[i]))
+		}
+		if next[i] >= 0 {
+			p.put("/Next " + ref(next[i]))
+		}
+		if first[i] >= 0 {
+			count := 0
+			for j := i + 1; j < nb && levels[j] > levels[i]; j++ {
+				count++
+			}
+			p.put("/First " + ref(first[i]))
+			p.put("/Last " + ref(last[i]))
+			p.put("/Count " + strconv.Itoa(count))
+		}
+		p.put("/Dest " + p.pageDest(o.page, o.y) + ">>")
+		p.put("endobj")
+	}
+	p.newObj()
+	p.outlineRoot = p.n
+	p.put("<</Type /Outlines /First " + ref(0))
+	p.put("/Last " + ref(lru[0]))
+	p.put("/Count " + strconv.Itoa(nb) + ">>")
+	p.put("endobj")
+}
+
+// linkBorder returns the border and color entries of link annotations.
+func (p *Fpdf) linkBorder() string {
+	if p.linkBorderWidth <= 0 {
+		return "/Border [0 0 0] "
+	}
+	s := sprintf("/Border [0 0 %.2F] /C [%.3F %.3F %.3F] ", p.linkBorderWidth,
+		float64(p.linkColor[0])/255, float64(p.linkColor[1])/255, float64(p.linkColor[2])/255)
+	switch strings.ToLower(p.linkStyle) {
+	case "dashed":
+		s += sprintf("/BS <</W %.2F /S /D>> ", p.linkBorderWidth)
+	case "underline":
+		s += sprintf("/BS <</W %.2F /S /U>> ", p.linkBorderWidth)
+	}
+	return s
+}
+
 func (p *Fpdf) putResources() {
 	p.putFonts()
 	p.putImages()
+	p.putThumbnails()
+	p.putSymbols()
+	p.putTilings()
+	p.putGradients()
+	p.putShadingPatterns()
+	p.putExtGStates()
 	p.newObj(2)
 	p.put("<<")
 	p.putResourceDict()
@@ -1255,7 +4074,24 @@ func (p *Fpdf) putResources() {
 }
 
 func (p *Fpdf) putFonts() {
-	for k, f := range p.fonts {
+	for _, k := range p.fontKeys() {
+		f := p.fonts[k]
+		if f.diff == "" {
+			continue
+		}
+		if _, ok := p.encodings[encodingDict(f)]; !ok {
+			p.newObj()
+			p.put(encodingDict(f))
+			p.put("endobj")
+			p.encodings[encodingDict(f)] = p.n
+		}
+	}
+	for _, k := range p.fontKeys() {
+		f := p.fonts[k]
+		if f.ttf != nil {
+			p.putUTF8Font(f)
+			continue
+		}
 		toUnicodeObj := 0
 		if len(f.uv) > 0 {
 			cmap := p.toUnicodeCMap(f.uv)
@@ -1265,12 +4101,13 @@ func (p *Fpdf) putFonts() {
 
 		p.newObj()
 		f.n = p.n
-		p.fonts[k] = f
 
 		p.put("<</Type /Font")
 		p.put("/BaseFont /" + f.name)
 		p.put("/Subtype /Type1")
-		if f.name != "Symbol" && f.name != "ZapfDingbats" {
+		if f.diff != "" {
+			p.put("/Encoding " + strconv.Itoa(p.encodings[encodingDict(f)]) + " 0 R")
+		} else if f.name != "Symbol" && f.name != "ZapfDingbats" {
 			p.put("/Encoding /WinAnsiEncoding")
 		}
 		if toUnicodeObj > 0 {
@@ -1281,6 +4118,126 @@ func (p *Fpdf) putFonts() {
 	}
 }
 
+// putUTF8Font writes a TrueType font added with AddUTF8Font as a Type0 font
+// with an Identity-H encoding, whose character codes are glyph ids.
+func (p *Fpdf) putUTF8Font(f *pdfFont) {
+	t := f.ttf
+	name, font := t.postName, t.data
+	if f.subsetted {
+		name, font = subsetTag(f.i)+"+"+t.postName, t.subset()
+	}
+	p.putStreamObjectDict(sprintf("/Length1 %d ", len(font)), font)
+	fileObj := p.n
+
+	flags := 4
+	if t.fixedPitch {
+		flags |= 1
+	}
+	if t.italicAngle != 0 {
+		flags |= 64
+	}
+	stemV := 70
+	if t.bold {
+		stemV = 120
+	}
+	p.newObj()
+	p.put("<</Type /FontDescriptor /FontName /" + name)
+	p.put(sprintf("/Flags %d /FontBBox [%d %d %d %d] /ItalicAngle %.2F", flags, t.bbox[0], t.bbox[1], t.bbox[2], t.bbox[3], t.italicAngle))
+	p.put(sprintf("/Ascent %d /Descent %d /CapHeight %d /StemV %d /MissingWidth %d", t.ascent, t.descent, t.capHeight, stemV, t.widths[0]))
+	p.put(sprintf("/FontFile2 %d 0 R>>", fileObj))
+	p.put("endobj")
+	descObj := p.n
+
+	gids := make([]int, 0, len(t.used))
+	for gid := range t.used {
+		gids = append(gids, int(gid))
+	}
+	sort.Ints(gids)
+	var widths, chars strings.Builder
+	for i, gid := range gids {
+		if i == 0 || gids[i-1] != gid-1 {
+			if i > 0 {
+				widths.WriteString("] ")
+			}
+			widths.WriteString(strconv.Itoa(gid) + " [")
+		} else {
+			widths.WriteString(" ")
+		}
+		widths.WriteString(strconv.Itoa(t.widths[gid]))
+		if i%100 == 0 {
+			if i > 0 {
+				chars.WriteString("endbfchar\n")
+			}
+			chars.WriteString(sprintf("%d beginbfchar\n", minInt(100, len(gids)-i)))
+		}
+		chars.WriteString(sprintf("<%04X> <%s>\n", gid, utf16Hex(t.used[uint16(gid)])))
+	}
+	if len(gids) > 0 {
+		widths.WriteString("]")
+		chars.WriteString("endbfchar\n")
+	}
+	cmap := "/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n" +
+		"/CIDSystemInfo\n<</Registry (Adobe)\n/Ordering (UCS)\n/Supplement 0\n>> def\n" +
+		"/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n" +
+		"1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n" + chars.String() +
+		"endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend"
+	p.putStreamObject([]byte(cmap))
+	cmapObj := p.n
+
+	p.newObj()
+	p.put("<</Type /Font /Subtype /CIDFontType2 /BaseFont /" + name)
+	p.put("/CIDSystemInfo <</Registry " + p.textString("Adobe") + " /Ordering " + p.textString("Identity") + " /Supplement 0>>")
+	p.put(sprintf("/FontDescriptor %d 0 R /CIDToGIDMap /Identity /DW %d", descObj, t.widths[0]))
+	p.put("/W [" + widths.String() + "]>>")
+	p.put("endobj")
+	cidObj := p.n
+
+	p.newObj()
+	f.n = p.n
+	p.put("<</Type /Font /Subtype /Type0 /BaseFont /" + name + " /Encoding /Identity-H")
+	p.put(sprintf("/DescendantFonts [%d 0 R] /ToUnicode %d 0 R>>", cidObj, cmapObj))
+	p.put("endobj")
+}
+
+// subsetTag returns the six-letter tag prefixed to the name of the subset of
+// font number n.
+func subsetTag(n int) string {
+	tag := []byte("AAAAAA")
+	for i := len(tag) - 1; i >= 0 && n > 0; i-- {
+		tag[i] = 'A' + byte(n%26)
+		n /= 26
+	}
+	return string(tag)
+}
+
+// encodingDict returns the encoding dictionary of a font with differences.
+// Fonts with the same dictionary share one encoding object. Symbolic fonts
+// keep their built-in base encoding.
+func encodingDict(f *pdfFont) string {
+	if f.name == "Symbol" || f.name == "ZapfDingbats" {
+		return "<</Type /Encoding /Differences [" + f.diff + "]>>"
+	}
+	return "<</Type /Encoding /BaseEncoding /WinAnsiEncoding /Differences [" + f.diff + "]>>"
+}
+
+// fontKeys returns the font keys ordered by font number, so that fonts are
+// written in the same order on every run.
+func (p *Fpdf) fontKeys() []string {
+	keys := make([]string, 0, len(p.fonts))
+	for k := range p.fonts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(a, b int) bool { return p.fonts[keys[a]].i < p.fonts[keys[b]].i })
+	return keys
+}
+
+// uniqueImages returns the registered images in order, each one once even when
+// it is registered under several names. Images replaced under their name are
+// included, as pages may already show them.
+func (p *Fpdf) uniqueImages() []*pdfImage {
+	return p.imageList
+}
+
 func (p *Fpdf) toUnicodeCMap(uv map[int]interface{}) string {
 	var ranges strings.Builder
 	var chars strings.Builder
@@ -1323,45 +4280,179 @@ func (p *Fpdf) toUnicodeCMap(uv map[int]interface{}) string {
 }
 
 func (p *Fpdf) putImages() {
-	for _, info := range p.images {
+	for _, info := range p.uniqueImages() {
 		p.putImage(info)
 	}
 }
 
 func (p *Fpdf) putImage(info *pdfImage) {
+	cs := "/" + info.cs
+	if len(info.icc) > 0 {
+		p.putStreamObjectDict(sprintf("/N %d /Alternate /%s ", colorComponents(info.cs), info.cs), info.icc)
+		cs = "[/ICCBased " + strconv.Itoa(p.n) + " 0 R]"
+	}
+	if info.cs == "Indexed" {
+		p.putStreamObject(info.pal)
+		cs = sprintf("[/Indexed /DeviceRGB %d %d 0 R]", len(info.pal)/3-1, p.n)
+	}
+	smask := 0
+	if info.smk != nil {
+		p.newObj()
+		smask = p.n
+		p.put("<</Type /XObject /Subtype /Image /Width " + strconv.Itoa(info.w) + " /Height " + strconv.Itoa(info.h))
+		p.put("/ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /FlateDecode")
+		p.put("/Length " + strconv.Itoa(len(info.smk)) + ">>")
+		p.putStream(info.smk)
+		p.put("endobj")
+	}
 	p.newObj()
 	info.n = p.n
 	p.put("<</Type /XObject")
 	p.put("/Subtype /Image")
 	p.put("/Width " + strconv.Itoa(info.w))
 	p.put("/Height " + strconv.Itoa(info.h))
-	p.put("/ColorSpace /" + info.cs)
+	p.put("/ColorSpace " + cs)
 	p.put("/BitsPerComponent " + strconv.Itoa(info.bpc))
 	if info.f != "" {
 		p.put("/Filter /" + info.f)
 	}
-	p.put("/Length " + strconv.Itoa(len(info.data)) + ">>")
-	p.putStream(info.data)
-	p.put("endobj")
+	if smask > 0 {
+		p.put("/SMask " + strconv.Itoa(smask) + " 0 R")
+	}
+	p.put("/Length " + strconv.Itoa(len(info.data)) + ">>")
+	p.putStream(info.data)
+	p.put("endobj")
+}
+
+func (p *Fpdf) putResourceDict() {
+	p.put("/ProcSet [/PDF /Text /ImageB /ImageC /ImageI]")
+	p.put("/Font <<")
+	for _, k := range p.fontKeys() {
+		f := p.fonts[k]
+		p.put("/F" + strconv.Itoa(f.i) + " " + strconv.Itoa(f.n) + " 0 R")
+	}
+	p.put(">>")
+	p.put("/XObject <<")
+	for _, image := range p.uniqueImages() {
+		p.put("/I" + strconv.Itoa(image.i) + " " + strconv.Itoa(image.n) + " 0 R")
+	}
+	for _, page := range sortedInts(p.thumbnails) {
+		p.put("/PG" + strconv.Itoa(page) + " " + strconv.Itoa(p.thumbnails[page]) + " 0 R")
+	}
+	for i, sym := range p.symbols {
+		p.put("/SY" + strconv.Itoa(i+1) + " " + strconv.Itoa(sym.n) + " 0 R")
+	}
+	p.put(">>")
+	if len(p.tilings) > 0 || len(p.shadings) > 0 {
+		p.put("/Pattern <<")
+		for i, t := range p.tilings {
+			p.put("/P" + strconv.Itoa(i+1) + " " + strconv.Itoa(t.n) + " 0 R")
+		}
+		for i, sp := range p.shadings {
+			p.put("/SP" + strconv.Itoa(i+1) + " " + strconv.Itoa(sp.n) + " 0 R")
+		}
+		p.put(">>")
+	}
+	if len(p.extGStates) > 0 {
+		p.put("/ExtGState <<")
+		for i, gs := range p.extGStates {
+			p.put("/GS" + strconv.Itoa(i+1) + " " + strconv.Itoa(gs.n) + " 0 R")
+		}
+		p.put(">>")
+	}
+	if len(p.gradients) > 0 {
+		p.put("/Shading <<")
+		for i, g := range p.gradients {
+			p.put("/Sh" + strconv.Itoa(i+1) + " " + strconv.Itoa(g.n) + " 0 R")
+		}
+		p.put(">>")
+	}
+}
+
+// putTilings writes the tiling patterns painted with TileImage.
+func (p *Fpdf) putTilings() {
+	for _, t := range p.tilings {
+		img := p.images[t.image]
+		p.putStreamObjectDict(sprintf("/Type /Pattern /PatternType 1 /PaintType 1 /TilingType 1 /BBox [0 0 %.2F %.2F] /XStep %.2F /YStep %.2F /Matrix [1 0 0 1 %.2F %.2F] /Resources <</XObject <</I%d %d 0 R>>>> ",
+			t.w, t.h, t.w, t.h, t.ox, t.oy, img.i, img.n), []byte(sprintf("q %.2F 0 0 %.2F 0 0 cm /I%d Do Q", t.w, t.h, img.i)))
+		t.n = p.n
+	}
+}
+
+// putGradients writes the axial shadings painted with LinearGradient.
+func (p *Fpdf) putGradients() {
+	for _, g := range p.gradients {
+		p.newObj()
+		p.put("<</ShadingType 2 /ColorSpace /DeviceRGB")
+		p.put(sprintf("/Coords [%.5F %.5F %.5F %.5F]", g.coords[0], g.coords[1], g.coords[2], g.coords[3]))
+		p.put(sprintf("/Function <</FunctionType 2 /Domain [0 1] /C0 [%.3F %.3F %.3F] /C1 [%.3F %.3F %.3F] /N 1>>",
+			g.c1[0], g.c1[1], g.c1[2], g.c2[0], g.c2[1], g.c2[2]))
+		p.put("/Extend [true true]>>")
+		p.put("endobj")
+		g.n = p.n
+	}
+}
+
+// putShadingPatterns writes the patterns defined with AddGradientPattern.
+func (p *Fpdf) putShadingPatterns() {
+	for _, sp := range p.shadings {
+		p.newObj()
+		p.put(sprintf("<</Type /Pattern /PatternType 2 /Shading %d 0 R>>", p.gradients[sp.gradient-1].n))
+		p.put("endobj")
+		sp.n = p.n
+	}
+}
+
+// putSymbols writes the symbols recorded with DefineSymbol as form XObjects.
+// The drawing was recorded in page coordinates, so the form matrix moves the
+// symbol origin to (0, 0).
+func (p *Fpdf) putSymbols() {
+	for _, sym := range p.symbols {
+		p.putStreamObjectDict(sprintf("/Type /XObject /Subtype /Form /BBox [%.2F %.2F %.2F %.2F] /Matrix [1 0 0 1 0 %.2F] /Resources 2 0 R ",
+			-sym.wPt, 0.0, sym.wPt, 2*sym.hPt, -sym.hPt), sym.data)
+		sym.n = p.n
+	}
 }
 
-func (p *Fpdf) putResourceDict() {
-	p.put("/ProcSet [/PDF /Text /ImageB /ImageC /ImageI]")
-	p.put("/Font <<")
-	for _, f := range p.fonts {
-		p.put("/F" + strconv.Itoa(f.i) + " " + strconv.Itoa(f.n) + " 0 R")
+// putExtGStates writes the graphics states used for transparency.
+func (p *Fpdf) putExtGStates() {
+	for _, gs := range p.extGStates {
+		p.newObj()
+		bm := ""
+		if gs.blendMode != "" {
+			bm = " /BM /" + gs.blendMode
+		}
+		p.put(sprintf("<</Type /ExtGState /ca %.3F /CA %.3F%s>>", gs.alpha, gs.alpha, bm))
+		p.put("endobj")
+		gs.n = p.n
 	}
-	p.put(">>")
-	p.put("/XObject <<")
-	for _, image := range p.images {
-		p.put("/I" + strconv.Itoa(image.i) + " " + strconv.Itoa(image.n) + " 0 R")
+}
+
+// putThumbnails writes the pages drawn with PageThumbnail as form XObjects.
+func (p *Fpdf) putThumbnails() {
+	for _, page := range sortedInts(p.thumbnails) {
+		sz := p.pageSizePt(page)
+		p.putStreamObjectDict(sprintf("/Type /XObject /Subtype /Form /BBox [0 0 %.2F %.2F] /Resources 2 0 R ", sz[0], sz[1]), p.pageContent(page))
+		p.thumbnails[page] = p.n
 	}
-	p.put(">>")
+}
+
+// pdfDate formats t as a PDF date string, in UTC with a "Z" suffix when its
+// location has no offset.
+func pdfDate(t time.Time) string {
+	if _, offset := t.Zone(); offset == 0 {
+		return "D:" + t.Format("20060102150405") + "Z"
+	}
+	date := t.Format("20060102150405-0700")
+	return "D:" + date[:len(date)-2] + "'" + date[len(date)-2:] + "'"
 }
 
 func (p *Fpdf) putInfo() {
-	date := p.creationDate.Format("20060102150405-0700")
-	p.metadata["CreationDate"] = "D:" + date[:len(date)-2] + "'" + date[len(date)-2:] + "'"
+	if p.canonical {
+		delete(p.metadata, "CreationDate")
+	} else {
+		p.metadata["CreationDate"] = pdfDate(p.creationDate.In(p.dateLoc))
+	}
 	keys := make([]string, 0, len(p.metadata))
 	for k := range p.metadata {
 		keys = append(keys, k)
@@ -1372,10 +4463,44 @@ func (p *Fpdf) putInfo() {
 	}
 }
 
+// putXMPMetadata writes the packet set with SetXMPMetadata. It is left
+// uncompressed so that tools scanning files for XMP can find it.
+func (p *Fpdf) putXMPMetadata() {
+	if p.xmp == "" {
+		return
+	}
+	p.newObj()
+	p.xmpObj = p.n
+	p.put("<</Type /Metadata /Subtype /XML /Length " + strconv.Itoa(len(p.xmp)) + ">>")
+	p.putStream([]byte(p.xmp))
+	p.put("endobj")
+}
+
+// putJavaScript writes the action set with SetJavaScript.
+func (p *Fpdf) putJavaScript() {
+	if p.javascript == "" {
+		return
+	}
+	p.newObj()
+	p.javascriptObj = p.n
+	p.put("<</S /JavaScript /JS " + p.textString(p.javascript) + ">>")
+	p.put("endobj")
+}
+
 func (p *Fpdf) putCatalog() {
 	n := toInt(p.pageInfo[1]["n"])
 	p.put("/Type /Catalog")
 	p.put("/Pages 1 0 R")
+	if p.xmp != "" {
+		p.put("/Metadata " + strconv.Itoa(p.xmpObj) + " 0 R")
+	}
+	if p.javascript != "" {
+		p.put("/Names <</JavaScript <</Names [" + p.textString("EmbeddedJS") + " " + strconv.Itoa(p.javascriptObj) + " 0 R]>>>>")
+	}
+	if len(p.outlines) > 0 {
+		p.put("/Outlines " + strconv.Itoa(p.outlineRoot) + " 0 R")
+		p.put("/PageMode /UseOutlines")
+	}
 	switch v := p.zoomMode.(type) {
 	case string:
 		s := strings.ToLower(v)
@@ -1400,6 +4525,15 @@ func (p *Fpdf) putCatalog() {
 	}
 }
 
+// Error returns the last error recorded while building the document, such as
+// an image skipped by SetImageErrorMode, or nil.
+func (p *Fpdf) Error() error {
+	if p.lastError == "" {
+		return nil
+	}
+	return errors.New("fpdf error: " + p.lastError)
+}
+
 func (p *Fpdf) setError(msg string)   { p.lastError = msg }
 func (p *Fpdf) panicError(msg string) { panic("fpdf error: " + msg) }
 
@@ -1415,6 +4549,7 @@ func (p *Fpdf) escape(s string) string {
 	r = strings.ReplaceAll(r, "(", "\\(")
 	r = strings.ReplaceAll(r, ")", "\\)")
 	r = strings.ReplaceAll(r, "\r", "\\r")
+	r = strings.ReplaceAll(r, "\n", "\\n")
 	return r
 }
 
@@ -1422,59 +4557,521 @@ func (p *Fpdf) textString(s string) string {
 	if !isASCII(s) {
 		s = utf8ToUTF16BEWithBOM(s)
 	}
+	if p.protection != nil {
+		s = string(p.protection.encrypt(p.curObj, []byte(s)))
+	}
 	return "(" + p.escape(s) + ")"
 }
 
+// borderDash returns the dash operator matching a cell border style, or an
+// empty string for solid borders.
+func (p *Fpdf) borderDash(style string) string {
+	return dashPattern(style, math.Max(p.lineWidth*p.k, 1))
+}
+
+// dashPattern returns the operators setting up a "dashed" or "dotted" line
+// whose dashes are scaled by unit points, or an empty string for other styles.
+func dashPattern(style string, unit float64) string {
+	switch strings.ToLower(style) {
+	case "dashed":
+		return sprintf("[%.2F %.2F] 0 d ", 3*unit, 2*unit)
+	case "dotted":
+		return sprintf("1 J [0 %.2F] 0 d ", 2*unit)
+	}
+	return ""
+}
+
 func (p *Fpdf) doUnderline(x, y float64, txt string) string {
 	if p.currentFont == nil {
 		return ""
 	}
 	w := p.GetStringWidth(txt) + p.ws*float64(strings.Count(txt, " "))
-	return sprintf("%.2F %.2F %.2F %.2F re f", x*p.k, (p.h-(y-p.currentFont.up/1000*p.fontSize))*p.k, w*p.k, -p.currentFont.ut/1000*p.fontSizePt)
+	t := p.currentFont.ut / 1000 * p.fontSizePt
+	if dash := dashPattern(p.underlineStyle, t); dash != "" {
+		// The line is stroked, so it takes the text color as stroke color.
+		color := strings.Fields(p.textColor)
+		color[len(color)-1] = strings.ToUpper(color[len(color)-1])
+		yl := (p.h-(y-p.currentFont.up/1000*p.fontSize))*p.k - t/2
+		return sprintf("q %s %.2F w 0 J %s%.2F %.2F m %.2F %.2F l S Q", strings.Join(color, " "), t, dash, x*p.k, yl, (x+w)*p.k, yl)
+	}
+	return sprintf("%.2F %.2F %.2F %.2F re f", x*p.k, (p.h-(y-p.currentFont.up/1000*p.fontSize))*p.k, w*p.k, -t)
+}
+
+func (p *Fpdf) doStrikeout(x, y float64, txt string) string {
+	if p.currentFont == nil {
+		return ""
+	}
+	w := p.GetStringWidth(txt) + p.ws*float64(strings.Count(txt, " "))
+	return sprintf("%.2F %.2F %.2F %.2F re f", x*p.k, (p.h-(y-0.3*p.fontSize))*p.k, w*p.k, -p.currentFont.ut/1000*p.fontSizePt)
+}
+
+// registerImage returns the image registered under file, parsing the file
+// and registering it first if needed.
+func (p *Fpdf) registerImage(file, typ string) *pdfImage {
+	info, err := p.tryRegisterImage(file, typ)
+	if err != nil {
+		p.panicError(err.Error())
+	}
+	return info
+}
+
+// ImageReader inserts an image read from r like Image does for a file. name
+// identifies the image: later calls to Image or ImageReader with the same name
+// reuse it without reading r. The type is taken from the decoded data when
+// typ is empty.
+func (p *Fpdf) ImageReader(name string, r io.Reader, x, y, w, h float64, typ string, link interface{}) {
+	if _, err := p.registerImageReader(name, r, typ); err != nil {
+		p.imageFailed(err, x, y, w, h)
+		return
+	}
+	p.Image(name, x, y, w, h, typ, link)
+}
+
+// RegisterImageBytes registers the image encoded in data under name so it can
+// be placed with Image(name, ...). The type is taken from the decoded data
+// when typ is empty.
+func (p *Fpdf) RegisterImageBytes(name string, data []byte, typ string) {
+	if _, err := p.registerImageReader(name, bytes.NewReader(data), typ); err != nil {
+		p.panicError(err.Error())
+	}
 }
 
-func (p *Fpdf) parseImageFile(file string) *pdfImage {
-	f, err := os.Open(file)
+// registerImageReader registers the image read from r under name, unless an
+// image is already registered under that name.
+func (p *Fpdf) registerImageReader(name string, r io.Reader, typ string) (*pdfImage, error) {
+	if name == "" {
+		return nil, errors.New("image name is empty")
+	}
+	if info, ok := p.images[name]; ok {
+		return info, nil
+	}
+	if typ != "" && !supportedImageType(typ) {
+		return nil, errors.New("unsupported image type: " + typ)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.New("unable to read image " + name + ": " + err.Error())
+	}
+	info, err := parseImageData(name, data, p.maxImageDim)
+	if err != nil {
+		return nil, err
+	}
+	return p.addImage(name, info), nil
+}
+
+// imageFailed handles an image that could not be loaded according to the
+// mode set with SetImageErrorMode.
+func (p *Fpdf) imageFailed(err error, x, y, w, h float64) {
+	if p.imageErrorMode == "" || p.imageErrorMode == "panic" {
+		p.panicError(err.Error())
+		return
+	}
+	p.setError(err.Error())
+	if p.imageErrorMode == "placeholder" {
+		p.imagePlaceholder(x, y, w, h)
+	}
+}
+
+// tryRegisterImage is like registerImage but returns loading errors.
+func (p *Fpdf) tryRegisterImage(file, typ string) (*pdfImage, error) {
+	if file == "" {
+		return nil, errors.New("image file name is empty")
+	}
+	if info, ok := p.images[file]; ok {
+		return info, nil
+	}
+	info, err := loadImage(file, typ, p.maxImageDim)
 	if err != nil {
-		p.panicError("can't open image file: " + file)
+		return nil, err
+	}
+	return p.addImage(file, info), nil
+}
+
+// loadImage decodes an image file of the given type, deriving the type from
+// the file extension when typ is empty.
+func loadImage(file, typ string, maxDim int) (*pdfImage, error) {
+	if typ == "" {
+		ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(file), "."))
+		if ext == "" {
+			return nil, errors.New("image file has no extension and no type was specified: " + file)
+		}
+		typ = ext
+	}
+	if !supportedImageType(typ) {
+		return nil, errors.New("unsupported image type: " + typ)
+	}
+	return parseImageFile(file, maxDim)
+}
+
+// supportedImageType reports whether images of type typ can be decoded.
+func supportedImageType(typ string) bool {
+	switch strings.ToLower(typ) {
+	case "jpg", "jpeg", "png", "gif":
+		return true
+	}
+	return false
+}
+
+// PreloadImages decodes and registers the images in paths under the
+// corresponding keys, so that later calls to Image with those keys do not
+// touch the file system. A path listed under several keys is decoded once.
+// Keys that are already registered are left unchanged.
+func (p *Fpdf) PreloadImages(keys []string, paths []string) error {
+	if len(keys) != len(paths) {
+		return fmt.Errorf("fpdf error: %d image keys but %d paths", len(keys), len(paths))
+	}
+	decoded := make(map[string]*pdfImage)
+	for i, key := range keys {
+		if key == "" {
+			return errors.New("fpdf error: image key is empty")
+		}
+		if _, ok := p.images[key]; ok {
+			continue
+		}
+		src, ok := decoded[paths[i]]
+		if !ok {
+			var err error
+			if src, err = loadImage(paths[i], "", p.maxImageDim); err != nil {
+				return errors.New("fpdf error: " + err.Error())
+			}
+			decoded[paths[i]] = src
+		}
+		p.addImage(key, src)
 	}
-	defer f.Close()
+	return nil
+}
+
+// ImageCount returns the number of names images are registered under. Unlike
+// GetImageCount, it counts an image registered under several names once per
+// name.
+func (p *Fpdf) ImageCount() int {
+	return len(p.images)
+}
+
+// GetImageCount returns the number of distinct images embedded in the
+// document. Images with identical content and ICC profile registered under
+// several names are embedded, and counted, once; ImageCount counts the names.
+func (p *Fpdf) GetImageCount() int {
+	return len(p.uniqueImages())
+}
+
+// addImage registers info under name, with the ICC profile attached to the
+// name, and returns the image to use, which is an already registered one when
+// its content and profile are identical.
+func (p *Fpdf) addImage(name string, info *pdfImage) *pdfImage {
+	info.icc = p.iccProfiles[name]
+	sum := info.hash()
+	if prev, ok := p.imageHashes[sum]; ok {
+		p.images[name] = prev
+		return prev
+	}
+	p.imageList = append(p.imageList, info)
+	info.i = len(p.imageList)
+	p.imageHashes[sum] = info
+	p.images[name] = info
+	return info
+}
+
+// hash returns a digest of the content of the image.
+func (info *pdfImage) hash() [16]byte {
+	h := md5.New()
+	fmt.Fprintf(h, "%d %d %d %d %s %d %s %s %d %v %d %d %d ", info.w, info.h, info.ow, info.oh, info.cs, info.bpc,
+		info.f, info.dp, len(info.pal), info.trns, len(info.data), len(info.smk), len(info.icc))
+	h.Write(info.pal)
+	h.Write(info.data)
+	h.Write(info.smk)
+	h.Write(info.icc)
+	var sum [16]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
 
-	cfg, format, err := image.DecodeConfig(f)
+func parseImageFile(file string, maxDim int) (*pdfImage, error) {
+	data, err := os.ReadFile(file)
 	if err != nil {
-		p.panicError("missing or incorrect image file: " + file)
+		return nil, errors.New("can't open image file: " + file)
 	}
+	return parseImageData(file, data, maxDim)
+}
 
-	if _, err := f.Seek(0, io.SeekStart); err != nil {
-		p.panicError("unable to seek image file")
+// parseImageData decodes the image encoded in data, whose format is detected
+// from its content. Images larger than maxDim pixels in either dimension are
+// downscaled to fit, unless maxDim is 0. name is used in error messages.
+func parseImageData(name string, data []byte, maxDim int) (*pdfImage, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("missing or incorrect image file: " + name)
+	}
+	if maxDim > 0 && (cfg.Width > maxDim || cfg.Height > maxDim) {
+		info, err := decodeImage(name, data, format, maxDim)
+		if err == nil {
+			info.ow, info.oh = cfg.Width, cfg.Height
+		}
+		return info, err
+	}
+	if strings.ToLower(format) == "jpeg" {
+		return &pdfImage{w: cfg.Width, h: cfg.Height, cs: "DeviceRGB", bpc: 8, f: "DCTDecode", data: data}, nil
 	}
+	return decodeImage(name, data, format, 0)
+}
+
+// decodeImage decodes the image encoded in data in the given format,
+// downscaling it to fit maxDim pixels unless maxDim is 0.
+func decodeImage(name string, data []byte, format string, maxDim int) (*pdfImage, error) {
 
-	switch strings.ToLower(format) {
-	case "jpeg":
-		data, readErr := io.ReadAll(f)
-		if readErr != nil {
-			p.panicError("unable to read JPEG image file")
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("unable to decode image file: " + name)
+	}
+	if maxDim > 0 {
+		img = downscaleImage(img, maxDim)
+		if strings.ToLower(format) == "jpeg" && grayImage(img) == nil {
+			// Photos stay JPEG compressed.
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+				return nil, errors.New("unable to encode image file: " + name)
+			}
+			b := img.Bounds()
+			return &pdfImage{w: b.Dx(), h: b.Dy(), cs: "DeviceRGB", bpc: 8, f: "DCTDecode", data: buf.Bytes()}, nil
 		}
-		return &pdfImage{w: cfg.Width, h: cfg.Height, cs: "DeviceRGB", bpc: 8, f: "DCTDecode", data: data}
+	}
+	if info := grayImage(img); info != nil {
+		return info, nil
+	}
+	return colorImage(img), nil
+}
+
+// downscaleImage shrinks img so that neither dimension exceeds maxDim pixels,
+// keeping its aspect ratio. Each pixel averages the pixels it covers.
+// Grayscale images stay grayscale.
+func downscaleImage(img image.Image, maxDim int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	f := math.Max(float64(w), float64(h)) / float64(maxDim)
+	nw := maxInt(1, int(math.Round(float64(w)/f)))
+	nh := maxInt(1, int(math.Round(float64(h)/f)))
+	var gray *image.Gray
+	var rgba *image.RGBA64
+	switch img.(type) {
+	case *image.Gray, *image.Gray16:
+		gray = image.NewGray(image.Rect(0, 0, nw, nh))
 	default:
-		img, _, decodeErr := image.Decode(f)
-		if decodeErr != nil {
-			p.panicError("unable to decode image file: " + file)
+		rgba = image.NewRGBA64(image.Rect(0, 0, nw, nh))
+	}
+	for y := 0; y < nh; y++ {
+		y0, y1 := b.Min.Y+y*h/nh, b.Min.Y+(y+1)*h/nh
+		for x := 0; x < nw; x++ {
+			x0, x1 := b.Min.X+x*w/nw, b.Min.X+(x+1)*w/nw
+			var sr, sg, sb, sa, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					r, g, bl, a := img.At(sx, sy).RGBA()
+					sr, sg, sb, sa = sr+uint64(r), sg+uint64(g), sb+uint64(bl), sa+uint64(a)
+					n++
+				}
+			}
+			if gray != nil {
+				gray.SetGray(x, y, color.Gray{Y: uint8(sr / n >> 8)})
+			} else {
+				rgba.SetRGBA64(x, y, color.RGBA64{R: uint16(sr / n), G: uint16(sg / n), B: uint16(sb / n), A: uint16(sa / n)})
+			}
+		}
+	}
+	if gray != nil {
+		return gray
+	}
+	return rgba
+}
+
+// colorImage returns the pixels of img as a lossless Indexed image for
+// paletted images or a DeviceRGB one otherwise. An alpha channel that is not
+// fully opaque becomes the soft mask of the image.
+func colorImage(img image.Image) *pdfImage {
+	b := img.Bounds()
+	info := &pdfImage{w: b.Dx(), h: b.Dy(), bpc: 8, f: "FlateDecode"}
+	alpha := make([]byte, 0, b.Dx()*b.Dy())
+	opaque := true
+	var data []byte
+	if pi, ok := img.(*image.Paletted); ok {
+		info.cs = "Indexed"
+		for _, c := range pi.Palette {
+			n := color.NRGBAModel.Convert(c).(color.NRGBA)
+			info.pal = append(info.pal, n.R, n.G, n.B)
+		}
+		data = make([]byte, 0, b.Dx()*b.Dy())
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				i := pi.ColorIndexAt(x, y)
+				data = append(data, i)
+				a := color.NRGBAModel.Convert(pi.Palette[i]).(color.NRGBA).A
+				alpha = append(alpha, a)
+				opaque = opaque && a == 255
+			}
+		}
+	} else {
+		info.cs = "DeviceRGB"
+		data = make([]byte, 0, 3*b.Dx()*b.Dy())
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				n := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+				data = append(data, n.R, n.G, n.B)
+				alpha = append(alpha, n.A)
+				opaque = opaque && n.A == 255
+			}
+		}
+	}
+	info.data = flateCompress(data)
+	if !opaque {
+		info.smk = flateCompress(alpha)
+	}
+	return info
+}
+
+// grayImage returns the pixels of a grayscale image as a lossless DeviceGray
+// image, or nil if img is not grayscale.
+func grayImage(img image.Image) *pdfImage {
+	b := img.Bounds()
+	var data []byte
+	bpc := 8
+	switch g := img.(type) {
+	case *image.Gray:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			data = append(data, g.Pix[g.PixOffset(b.Min.X, y):g.PixOffset(b.Max.X, y)]...)
+		}
+	case *image.Gray16:
+		bpc = 16
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			data = append(data, g.Pix[g.PixOffset(b.Min.X, y):g.PixOffset(b.Max.X, y)]...)
 		}
+	default:
+		return nil
+	}
+	return &pdfImage{w: b.Dx(), h: b.Dy(), cs: "DeviceGray", bpc: bpc, f: "FlateDecode", data: flateCompress(data)}
+}
+
+// lineRange holds the [start, end) byte offsets of a wrapped line. hyphen is
+// set when the line ends inside a word broken by the hyphenator.
+type lineRange struct {
+	start, end int
+	hyphen     bool
+}
+
+// zeroWidthSpace marks a break opportunity in the text wrapped by
+// lineRanges. It takes no room and is not printed.
+const zeroWidthSpace = "\u200b"
+
+// text returns the line of s described by r, hyphen included.
+func (r lineRange) text(s string) string {
+	line := strings.ReplaceAll(s[r.start:r.end], zeroWidthSpace, "")
+	if r.hyphen {
+		return line + "-"
+	}
+	return line
+}
 
-		var encoded bytes.Buffer
-		if encodeErr := stdjpeg.Encode(&encoded, img, &stdjpeg.Options{Quality: 90}); encodeErr != nil {
-			p.panicError("unable to encode image as JPEG: " + file)
+// lineRanges splits s the way MultiCell wraps text in a cell of width w and
+// returns the offsets of every line. Lines break at spaces, which are
+// dropped, and at zero-width spaces. s must not contain carriage returns.
+func (p *Fpdf) lineRanges(s string, w float64) []lineRange {
+	if p.currentFont == nil {
+		return nil
+	}
+	wmax := (w - 2*p.cMargin) * 1000 / p.fontSize
+	nb := len(s)
+	if nb > 0 && s[nb-1] == '\n' {
+		nb--
+	}
+	var lines []lineRange
+	// The line breaks at sep at the latest and the next one starts at next.
+	sep, next := -1, -1
+	i, j, l := 0, 0, 0
+	for i < nb {
+		c := s[i]
+		if c == '\n' {
+			lines = append(lines, lineRange{start: j, end: i})
+			i++
+			sep = -1
+			j = i
+			l = 0
+			continue
+		}
+		if strings.HasPrefix(s[i:nb], zeroWidthSpace) {
+			sep, next = i, i+len(zeroWidthSpace)
+			i = next
+			continue
+		}
+		if c == ' ' {
+			sep, next = i, i+1
+		}
+		l += p.charWidthAt(s, i)
+		if float64(l) > wmax {
+			if k, ok := p.hyphenBreak(s[:nb], j, i, wmax); ok {
+				lines = append(lines, lineRange{start: j, end: k, hyphen: true})
+				i = k
+			} else if sep == -1 {
+				if i == j {
+					i += p.charLen(s, i)
+				}
+				lines = append(lines, lineRange{start: j, end: i})
+			} else {
+				lines = append(lines, lineRange{start: j, end: sep})
+				i = next
+			}
+			sep = -1
+			j = i
+			l = 0
+		} else {
+			i++
 		}
+	}
+	return append(lines, lineRange{start: j, end: i})
+}
 
-		return &pdfImage{w: cfg.Width, h: cfg.Height, cs: "DeviceRGB", bpc: 8, f: "DCTDecode", data: encoded.Bytes()}
+// hyphenBreak looks for a break point inside the word of s that overflows the
+// line starting at j at byte i. It returns the offset at which the line ends
+// when the hyphenator offers a break that fits in wmax (in font units) along
+// with the hyphen.
+func (p *Fpdf) hyphenBreak(s string, j, i int, wmax float64) (int, bool) {
+	if p.hyphenator == nil || s[i] == ' ' {
+		return 0, false
+	}
+	start := strings.LastIndexByte(s[j:i], ' ') + j + 1
+	end := strings.IndexAny(s[i:], " \n")
+	if end < 0 {
+		end = len(s)
+	} else {
+		end += i
+	}
+	parts := p.hyphenator.Hyphenate(s[start:end])
+	if len(parts) < 2 || strings.Join(parts, "") != s[start:end] {
+		return 0, false
+	}
+	l := p.charWidth('-')
+	for k := j; k < start; k++ {
+		l += p.charWidthAt(s, k)
 	}
+	k := start
+	for _, part := range parts[:len(parts)-1] {
+		w := 0
+		for n := 0; n < len(part); n++ {
+			w += p.charWidthAt(part, n)
+		}
+		if float64(l+w) > wmax {
+			break
+		}
+		l += w
+		k += len(part)
+	}
+	return k, k > start
 }
 
 func (p *Fpdf) charWidth(c byte) int {
 	if p.currentFont == nil {
 		return 0
 	}
+	if p.currentFont.ttf != nil {
+		return p.ttfWidth(rune(c))
+	}
 	w := p.currentFont.cw[c]
 	if w == 0 {
 		return p.currentFont.cw['?']
@@ -1482,6 +5079,195 @@ func (p *Fpdf) charWidth(c byte) int {
 	return w
 }
 
+// charWidthAt returns the width of the character starting at byte i of s. The
+// whole width of a multibyte UTF-8 character goes to its first byte and its
+// other bytes count for nothing. With a cp1252 font, such a character is
+// measured as the cp1252 character it is printed as (see winAnsiText); other
+// single-byte fonts measure every byte.
+func (p *Fpdf) charWidthAt(s string, i int) int {
+	if p.currentFont == nil || s[i] < utf8.RuneSelf || p.currentFont.ttf == nil && p.currentFont.enc != "cp1252" {
+		return p.charWidth(s[i])
+	}
+	if p.currentFont.ttf != nil {
+		if !utf8.RuneStart(s[i]) {
+			return 0
+		}
+		r, _ := utf8.DecodeRuneInString(s[i:])
+		return p.ttfWidth(r)
+	}
+	if r, n := utf8.DecodeRuneInString(s[i:]); n > 1 {
+		c, ok := winAnsiByte(r)
+		if !ok {
+			c = '?'
+		}
+		return p.charWidth(c)
+	}
+	for j := i - 1; j >= 0 && j >= i-3; j-- {
+		if utf8.RuneStart(s[j]) {
+			if _, n := utf8.DecodeRuneInString(s[j:]); j+n > i {
+				return 0
+			}
+			break
+		}
+	}
+	return p.charWidth(s[i])
+}
+
+// charLen returns the length in bytes of the character starting at byte i of
+// s: 1 unless it is a multibyte UTF-8 character and the current font takes
+// UTF-8 text.
+func (p *Fpdf) charLen(s string, i int) int {
+	f := p.currentFont
+	if f != nil && f.ttf == nil && f.enc != "cp1252" {
+		return 1
+	}
+	if _, n := utf8.DecodeRuneInString(s[i:]); n > 1 || f != nil && f.ttf != nil {
+		return n
+	}
+	return 1
+}
+
+// singleByteText returns s as printed with the single-byte font f. cp1252
+// fonts take UTF-8 text (see winAnsiText); fonts with another encoding print
+// the bytes of s as they are.
+func (f *pdfFont) singleByteText(s string) string {
+	if f.enc != "cp1252" {
+		return s
+	}
+	return winAnsiText(s)
+}
+
+// winAnsiText returns s as printed with a cp1252 font. Valid multibyte
+// UTF-8 characters in s are replaced by their cp1252 code, or by '?' when
+// cp1252 lacks them; other bytes are kept, so that cp1252 text passes
+// unchanged.
+func winAnsiText(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		if n > 1 {
+			c, ok := winAnsiByte(r)
+			if !ok {
+				c = '?'
+			}
+			b.WriteByte(c)
+		} else {
+			b.WriteByte(s[i])
+			n = 1
+		}
+		i += n
+	}
+	return b.String()
+}
+
+// showText returns the operators showing txt in the current font. UTF-8 fonts
+// show glyph ids; as word spacing does not apply to them, it is rendered with
+// TJ adjustments after each space.
+func (p *Fpdf) showText(txt string) string {
+	t := p.currentFont.ttf
+	if t == nil {
+		return p.showCoreText(p.currentFont.singleByteText(txt))
+	}
+	if len(p.fontFallbacks) > 0 {
+		return p.showFallbackText(txt)
+	}
+	return p.showUTF8Text(txt)
+}
+
+// showFallbackText returns the operators showing text in the current UTF-8
+// font, switching to the fallback fonts for the runs of characters it lacks.
+func (p *Fpdf) showFallbackText(txt string) string {
+	var ops []string
+	start, key := 0, ""
+	flush := func(end int) {
+		if end == start {
+			return
+		}
+		run := txt[start:end]
+		if key == "" {
+			ops = append(ops, p.showUTF8Text(run))
+			return
+		}
+		fb := p.fonts[key]
+		p.usedFonts[key] = true
+		op := sprintf("/F%d %.2F Tf ", fb.i, p.fontSizePt)
+		if fb.ttf != nil {
+			op += sprintf("<%X> Tj", fb.ttf.encode(run))
+		} else {
+			op += "(" + p.escape(fb.singleByteText(run)) + ") Tj"
+		}
+		ops = append(ops, op, sprintf("/F%d %.2F Tf", p.currentFont.i, p.fontSizePt))
+	}
+	for i, r := range txt {
+		if k := p.fallbackFont(r); k != key {
+			flush(i)
+			start, key = i, k
+		}
+	}
+	flush(len(txt))
+	return strings.Join(ops, " ")
+}
+
+// showUTF8Text returns the operator showing text in the current UTF-8 font.
+func (p *Fpdf) showUTF8Text(txt string) string {
+	t := p.currentFont.ttf
+	alias := p.aliasNbPages != "" && strings.Contains(txt, p.aliasNbPages)
+	if !alias && (p.ws == 0 || !strings.Contains(txt, " ")) {
+		return sprintf("<%X> Tj", t.encode(txt))
+	}
+	adj := sprintf("%.3F", -p.ws/p.fontSize*1000)
+	var parts []string
+	add := func(s string) {
+		if p.ws == 0 {
+			if s != "" {
+				parts = append(parts, sprintf("<%X>", t.encode(s)))
+			}
+			return
+		}
+		for _, word := range strings.SplitAfter(s, " ") {
+			if word == "" {
+				continue
+			}
+			parts = append(parts, sprintf("<%X>", t.encode(word)))
+			if strings.HasSuffix(word, " ") {
+				parts = append(parts, adj)
+			}
+		}
+	}
+	// The alias of the number of pages is a string of its own, which
+	// pageContent replaces as a whole.
+	pieces := []string{txt}
+	if alias {
+		pieces = strings.Split(txt, p.aliasNbPages)
+	}
+	for i, s := range pieces {
+		if i > 0 {
+			parts = append(parts, sprintf("<%X>", t.encode(p.aliasNbPages)))
+		}
+		add(s)
+	}
+	return "[" + strings.Join(parts, " ") + "] TJ"
+}
+
+// showCoreText returns the operator showing cp1252 text in a core font, as a
+// TJ array when kerning applies to some of its pairs.
+func (p *Fpdf) showCoreText(txt string) string {
+	kp := p.kernPairs()
+	var parts []string
+	start := 0
+	for i := 1; i < len(txt) && kp != nil; i++ {
+		if k := kp[[2]byte{txt[i-1], txt[i]}]; k != 0 {
+			parts = append(parts, "("+p.escape(txt[start:i])+")", strconv.Itoa(-k))
+			start = i
+		}
+	}
+	if parts == nil {
+		return "(" + p.escape(txt) + ") Tj"
+	}
+	parts = append(parts, "("+p.escape(txt[start:])+")")
+	return "[" + strings.Join(parts, " ") + "] TJ"
+}
+
 func (p *Fpdf) loadFontAsset(file string) (*pdfFont, bool) {
 	key := strings.ToLower(filepath.Base(file))
 	f, ok := p.assetFonts[key]
@@ -1493,6 +5279,7 @@ func (p *Fpdf) loadFontAsset(file string) (*pdfFont, bool) {
 
 // HTML rendering support structures
 type pdfHTMLStyle struct {
+	tag                    string
 	colorR, colorG, colorB float64
 	fontFamily             string
 	fontStyle              string
@@ -1506,6 +5293,7 @@ type pdfHTMLState struct {
 	boldCount      int
 	italicCount    int
 	underlineCount int
+	strikeCount    int
 	href           string
 	pre            bool
 
@@ -1532,28 +5320,62 @@ type pdfHTMLState struct {
 	tdColorR, tdColorG, tdColorB float64
 	tdColorSet                   bool
 
+	rowCells []pdfHTMLCell
+	inHead   bool
+	headRows [][]pdfHTMLCell
+
+	inCaption     bool
+	caption       string
+	captionBottom bool
+	captionDrawn  bool
+	tableWidth    float64
+
 	styleStack []pdfHTMLStyle
 
 	fontSet  bool
 	colorSet bool
 
-	listDepth int
-	listType  string
-	listCount int
-	listStack []pdfHTMLListState
-	currAlign string
+	listDepth  int
+	listType   string
+	listCount  int
+	listStack  []pdfHTMLListState
+	currAlign  string
+	alignStack []string
+	runs       []pdfHTMLRun
+	headingH   float64
 
 	defaultFontSize float64
-	scriptActive    bool
-	scriptDeltaY    float64
+}
+
+type pdfHTMLCell struct {
+	text                string
+	width               string
+	align               string
+	header              bool
+	fill                bool
+	fillR, fillG, fillB float64
+}
+
+// pdfHTMLRun is a piece of text buffered in an aligned block, together with
+// the font, color and link it is printed with.
+type pdfHTMLRun struct {
+	text      string
+	family    string
+	style     string
+	size      float64
+	rise      float64
+	textColor string
+	href      string
 }
 
 type pdfHTMLListState struct {
 	listType  string
 	listCount int
+	lMargin   float64
 }
 
 func (s *pdfHTMLState) renderHTML(input string) {
+	scripts := len(s.p.scripts)
 	tagRe := regexp.MustCompile(`(?is)<[^>]+>`)
 	segments := tagRe.FindAllStringIndex(input, -1)
 	pos := 0
@@ -1564,8 +5386,20 @@ func (s *pdfHTMLState) renderHTML(input string) {
 		s.handleTag(input[seg[0]:seg[1]])
 		pos = seg[1]
 	}
-	if pos < len(input) {
-		s.handleText(input[pos:])
+	if pos < len(input) {
+		s.handleText(input[pos:])
+	}
+	s.flushRuns()
+	// Elements left open must not change the font, baseline or margin of
+	// what is printed after the HTML.
+	for len(s.p.scripts) > scripts {
+		s.p.SetSuperscript(false)
+	}
+	if s.headingH > 0 {
+		s.closeHeading()
+	}
+	for s.listDepth > 0 {
+		s.closeList()
 	}
 }
 
@@ -1579,10 +5413,20 @@ func (s *pdfHTMLState) handleText(raw string) {
 		text = re.ReplaceAllString(text, " ")
 	}
 	text = stdhtml.UnescapeString(text)
-	text = normalizeHTMLTextForPDF(text)
+	if s.p.currentFont == nil || s.p.currentFont.ttf == nil {
+		text = normalizeHTMLTextForPDF(text)
+	}
 	if text == "" {
 		return
 	}
+	if s.inCaption {
+		s.caption += text
+		return
+	}
+	if s.currAlign != "L" && !s.tdBegin && !s.thBegin && !s.inTable {
+		s.addRun(text)
+		return
+	}
 	if s.href != "" {
 		s.putLink(s.href, text)
 		return
@@ -1594,7 +5438,23 @@ func (s *pdfHTMLState) handleText(raw string) {
 	if (s.inTable || s.inRow) && strings.TrimSpace(text) == "" {
 		return
 	}
-	s.p.Write(5, text, "")
+	if s.listDepth > 0 && s.p.x == s.p.lMargin {
+		// Spaces between list tags or after a marker would indent the
+		// item.
+		if text = strings.TrimLeft(text, " "); text == "" {
+			return
+		}
+	}
+	s.p.Write(s.lineHeight(), text, "")
+}
+
+// lineHeight returns the height of the lines of flowing text, which is larger
+// inside a heading.
+func (s *pdfHTMLState) lineHeight() float64 {
+	if s.headingH > 0 {
+		return s.headingH
+	}
+	return 5
 }
 
 func (s *pdfHTMLState) handleTag(rawTag string) {
@@ -1635,38 +5495,606 @@ func (s *pdfHTMLState) openTag(tag string, attrs map[string]string) {
 			s.p.SetFillColor(float64(r), float64(g), float64(b))
 			s.tdBgColor = true
 		}
+		if tag != "BODY" {
+			s.setCSSFont(tag, css)
+		}
 	}
 	switch tag {
+	case "BODY":
+		css := parseCSSStyle(attrs["STYLE"])
+		family := ""
+		if v, ok := css["font-family"]; ok {
+			family = s.p.cssFontFamily(v)
+		}
+		size := 0.0
+		if v, ok := css["font-size"]; ok {
+			size = cssFontSize(v, s.defaultFontSize)
+		}
+		if family != "" || size > 0 {
+			style := s.p.fontStyle
+			if s.p.underline {
+				style += "U"
+			}
+			if s.p.strikeout {
+				style += "S"
+			}
+			s.p.SetFont(family, style, size)
+			s.defaultFontSize = s.p.fontSizePt
+		}
 	case "STRONG", "B":
 		s.setStyle("B", true)
 	case "EM", "I":
 		s.setStyle("I", true)
-	case "U":
+	case "U", "INS":
 		s.setStyle("U", true)
+	case "S", "DEL", "STRIKE":
+		s.setStyle("S", true)
+	case "SUP":
+		s.p.SetSuperscript(true)
+	case "SUB":
+		s.p.SetSubscript(true)
+	case "WBR":
+		// Flowing text after the tag is laid out separately, by its own
+		// Write call or in its own run, and both move a piece that does not
+		// fit to the next line. Table cells are wrapped as a whole.
+		if s.tdBegin || s.thBegin {
+			s.cellText += zeroWidthSpace
+		}
+	case "IMG":
+		s.image(attrs)
 	case "BR":
-		s.p.Ln(5)
+		if len(s.runs) > 0 {
+			s.addRun("\n")
+		} else {
+			s.p.Ln(s.lineHeight())
+		}
+	case "H1", "H2", "H3", "H4", "H5", "H6":
+		level := int(tag[1] - '0')
+		size := s.p.htmlHeadingSize(level)
+		if v, ok := parseCSSStyle(attrs["STYLE"])["font-size"]; ok && cssFontSize(v, s.defaultFontSize) > 0 {
+			size = cssFontSize(v, s.defaultFontSize)
+		}
+		s.openHeading(level, size)
+	case "UL", "OL":
+		s.openList(tag, toInt(attrs["START"]))
+	case "LI":
+		s.listItem()
 	case "P", "DIV":
+		s.flushRuns()
 		s.p.Ln(5)
+		s.alignStack = append(s.alignStack, s.currAlign)
+		align := attrs["ALIGN"]
+		if v, ok := parseCSSStyle(attrs["STYLE"])["text-align"]; ok {
+			align = v
+		}
+		switch strings.ToLower(align) {
+		case "left":
+			s.currAlign = "L"
+		case "center":
+			s.currAlign = "C"
+		case "right":
+			s.currAlign = "R"
+		case "justify":
+			s.currAlign = "J"
+		}
 	case "A":
 		s.href = attrs["HREF"]
 		s.p.SetTextColor(0, 0, 255)
 		s.setStyle("U", true)
+	case "TABLE":
+		s.flushRuns()
+		if s.p.x > s.p.lMargin {
+			s.p.Ln(5)
+		}
+		s.inTable = true
+		s.tableBorder = toInt(attrs["BORDER"])
+		s.cellPadding = toFloat(attrs["CELLPADDING"])
+		s.tableColWidths = make(map[int]float64)
+		s.headRows = nil
+		s.caption = ""
+		s.captionDrawn = false
+	case "CAPTION":
+		s.inCaption = true
+		s.caption = ""
+		side := strings.ToLower(attrs["ALIGN"])
+		if v, ok := parseCSSStyle(attrs["STYLE"])["caption-side"]; ok {
+			side = strings.ToLower(v)
+		}
+		s.captionBottom = side == "bottom"
+	case "THEAD":
+		s.inHead = true
+	case "TR":
+		s.inRow = true
+		s.rowCells = nil
+		s.colIndex = 0
+	case "TD", "TH":
+		s.tdBegin = tag == "TD"
+		s.thBegin = tag == "TH"
+		s.cellText = ""
+		s.tdWidthAttr = attrs["WIDTH"]
+		s.tdAlign = "L"
+		if s.thBegin {
+			s.tdAlign = "C"
+		}
+		switch strings.ToUpper(attrs["ALIGN"]) {
+		case "LEFT":
+			s.tdAlign = "L"
+		case "CENTER":
+			s.tdAlign = "C"
+		case "RIGHT":
+			s.tdAlign = "R"
+		}
+		s.tdColorSet = false
+		bg := attrs["BGCOLOR"]
+		if v, ok := parseCSSStyle(attrs["STYLE"])["background-color"]; ok {
+			bg = v
+		}
+		if bg != "" {
+			r, g, b := htmlColorToRGB(bg)
+			s.tdColorR, s.tdColorG, s.tdColorB = float64(r), float64(g), float64(b)
+			s.tdColorSet = true
+		}
 	}
 }
 
 func (s *pdfHTMLState) closeTag(tag string) {
+	defer s.restoreFont(tag)
 	switch tag {
 	case "STRONG", "B":
 		s.setStyle("B", false)
 	case "EM", "I":
 		s.setStyle("I", false)
-	case "U":
+	case "U", "INS":
 		s.setStyle("U", false)
+	case "S", "DEL", "STRIKE":
+		s.setStyle("S", false)
+	case "SUP", "SUB":
+		s.p.SetSuperscript(false)
 	case "A":
 		s.href = ""
 		s.setStyle("U", false)
 		s.p.SetTextColor(0, math.NaN(), math.NaN())
+	case "TD", "TH":
+		if !s.tdBegin && !s.thBegin {
+			return
+		}
+		s.rowCells = append(s.rowCells, pdfHTMLCell{
+			text:   strings.TrimSpace(s.cellText),
+			width:  s.tdWidthAttr,
+			align:  s.tdAlign,
+			header: s.thBegin,
+			fill:   s.tdColorSet,
+			fillR:  s.tdColorR,
+			fillG:  s.tdColorG,
+			fillB:  s.tdColorB,
+		})
+		s.tdBegin = false
+		s.thBegin = false
+		s.cellText = ""
+		s.colIndex++
+	case "TR":
+		if !s.inRow {
+			return
+		}
+		s.inRow = false
+		if len(s.rowCells) == 0 {
+			return
+		}
+		rowH := s.rowHeight(s.rowCells)
+		begun := s.captionDrawn
+		if !s.captionDrawn && s.caption != "" && !s.captionBottom {
+			// Keep the caption on the page of the first row.
+			if s.p.y+5+rowH > s.p.pageBreakTrigger && !s.p.inHeader && !s.p.inFooter && s.p.AcceptPageBreak() {
+				s.p.AddPage(s.p.curOrientation, "", s.p.curRotation)
+			}
+			s.drawCaption(s.caption, s.rowCells)
+		}
+		s.captionDrawn = true
+		if s.inHead {
+			s.headRows = append(s.headRows, s.rowCells)
+		} else if s.p.y+rowH > s.p.pageBreakTrigger && !s.p.inHeader && !s.p.inFooter && s.p.AcceptPageBreak() {
+			if begun {
+				s.p.putContinuation()
+			}
+			s.p.AddPage(s.p.curOrientation, "", s.p.curRotation)
+			if s.caption != "" && !s.captionBottom {
+				caption := s.caption
+				if s.p.continuationText != "" {
+					caption += " " + s.p.continuationText
+				}
+				s.drawCaption(caption, s.rowCells)
+			}
+			for _, row := range s.headRows {
+				s.drawRow(row)
+			}
+		}
+		s.drawRow(s.rowCells)
+		s.rowCells = nil
+	case "H1", "H2", "H3", "H4", "H5", "H6":
+		s.closeHeading()
+	case "LI":
+		s.flushRuns()
+	case "UL", "OL":
+		s.closeList()
+	case "P", "DIV":
+		s.flushRuns()
+		if n := len(s.alignStack); n > 0 {
+			s.currAlign = s.alignStack[n-1]
+			s.alignStack = s.alignStack[:n-1]
+		}
+	case "CAPTION":
+		s.inCaption = false
+		s.caption = strings.TrimSpace(s.caption)
+	case "THEAD":
+		s.inHead = false
+	case "TABLE":
+		if s.caption != "" && s.captionBottom {
+			s.drawCaption(s.caption, nil)
+		}
+		s.caption = ""
+		s.inTable = false
+		s.inHead = false
+		s.headRows = nil
+		s.p.x = s.p.lMargin
+	}
+}
+
+// openHeading starts a heading of level on a new line, after some spacing,
+// in bold at size points. The font in effect before it is saved on the style
+// stack.
+func (s *pdfHTMLState) openHeading(level int, size float64) {
+	p := s.p
+	s.flushRuns()
+	if p.x > p.lMargin {
+		p.Ln(s.lineHeight())
+	}
+	style := p.fontStyle
+	if p.underline {
+		style += "U"
+	}
+	if p.strikeout {
+		style += "S"
+	}
+	s.styleStack = append(s.styleStack, pdfHTMLStyle{tag: sprintf("H%d", level), fontFamily: p.fontFamily, fontStyle: style, fontSize: p.fontSizePt})
+	p.Ln(size / p.k / 2)
+	p.SetFontSize(size)
+	s.setStyle("B", true)
+	s.headingH = 1.25 * p.fontSize
+}
+
+// closeHeading ends the current heading line, leaves some spacing below it
+// and restores the font saved by openHeading.
+func (s *pdfHTMLState) closeHeading() {
+	n := len(s.styleStack)
+	if s.headingH == 0 || n == 0 {
+		return
+	}
+	p := s.p
+	if len(s.runs) > 0 {
+		s.flushRuns()
+		p.Ln(p.fontSize / 2)
+	} else {
+		p.Ln(s.headingH + p.fontSize/2)
+	}
+	s.headingH = 0
+	s.setStyle("B", false)
+	st := s.styleStack[n-1]
+	s.styleStack = s.styleStack[:n-1]
+	p.SetFont(st.fontFamily, st.fontStyle, st.fontSize)
+}
+
+// setCSSFont applies the font-family and font-size properties of the style
+// of tag. The font in effect before it is saved on the style stack, to be
+// restored by the closing tag. Families that are not available fall back to
+// helvetica.
+func (s *pdfHTMLState) setCSSFont(tag string, css map[string]string) {
+	cssFamily, hasFamily := css["font-family"]
+	cssSize, hasSize := css["font-size"]
+	if !hasFamily && !hasSize {
+		return
+	}
+	p := s.p
+	style := p.fontStyle
+	if p.underline {
+		style += "U"
+	}
+	if p.strikeout {
+		style += "S"
+	}
+	s.styleStack = append(s.styleStack, pdfHTMLStyle{tag: tag, fontFamily: p.fontFamily, fontStyle: style, fontSize: p.fontSizePt})
+	family := ""
+	if hasFamily {
+		if family = p.cssFontFamily(cssFamily); family == "" {
+			family = "helvetica"
+		}
+	}
+	size := 0.0
+	if hasSize {
+		size = cssFontSize(cssSize, s.defaultFontSize)
+	}
+	p.SetFont(family, style, size)
+}
+
+// restoreFont restores the font saved by setCSSFont when tag closes.
+func (s *pdfHTMLState) restoreFont(tag string) {
+	n := len(s.styleStack)
+	if n == 0 || s.styleStack[n-1].tag != tag {
+		return
+	}
+	st := s.styleStack[n-1]
+	s.styleStack = s.styleStack[:n-1]
+	s.p.SetFont(st.fontFamily, st.fontStyle, st.fontSize)
+}
+
+// htmlListIndent is the indentation, in user units, of each level of HTML
+// lists. The markers of the items are printed in it.
+const htmlListIndent = 8
+
+// openList starts a list of type tag ("UL" or "OL") nested in the current
+// one, indented by one more level. The items of an ordered list are numbered
+// from start, or from 1 when start is not positive.
+func (s *pdfHTMLState) openList(tag string, start int) {
+	p := s.p
+	s.flushRuns()
+	if p.x > p.lMargin {
+		p.Ln(s.lineHeight())
+	}
+	s.listStack = append(s.listStack, pdfHTMLListState{listType: s.listType, listCount: s.listCount, lMargin: p.lMargin})
+	s.listDepth++
+	s.listType = tag
+	s.listCount = 0
+	if start > 0 {
+		s.listCount = start - 1
+	}
+	p.lMargin += htmlListIndent
+	p.x = p.lMargin
+}
+
+// listItem starts an item of the current list on a new line, with its bullet
+// or number printed in the indentation before it.
+func (s *pdfHTMLState) listItem() {
+	if s.listDepth == 0 {
+		return
+	}
+	p := s.p
+	s.flushRuns()
+	if p.x > p.lMargin {
+		p.Ln(s.lineHeight())
+	}
+	s.listCount++
+	marker := "\u2022"
+	if s.listType == "OL" {
+		marker = strconv.Itoa(s.listCount) + "."
+	}
+	p.SetX(p.lMargin - htmlListIndent)
+	p.Cell(htmlListIndent, s.lineHeight(), marker, 0, 0, "R", false, "")
+	p.x = p.lMargin
+}
+
+// closeList ends the current list and goes back to the list it is nested in.
+func (s *pdfHTMLState) closeList() {
+	if s.listDepth == 0 {
+		return
+	}
+	p := s.p
+	s.flushRuns()
+	if p.x > p.lMargin {
+		p.Ln(s.lineHeight())
+	}
+	n := len(s.listStack)
+	p.lMargin = s.listStack[n-1].lMargin
+	p.x = p.lMargin
+	s.listType, s.listCount = s.listStack[n-1].listType, s.listStack[n-1].listCount
+	s.listStack = s.listStack[:n-1]
+	s.listDepth--
+}
+
+// image places the picture of an IMG tag below the current line, at the size
+// given by its width and height attributes or CSS properties. Without them,
+// the picture keeps its natural size at 96 dpi, reduced to the content width
+// if it is wider. The align attribute, or else the alignment of the enclosing
+// block, places it at the left, center or right. Remote pictures are not
+// fetched: the failure is recorded and reported by Error.
+func (s *pdfHTMLState) image(attrs map[string]string) {
+	src := strings.TrimSpace(attrs["SRC"])
+	if src == "" {
+		return
+	}
+	if u := strings.ToLower(src); strings.HasPrefix(u, "http://") || strings.HasPrefix(u, "https://") || strings.HasPrefix(u, "//") {
+		s.p.setError("remote image not supported: " + src)
+		return
+	}
+	css := parseCSSStyle(attrs["STYLE"])
+	width, height := attrs["WIDTH"], attrs["HEIGHT"]
+	if v, ok := css["width"]; ok {
+		width = v
+	}
+	if v, ok := css["height"]; ok {
+		height = v
+	}
+	s.flushRuns()
+	if s.p.x > s.p.lMargin {
+		s.p.Ln(5)
+	}
+	avail := s.p.w - s.p.lMargin - s.p.rMargin
+	var link interface{}
+	if s.href != "" {
+		link = s.href
+	}
+	w, h := s.p.htmlLength(width, avail), s.p.htmlLength(height, avail)
+	align := s.currAlign
+	switch strings.ToLower(attrs["ALIGN"]) {
+	case "left":
+		align = "L"
+	case "center", "middle":
+		align = "C"
+	case "right":
+		align = "R"
+	}
+	if w == 0 && (h == 0 || align == "C" || align == "R") {
+		if info, err := s.p.tryRegisterImage(src, ""); err == nil {
+			iw, ih := info.size()
+			if h == 0 {
+				w = math.Min(iw*htmlPixel/s.p.k, avail)
+			} else {
+				w = h * iw / ih
+			}
+		}
+	}
+	x := s.p.x
+	switch align {
+	case "C":
+		x = s.p.lMargin + (avail-w)/2
+	case "R":
+		x = s.p.w - s.p.rMargin - w
+	}
+	s.p.Image(src, x, math.NaN(), w, h, "", link)
+}
+
+// htmlPixel is the size of an HTML pixel in points, 96 pixels to the inch as
+// in CSS.
+const htmlPixel = 0.75
+
+// htmlLength converts an HTML or CSS length to user units. Bare numbers are
+// pixels, as for the natural size of images, and percentages are relative to
+// ref. Zero is returned for empty or invalid
+// lengths.
+func (p *Fpdf) htmlLength(v string, ref float64) float64 {
+	v = strings.ToLower(strings.TrimSpace(v))
+	if strings.HasSuffix(v, "%") {
+		n, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(v, "%")), 64)
+		if err != nil || n <= 0 {
+			return 0
+		}
+		return ref * n / 100
+	}
+	units := []struct {
+		suffix string
+		factor float64
+	}{{"pt", 1}, {"px", htmlPixel}, {"mm", 72 / 25.4}, {"cm", 72 / 2.54}, {"in", 72}}
+	factor := htmlPixel
+	for _, u := range units {
+		if strings.HasSuffix(v, u.suffix) {
+			v = strings.TrimSpace(strings.TrimSuffix(v, u.suffix))
+			factor = u.factor
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n <= 0 {
+		return 0
+	}
+	return n * factor / p.k
+}
+
+// cellWidths resolves the widths of the cells of a table row. Explicit widths
+// (absolute or percentages of the content width) are remembered per column so
+// that later rows line up; the remaining space is shared by the other columns.
+func (s *pdfHTMLState) cellWidths(cells []pdfHTMLCell) []float64 {
+	avail := s.p.w - s.p.lMargin - s.p.rMargin
+	widths := make([]float64, len(cells))
+	used := 0.0
+	free := 0
+	for i, c := range cells {
+		if v := strings.TrimSpace(c.width); v != "" {
+			if strings.HasSuffix(v, "%") {
+				widths[i] = avail * toFloat(strings.TrimSuffix(v, "%")) / 100
+			} else {
+				widths[i] = toFloat(v)
+			}
+			s.tableColWidths[i] = widths[i]
+		} else if w, ok := s.tableColWidths[i]; ok {
+			widths[i] = w
+		}
+		if widths[i] > 0 {
+			used += widths[i]
+		} else {
+			free++
+		}
+	}
+	if free > 0 {
+		share := math.Max(avail-used, 0) / float64(free)
+		for i := range widths {
+			if widths[i] <= 0 {
+				widths[i] = share
+			}
+		}
+	}
+	return widths
+}
+
+// drawCaption prints a table caption in bold, centered over the width of the
+// table. The width is taken from cells, or from the last row drawn when cells
+// is nil.
+func (s *pdfHTMLState) drawCaption(text string, cells []pdfHTMLCell) {
+	w := s.tableWidth
+	if cells != nil {
+		w = 0
+		for _, cw := range s.cellWidths(cells) {
+			w += cw
+		}
+	}
+	s.setStyle("B", true)
+	s.p.SetX(s.p.lMargin)
+	s.p.Cell(w, 5, text, 0, 1, "C", false, "")
+	s.setStyle("B", false)
+}
+
+// rowHeight returns the height a table row takes once its cells are wrapped.
+func (s *pdfHTMLState) rowHeight(cells []pdfHTMLCell) float64 {
+	widths := s.cellWidths(cells)
+	lines := 1
+	for i, c := range cells {
+		if c.header {
+			s.setStyle("B", true)
+		}
+		lines = maxInt(lines, len(s.p.lineRanges(c.text, widths[i])))
+		if c.header {
+			s.setStyle("B", false)
+		}
+	}
+	return float64(lines)*5 + 2*s.cellPadding
+}
+
+// drawRow renders a buffered table row at the current position and moves
+// below it.
+func (s *pdfHTMLState) drawRow(cells []pdfHTMLCell) {
+	p := s.p
+	widths := s.cellWidths(cells)
+	rowH := s.rowHeight(cells)
+	s.tableWidth = 0
+	for _, w := range widths {
+		s.tableWidth += w
+	}
+	y := p.y
+	x := p.lMargin
+	auto := p.autoPageBreak
+	p.autoPageBreak = false
+	for i, c := range cells {
+		if c.fill {
+			fc, cf := p.fillColor, p.colorFlag
+			p.SetFillColor(c.fillR, c.fillG, c.fillB)
+			p.Rect(x, y, widths[i], rowH, "F")
+			p.fillColor, p.colorFlag = fc, cf
+			p.out(fc)
+		}
+		if s.tableBorder > 0 {
+			p.Rect(x, y, widths[i], rowH, "D")
+		}
+		if c.header {
+			s.setStyle("B", true)
+		}
+		p.SetXY(x, y+s.cellPadding)
+		for _, r := range p.lineRanges(c.text, widths[i]) {
+			p.Cell(widths[i], 5, r.text(c.text), 0, 2, c.align, false, "")
+		}
+		if c.header {
+			s.setStyle("B", false)
+		}
+		x += widths[i]
 	}
+	p.autoPageBreak = auto
+	p.SetXY(p.lMargin, y+rowH)
 }
 
 func (s *pdfHTMLState) setStyle(tag string, enable bool) {
@@ -1689,6 +6117,12 @@ func (s *pdfHTMLState) setStyle(tag string, enable bool) {
 		} else if s.underlineCount > 0 {
 			s.underlineCount--
 		}
+	case "S":
+		if enable {
+			s.strikeCount++
+		} else if s.strikeCount > 0 {
+			s.strikeCount--
+		}
 	}
 	style := ""
 	if s.boldCount > 0 {
@@ -1700,17 +6134,182 @@ func (s *pdfHTMLState) setStyle(tag string, enable bool) {
 	if s.underlineCount > 0 {
 		style += "U"
 	}
+	if s.strikeCount > 0 {
+		style += "S"
+	}
 	s.p.SetFont("", style, 0)
 }
 
 func (s *pdfHTMLState) putLink(url, text string) {
 	s.p.SetTextColor(0, 0, 255)
 	s.setStyle("U", true)
-	s.p.Write(5, text, url)
+	s.p.Write(s.lineHeight(), text, url)
 	s.setStyle("U", false)
 	s.p.SetTextColor(0, math.NaN(), math.NaN())
 }
 
+// addRun buffers text for the current aligned block with the current font,
+// color and link.
+func (s *pdfHTMLState) addRun(text string) {
+	p := s.p
+	style := p.fontStyle
+	if p.underline {
+		style += "U"
+	}
+	if p.strikeout {
+		style += "S"
+	}
+	s.runs = append(s.runs, pdfHTMLRun{text: text, family: p.fontFamily, style: style, size: p.fontSizePt, rise: p.rise, textColor: p.textColor, href: s.href})
+}
+
+// flushRuns prints the buffered runs of an aligned block as lines spanning
+// the width between the margins, aligned as the block requested.
+func (s *pdfHTMLState) flushRuns() {
+	if len(s.runs) == 0 {
+		return
+	}
+	p := s.p
+	family, style, size := p.fontFamily, p.fontStyle, p.fontSizePt
+	if p.underline {
+		style += "U"
+	}
+	if p.strikeout {
+		style += "S"
+	}
+	textColor, rise := p.textColor, p.rise
+	runs := s.runs
+	s.runs = nil
+	if p.x > p.lMargin {
+		p.Ln(5)
+	}
+
+	type piece struct {
+		run  int
+		text string
+		w    float64
+	}
+	wmax := p.w - p.lMargin - p.rMargin - 2*p.cMargin
+	var line []piece
+	lw := 0.0
+	emit := func(last bool) {
+		for len(line) > 0 && line[len(line)-1].text == " " {
+			lw -= line[len(line)-1].w
+			line = line[:len(line)-1]
+		}
+		dx := 0.0
+		ws := 0.0
+		switch s.currAlign {
+		case "C":
+			dx = (wmax - lw) / 2
+		case "R":
+			dx = wmax - lw
+		case "J":
+			ns := 0
+			for _, pc := range line {
+				if pc.text == " " {
+					ns++
+				}
+			}
+			if !last && ns > 0 {
+				ws = (wmax - lw) / float64(ns)
+			}
+		}
+		p.x = p.lMargin + dx
+		if ws > 0 {
+			p.ws = ws
+			p.out(sprintf("%.3F Tw", ws*p.k))
+		}
+		for i := 0; i < len(line); {
+			run := line[i].run
+			text, w := "", 0.0
+			for ; i < len(line) && line[i].run == run; i++ {
+				text += line[i].text
+				w += line[i].w
+			}
+			r := runs[run]
+			s.setRunFont(r)
+			p.Cell(w+ws*float64(strings.Count(text, " ")), 5, text, 0, 0, "", false, r.href)
+		}
+		if ws > 0 {
+			p.ws = 0
+			p.out("0 Tw")
+		}
+		p.Ln(5)
+		line = nil
+		lw = 0
+	}
+	for i, r := range runs {
+		s.setRunFont(r)
+		for _, word := range splitWords(r.text) {
+			if word == "\n" {
+				emit(true)
+				continue
+			}
+			if word == " " && len(line) == 0 {
+				continue
+			}
+			w := p.GetStringWidth(word)
+			if word != " " && len(line) > 0 && lw+w > wmax {
+				emit(false)
+			}
+			line = append(line, piece{run: i, text: word, w: w})
+			lw += w
+		}
+	}
+	if len(line) > 0 {
+		emit(true)
+	}
+	p.SetFont(family, style, size)
+	if p.rise != rise {
+		p.SetTextRise(rise)
+	}
+	p.textColor = textColor
+	p.colorFlag = p.fillColor != p.textColor
+}
+
+func (s *pdfHTMLState) setRunFont(r pdfHTMLRun) {
+	s.p.SetFont(r.family, r.style, r.size)
+	if s.p.rise != r.rise {
+		s.p.SetTextRise(r.rise)
+	}
+	s.p.textColor = r.textColor
+	s.p.colorFlag = s.p.fillColor != s.p.textColor
+}
+
+// splitWords splits text into words, single spaces and line breaks.
+func splitWords(text string) []string {
+	var words []string
+	start := 0
+	for i := 0; i < len(text); i++ {
+		if text[i] == ' ' || text[i] == '\n' {
+			if i > start {
+				words = append(words, text[start:i])
+			}
+			words = append(words, text[i:i+1])
+			start = i + 1
+		}
+	}
+	if start < len(text) {
+		words = append(words, text[start:])
+	}
+	return words
+}
+
+// code128Patterns holds the bar/space module widths of the Code 128 symbols.
+var code128Patterns = [...]string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232",
+}
+
 // Utility functions
 func sprintf(format string, args ...interface{}) string { return fmt.Sprintf(format, args...) }
 func toInt(v interface{}) int {
@@ -1739,6 +6338,24 @@ func toFloat(v interface{}) float64 {
 		return 0
 	}
 }
+func colorComponents(cs string) int {
+	switch cs {
+	case "DeviceGray":
+		return 1
+	case "DeviceCMYK":
+		return 4
+	default:
+		return 3
+	}
+}
+func sortedInts(m map[int]int) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
 func containsString(list []string, v string) bool {
 	for _, x := range list {
 		if x == v {
@@ -1747,6 +6364,29 @@ func containsString(list []string, v string) bool {
 	}
 	return false
 }
+func equalFloats(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
 func maxInt(a, b int) int {
 	if a > b {
 		return a
@@ -1803,14 +6443,39 @@ func normalizeHTMLTextForPDF(text string) string {
 	var b strings.Builder
 	b.Grow(len(text))
 	for _, r := range text {
-		if r >= 0 && r <= 255 {
-			b.WriteByte(byte(r))
+		if c, ok := winAnsiByte(r); ok {
+			b.WriteByte(c)
 		} else {
 			b.WriteByte('?')
 		}
 	}
 	return b.String()
 }
+
+// winAnsiByte returns the WinAnsi (cp1252) code of r, which covers Latin-1
+// plus typographic characters such as dashes, curly quotes, the ellipsis and
+// the euro sign. A few other symbols are mapped to a close WinAnsi character.
+func winAnsiByte(r rune) (byte, bool) {
+	if r >= 0 && r <= 255 {
+		return byte(r), true
+	}
+	for i, c := range codePages["cp1252"] {
+		if c == r {
+			return byte(128 + i), true
+		}
+	}
+	switch r {
+	case '\u2212', '\u2010', '\u2011':
+		return '-', true
+	case '\u2032':
+		return '\'', true
+	case '\u2033':
+		return '"', true
+	case '\u2009', '\u200A', '\u2002', '\u2003':
+		return ' ', true
+	}
+	return 0, false
+}
 func parseHTMLTag(content string) (string, map[string]string) {
 	attrs := map[string]string{}
 	parts := strings.Fields(content)
@@ -1849,6 +6514,248 @@ func parseCSSStyle(style string) map[string]string {
 	}
 	return styles
 }
+
+// cssFontFamily returns the first family of a CSS font-family list that is a
+// core font, a generic family or a font added to the document, or an empty
+// string if there is none.
+func (p *Fpdf) cssFontFamily(v string) string {
+	generic := map[string]string{
+		"sans-serif":      "helvetica",
+		"arial":           "helvetica",
+		"serif":           "times",
+		"times new roman": "times",
+		"monospace":       "courier",
+		"courier new":     "courier",
+	}
+	for _, name := range strings.Split(v, ",") {
+		name = strings.ToLower(strings.Trim(strings.TrimSpace(name), `"'`))
+		if f, ok := generic[name]; ok {
+			return f
+		}
+		if containsString(p.coreFonts, name) {
+			return name
+		}
+		if _, ok := p.fonts[name]; ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// cssFontSize converts a CSS font-size to points. Relative sizes (em and %)
+// are based on base; unknown values give 0.
+func cssFontSize(v string, base float64) float64 {
+	v = strings.ToLower(strings.TrimSpace(v))
+	units := []struct {
+		suffix string
+		factor float64
+	}{{"pt", 1}, {"px", htmlPixel}, {"rem", base}, {"em", base}, {"%", base / 100}, {"mm", 72 / 25.4}, {"cm", 72 / 2.54}, {"in", 72}}
+	for _, u := range units {
+		if strings.HasSuffix(v, u.suffix) {
+			n, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(v, u.suffix)), 64)
+			if err != nil || n <= 0 {
+				return 0
+			}
+			return n * u.factor
+		}
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n <= 0 {
+		return 0
+	}
+	return n
+}
+
+// htmlColorToRGB parses a CSS color given as #rgb, #rrggbb, rgb(r,g,b) or a
+// named color. Black is returned when the value cannot be parsed.
 func htmlColorToRGB(color string) (int, int, int) {
-	return 0, 0, 0 // Simplified for brevity
+	color = strings.ToLower(strings.TrimSpace(color))
+	if strings.HasPrefix(color, "#") {
+		hex := color[1:]
+		if len(hex) == 3 {
+			hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+		}
+		if len(hex) == 6 {
+			if v, err := strconv.ParseUint(hex, 16, 32); err == nil {
+				return int(v >> 16), int(v >> 8 & 0xff), int(v & 0xff)
+			}
+		}
+		return 0, 0, 0
+	}
+	if strings.HasPrefix(color, "rgb(") && strings.HasSuffix(color, ")") {
+		parts := strings.Split(color[4:len(color)-1], ",")
+		if len(parts) != 3 {
+			return 0, 0, 0
+		}
+		var c [3]int
+		for i, part := range parts {
+			part = strings.TrimSpace(part)
+			percent := strings.HasSuffix(part, "%")
+			n, err := strconv.ParseFloat(strings.TrimSuffix(part, "%"), 64)
+			if err != nil {
+				return 0, 0, 0
+			}
+			if percent {
+				n = n * 255 / 100
+			}
+			c[i] = int(math.Round(math.Max(0, math.Min(255, n))))
+		}
+		return c[0], c[1], c[2]
+	}
+	if c, ok := htmlNamedColors[color]; ok {
+		return c[0], c[1], c[2]
+	}
+	return 0, 0, 0
+}
+
+// htmlNamedColors holds the CSS named colors.
+var htmlNamedColors = map[string][3]int{
+	"aliceblue":            {240, 248, 255},
+	"antiquewhite":         {250, 235, 215},
+	"aqua":                 {0, 255, 255},
+	"aquamarine":           {127, 255, 212},
+	"azure":                {240, 255, 255},
+	"beige":                {245, 245, 220},
+	"bisque":               {255, 228, 196},
+	"black":                {0, 0, 0},
+	"blanchedalmond":       {255, 235, 205},
+	"blue":                 {0, 0, 255},
+	"blueviolet":           {138, 43, 226},
+	"brown":                {165, 42, 42},
+	"burlywood":            {222, 184, 135},
+	"cadetblue":            {95, 158, 160},
+	"chartreuse":           {127, 255, 0},
+	"chocolate":            {210, 105, 30},
+	"coral":                {255, 127, 80},
+	"cornflowerblue":       {100, 149, 237},
+	"cornsilk":             {255, 248, 220},
+	"crimson":              {220, 20, 60},
+	"cyan":                 {0, 255, 255},
+	"darkblue":             {0, 0, 139},
+	"darkcyan":             {0, 139, 139},
+	"darkgoldenrod":        {184, 134, 11},
+	"darkgray":             {169, 169, 169},
+	"darkgreen":            {0, 100, 0},
+	"darkgrey":             {169, 169, 169},
+	"darkkhaki":            {189, 183, 107},
+	"darkmagenta":          {139, 0, 139},
+	"darkolivegreen":       {85, 107, 47},
+	"darkorange":           {255, 140, 0},
+	"darkorchid":           {153, 50, 204},
+	"darkred":              {139, 0, 0},
+	"darksalmon":           {233, 150, 122},
+	"darkseagreen":         {143, 188, 143},
+	"darkslateblue":        {72, 61, 139},
+	"darkslategray":        {47, 79, 79},
+	"darkslategrey":        {47, 79, 79},
+	"darkturquoise":        {0, 206, 209},
+	"darkviolet":           {148, 0, 211},
+	"deeppink":             {255, 20, 147},
+	"deepskyblue":          {0, 191, 255},
+	"dimgray":              {105, 105, 105},
+	"dimgrey":              {105, 105, 105},
+	"dodgerblue":           {30, 144, 255},
+	"firebrick":            {178, 34, 34},
+	"floralwhite":          {255, 250, 240},
+	"forestgreen":          {34, 139, 34},
+	"fuchsia":              {255, 0, 255},
+	"gainsboro":            {220, 220, 220},
+	"ghostwhite":           {248, 248, 255},
+	"gold":                 {255, 215, 0},
+	"goldenrod":            {218, 165, 32},
+	"gray":                 {128, 128, 128},
+	"green":                {0, 128, 0},
+	"greenyellow":          {173, 255, 47},
+	"grey":                 {128, 128, 128},
+	"honeydew":             {240, 255, 240},
+	"hotpink":              {255, 105, 180},
+	"indianred":            {205, 92, 92},
+	"indigo":               {75, 0, 130},
+	"ivory":                {255, 255, 240},
+	"khaki":                {240, 230, 140},
+	"lavender":             {230, 230, 250},
+	"lavenderblush":        {255, 240, 245},
+	"lawngreen":            {124, 252, 0},
+	"lemonchiffon":         {255, 250, 205},
+	"lightblue":            {173, 216, 230},
+	"lightcoral":           {240, 128, 128},
+	"lightcyan":            {224, 255, 255},
+	"lightgoldenrodyellow": {250, 250, 210},
+	"lightgray":            {211, 211, 211},
+	"lightgreen":           {144, 238, 144},
+	"lightgrey":            {211, 211, 211},
+	"lightpink":            {255, 182, 193},
+	"lightsalmon":          {255, 160, 122},
+	"lightseagreen":        {32, 178, 170},
+	"lightskyblue":         {135, 206, 250},
+	"lightslategray":       {119, 136, 153},
+	"lightslategrey":       {119, 136, 153},
+	"lightsteelblue":       {176, 196, 222},
+	"lightyellow":          {255, 255, 224},
+	"lime":                 {0, 255, 0},
+	"limegreen":            {50, 205, 50},
+	"linen":                {250, 240, 230},
+	"magenta":              {255, 0, 255},
+	"maroon":               {128, 0, 0},
+	"mediumaquamarine":     {102, 205, 170},
+	"mediumblue":           {0, 0, 205},
+	"mediumorchid":         {186, 85, 211},
+	"mediumpurple":         {147, 112, 219},
+	"mediumseagreen":       {60, 179, 113},
+	"mediumslateblue":      {123, 104, 238},
+	"mediumspringgreen":    {0, 250, 154},
+	"mediumturquoise":      {72, 209, 204},
+	"mediumvioletred":      {199, 21, 133},
+	"midnightblue":         {25, 25, 112},
+	"mintcream":            {245, 255, 250},
+	"mistyrose":            {255, 228, 225},
+	"moccasin":             {255, 228, 181},
+	"navajowhite":          {255, 222, 173},
+	"navy":                 {0, 0, 128},
+	"oldlace":              {253, 245, 230},
+	"olive":                {128, 128, 0},
+	"olivedrab":            {107, 142, 35},
+	"orange":               {255, 165, 0},
+	"orangered":            {255, 69, 0},
+	"orchid":               {218, 112, 214},
+	"palegoldenrod":        {238, 232, 170},
+	"palegreen":            {152, 251, 152},
+	"paleturquoise":        {175, 238, 238},
+	"palevioletred":        {219, 112, 147},
+	"papayawhip":           {255, 239, 213},
+	"peachpuff":            {255, 218, 185},
+	"peru":                 {205, 133, 63},
+	"pink":                 {255, 192, 203},
+	"plum":                 {221, 160, 221},
+	"powderblue":           {176, 224, 230},
+	"purple":               {128, 0, 128},
+	"rebeccapurple":        {102, 51, 153},
+	"red":                  {255, 0, 0},
+	"rosybrown":            {188, 143, 143},
+	"royalblue":            {65, 105, 225},
+	"saddlebrown":          {139, 69, 19},
+	"salmon":               {250, 128, 114},
+	"sandybrown":           {244, 164, 96},
+	"seagreen":             {46, 139, 87},
+	"seashell":             {255, 245, 238},
+	"sienna":               {160, 82, 45},
+	"silver":               {192, 192, 192},
+	"skyblue":              {135, 206, 235},
+	"slateblue":            {106, 90, 205},
+	"slategray":            {112, 128, 144},
+	"slategrey":            {112, 128, 144},
+	"snow":                 {255, 250, 250},
+	"springgreen":          {0, 255, 127},
+	"steelblue":            {70, 130, 180},
+	"tan":                  {210, 180, 140},
+	"teal":                 {0, 128, 128},
+	"thistle":              {216, 191, 216},
+	"tomato":               {255, 99, 71},
+	"turquoise":            {64, 224, 208},
+	"violet":               {238, 130, 238},
+	"wheat":                {245, 222, 179},
+	"white":                {255, 255, 255},
+	"whitesmoke":           {245, 245, 245},
+	"yellow":               {255, 255, 0},
+	"yellowgreen":          {154, 205, 50},
 }
@@ -0,0 +1,3259 @@
+package gofpdf
+
+import (
+	"bytes"
+	"cmp"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestPdf returns an uncompressed A4 document in millimeters with a first
+// page and 12 point Helvetica as the current font.
+func newTestPdf() *Fpdf {
+	p := NewFpdf("P", "mm", "A4")
+	p.SetCompression(false)
+	p.AddPage("", "", 0)
+	p.SetFont("helvetica", "", 12)
+	return p
+}
+
+// pageStream returns the content written to page n so far.
+func pageStream(p *Fpdf, n int) string {
+	return strings.Join(p.pages[n], "\n")
+}
+
+// output closes p and returns the document.
+func output(t *testing.T, p *Fpdf) string {
+	t.Helper()
+	var b bytes.Buffer
+	if err := p.OutputTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+// pdfObject returns the text of object n of doc, from its header to endobj.
+func pdfObject(t *testing.T, doc string, n int) string {
+	t.Helper()
+	start := strings.Index(doc, "\n"+strconv.Itoa(n)+" 0 obj\n")
+	if start < 0 {
+		t.Fatalf("object %d not found", n)
+	}
+	end := strings.Index(doc[start:], "endobj")
+	return doc[start+1 : start+end]
+}
+
+// findRef returns the object number captured by the first group of re in
+// doc.
+func findRef(t *testing.T, doc string, re string) int {
+	t.Helper()
+	m := regexp.MustCompile(re).FindStringSubmatch(doc)
+	if m == nil {
+		t.Fatalf("%s not found", re)
+	}
+	n, _ := strconv.Atoi(m[1])
+	return n
+}
+
+// pngBytes returns a w by h PNG image painted with c.
+func pngBytes(t *testing.T, w, h int, c color.Color) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestSetImageICCProfile(t *testing.T) {
+	p := newTestPdf()
+	p.RegisterImageBytes("rgb.png", pngBytes(t, 2, 2, color.NRGBA{R: 255, A: 255}), "")
+	p.SetImageICCProfile("rgb.png", []byte("icc profile data"))
+	p.Image("rgb.png", 10, 10, 20, 0, "", nil)
+	doc := output(t, p)
+
+	icc := pdfObject(t, doc, findRef(t, doc, `/ColorSpace \[/ICCBased (\d+) 0 R\]`))
+	if !strings.Contains(icc, "/N 3 ") || !strings.Contains(icc, "/Alternate /DeviceRGB") {
+		t.Errorf("ICC profile stream has the wrong component count:\n%s", icc)
+	}
+	if !strings.Contains(icc, "icc profile data") {
+		t.Errorf("ICC profile stream does not hold the profile:\n%s", icc)
+	}
+}
+
+func TestSetImageICCProfileShared(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(p *Fpdf, img []byte)
+	}{
+		{"profile after registration", func(p *Fpdf, img []byte) {
+			p.RegisterImageBytes("a.png", img, "")
+			p.RegisterImageBytes("b.png", img, "")
+			p.SetImageICCProfile("a.png", []byte("icc profile data"))
+		}},
+		{"profile before registration", func(p *Fpdf, img []byte) {
+			p.SetImageICCProfile("a.png", []byte("icc profile data"))
+			p.RegisterImageBytes("a.png", img, "")
+			p.RegisterImageBytes("b.png", img, "")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			tt.setup(p, pngBytes(t, 2, 2, color.NRGBA{R: 255, A: 255}))
+			p.Image("a.png", 10, 10, 20, 0, "", nil)
+			p.Image("b.png", 40, 10, 20, 0, "", nil)
+			if p.ImageCount() != 2 || p.GetImageCount() != 2 {
+				t.Errorf("%d images under %d names, want 2 under 2", p.GetImageCount(), p.ImageCount())
+			}
+			doc := output(t, p)
+			if n := strings.Count(doc, "/ColorSpace [/ICCBased "); n != 1 {
+				t.Errorf("%d images with the profile, want 1", n)
+			}
+			if n := strings.Count(doc, "/ColorSpace /DeviceRGB"); n != 1 {
+				t.Errorf("%d images without the profile, want 1", n)
+			}
+		})
+	}
+}
+
+func TestCellBorderStyle(t *testing.T) {
+	p := newTestPdf()
+	p.CellWithOptions(40, 10, "dashed", 1, 0, "L", false, "", CellOptions{BorderStyle: "dashed"})
+	dashed := p.pages[1][len(p.pages[1])-1]
+	p.Cell(40, 10, "solid", 1, 0, "L", false, "")
+	solid := p.pages[1][len(p.pages[1])-1]
+
+	if !regexp.MustCompile(`^q \[[0-9.]+ [0-9.]+\] 0 d .* re S Q`).MatchString(dashed) {
+		t.Errorf("dashed border is not scoped by q/Q: %q", dashed)
+	}
+	if strings.Contains(solid, " d ") || !strings.Contains(solid, "re S") {
+		t.Errorf("solid border after a dashed one: %q", solid)
+	}
+}
+
+func TestWriteHTMLRepeatsTableHead(t *testing.T) {
+	p := newTestPdf()
+	var html strings.Builder
+	html.WriteString("<table border=\"1\"><thead><tr><th>Heading</th><th>Value</th></tr></thead><tbody>")
+	for i := 0; i < 80; i++ {
+		html.WriteString("<tr><td>row " + strconv.Itoa(i) + "</td><td>x</td></tr>")
+	}
+	html.WriteString("</tbody></table>")
+	p.WriteHTML(html.String())
+
+	if p.PageNo() < 2 {
+		t.Fatalf("table fits on %d page", p.PageNo())
+	}
+	for n := 1; n <= p.PageNo(); n++ {
+		if got := strings.Count(pageStream(p, n), "(Heading) Tj"); got != 1 {
+			t.Errorf("page %d has %d header rows, want 1", n, got)
+		}
+	}
+}
+
+func TestNewlinesAreEscaped(t *testing.T) {
+	tests := []struct {
+		name string
+		draw func(p *Fpdf)
+	}{
+		{"Cell", func(p *Fpdf) { p.Cell(40, 10, "one\ntwo\r", 0, 0, "L", false, "") }},
+		{"Text", func(p *Fpdf) { p.Text(10, 20, "one\ntwo\r") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			tt.draw(p)
+			s := p.pages[1][len(p.pages[1])-1]
+			if !strings.Contains(s, `(one\ntwo\r) Tj`) {
+				t.Errorf("newlines are not escaped: %q", s)
+			}
+		})
+	}
+}
+
+func TestSetLinkStyle(t *testing.T) {
+	tests := []struct {
+		name  string
+		width float64
+		want  string
+	}{
+		{"invisible", 0, "/Border [0 0 0] /A"},
+		{"blue", 1, "/Border [0 0 1.00] /C [0.000 0.000 1.000] "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			p.SetLinkStyle(tt.width, [3]int{0, 0, 255}, "solid")
+			p.Cell(40, 10, "link", 0, 0, "L", false, "https://example.com")
+			doc := output(t, p)
+			if !strings.Contains(doc, "/Subtype /Link") || !strings.Contains(doc, tt.want) {
+				t.Errorf("link annotation does not contain %q:\n%s", tt.want, doc)
+			}
+		})
+	}
+}
+
+func TestBarcode128Link(t *testing.T) {
+	p := newTestPdf()
+	p.Barcode128(20, 30, 60, 15, "GOFPDF-128", "https://example.com/item")
+	doc := output(t, p)
+
+	k := p.k
+	want := sprintf("/Rect [%.2F %.2F %.2F %.2F]", 20*k, p.hPt-30*k, 80*k, p.hPt-45*k)
+	if !strings.Contains(doc, want) {
+		t.Errorf("link annotation does not cover the barcode, want %s", want)
+	}
+	if !strings.Contains(doc, "/URI (https://example.com/item)") {
+		t.Error("link annotation has no URI action")
+	}
+}
+
+func TestTextBoxOverflow(t *testing.T) {
+	p := newTestPdf()
+	txt := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 6)
+	lines := p.SplitLines(txt, 50)
+	lh := p.fontSize * 1.2
+	overflow := p.TextBox(10, 10, 50, 2*lh, txt, "J")
+
+	if !strings.HasPrefix(overflow, lines[2]) {
+		t.Errorf("overflow %q does not start with the third line %q", overflow, lines[2])
+	}
+	printed := strings.TrimSuffix(txt, overflow)
+	if printed != lines[0]+" "+lines[1]+" " {
+		t.Errorf("overflow is not the remainder of the text: printed %q", printed)
+	}
+	if got := strings.Count(pageStream(p, 1), " Tj"); got != 2 {
+		t.Errorf("%d lines printed, want 2", got)
+	}
+}
+
+// testFont writes a TrueType font mapping "A" to "E" to glyphs 1 to 5 and
+// returns its file name.
+func testFont(t *testing.T) string {
+	t.Helper()
+	return testFontRange(t, 'A', 5)
+}
+
+// testFontRange writes a TrueType font mapping the n characters from first
+// on to glyphs 1 to n, glyph g being 500+10g units wide, and returns its file
+// name.
+func testFontRange(t *testing.T, first rune, n int) string {
+	t.Helper()
+	numGlyphs := n + 1
+	u16 := func(b []byte, v int) []byte { return binary.BigEndian.AppendUint16(b, uint16(v)) }
+
+	var glyf []byte
+	loca := binary.BigEndian.AppendUint32(nil, 0)
+	hmtx := []byte{}
+	for gid := 0; gid < numGlyphs; gid++ {
+		// One contour of a single on-curve point.
+		glyf = u16(glyf, 1)
+		glyf = u16(u16(u16(u16(glyf, 0), 0), 100*gid), 700)
+		glyf = u16(u16(glyf, 0), 0)
+		glyf = append(glyf, 0x01)
+		glyf = u16(u16(glyf, 100*gid), 700)
+		for len(glyf)%4 != 0 {
+			glyf = append(glyf, 0)
+		}
+		loca = binary.BigEndian.AppendUint32(loca, uint32(len(glyf)))
+		hmtx = u16(u16(hmtx, 500+10*gid), 0)
+	}
+
+	head := make([]byte, 54)
+	binary.BigEndian.PutUint32(head, 0x00010000)
+	binary.BigEndian.PutUint16(head[18:], 1000)
+	binary.BigEndian.PutUint16(head[42:], 700)
+	binary.BigEndian.PutUint16(head[50:], 1)
+	hhea := make([]byte, 36)
+	binary.BigEndian.PutUint32(hhea, 0x00010000)
+	binary.BigEndian.PutUint16(hhea[4:], 800)
+	binary.BigEndian.PutUint16(hhea[6:], 0xFF38) // -200
+	binary.BigEndian.PutUint16(hhea[34:], uint16(numGlyphs))
+	maxp := u16(binary.BigEndian.AppendUint32(nil, 0x00005000), numGlyphs)
+
+	// Format 4 subtable with the segment of the characters and the final
+	// 0xFFFF one.
+	sub := u16(u16(u16(nil, 4), 40), 0)
+	sub = u16(u16(u16(u16(sub, 4), 4), 1), 0)
+	sub = u16(u16(sub, int(first)+n-1), 0xFFFF)
+	sub = u16(sub, 0)
+	sub = u16(u16(sub, int(first)), 0xFFFF)
+	sub = u16(u16(sub, 1-int(first)), 1)
+	sub = u16(u16(sub, 0), 0)
+	cmap := binary.BigEndian.AppendUint32(u16(u16(u16(u16(nil, 0), 1), 3), 1), 12)
+	cmap = append(cmap, sub...)
+
+	name := u16(u16(u16(nil, 0), 1), 18)
+	name = u16(u16(u16(u16(u16(u16(name, 1), 0), 0), 6), 8), 0)
+	name = append(name, "TestFont"...)
+
+	font := buildTTF(map[string][]byte{
+		"cmap": cmap, "glyf": glyf, "head": head, "hhea": hhea,
+		"hmtx": hmtx, "loca": loca, "maxp": maxp, "name": name,
+	})
+	file := filepath.Join(t.TempDir(), "test.ttf")
+	if err := os.WriteFile(file, font, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// fontFile returns the first embedded TrueType font file of doc.
+func fontFile(t *testing.T, doc string) []byte {
+	t.Helper()
+	m := regexp.MustCompile(`/Length1 \d+ /Length (\d+)>>\nstream\n`).FindStringSubmatchIndex(doc)
+	if m == nil {
+		t.Fatal("no embedded font file")
+	}
+	n, _ := strconv.Atoi(doc[m[2]:m[3]])
+	return []byte(doc[m[1] : m[1]+n])
+}
+
+func TestFontSubsetting(t *testing.T) {
+	tests := []struct {
+		name   string
+		subset bool
+		want   []int
+	}{
+		{"subset", true, []int{0, 1, 2, 4}},
+		{"whole font", false, []int{0, 1, 2, 3, 4, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			p.SetFontSubsetting(tt.subset)
+			p.AddUTF8Font("test", "", testFont(t))
+			p.SetFont("test", "", 12)
+			p.Cell(40, 10, "ABDBA", 0, 0, "L", false, "")
+			doc := output(t, p)
+
+			font := fontFile(t, doc)
+			loca := font[tableOffset(font, "loca"):]
+			var got []int
+			for gid := 0; gid < 6; gid++ {
+				if binary.BigEndian.Uint32(loca[4*gid+4:]) > binary.BigEndian.Uint32(loca[4*gid:]) {
+					got = append(got, gid)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("embedded glyphs %v, want %v", got, tt.want)
+			}
+			if tagged := regexp.MustCompile(`/BaseFont /[A-Z]{6}\+TestFont`).MatchString(doc); tagged != tt.subset {
+				t.Errorf("subset tag in the font name: %v, want %v", tagged, tt.subset)
+			}
+		})
+	}
+}
+
+func TestUsedFontsAndImages(t *testing.T) {
+	p := newTestPdf()
+	p.AddFont("courier", "", "", "")
+	p.RegisterImageBytes("red.png", pngBytes(t, 1, 1, color.NRGBA{R: 255, A: 255}), "")
+	p.Image("red.png", 10, 10, 10, 0, "", nil)
+	p.Cell(40, 10, "text", 0, 0, "L", false, "")
+
+	if got := p.UsedFonts(); !slices.Equal(got, []string{"helvetica"}) {
+		t.Errorf("UsedFonts() = %v, want [helvetica]", got)
+	}
+	if got := p.UsedImages(); !slices.Equal(got, []string{"red.png"}) {
+		t.Errorf("UsedImages() = %v, want [red.png]", got)
+	}
+}
+
+func TestSetDraftMode(t *testing.T) {
+	p := NewFpdf("P", "mm", "A4")
+	p.SetCompression(false)
+	p.SetDraftMode("DRAFT")
+	p.AddPage("", "", 0)
+	p.AddPage("", "", 0)
+	doc := output(t, p)
+
+	mark := regexp.MustCompile(`^q /GS1 gs .* Tm \[?\(DRAFT\)`)
+	for n := 1; n <= 2; n++ {
+		if !mark.MatchString(pageStream(p, n)) {
+			t.Errorf("page %d has no draft mark:\n%s", n, pageStream(p, n))
+		}
+	}
+	if !strings.Contains(doc, "<</Type /ExtGState /ca 0.300 /CA 0.300>>") {
+		t.Error("draft mark graphics state is not translucent")
+	}
+}
+
+func TestWriteHTMLReturn(t *testing.T) {
+	p := newTestPdf()
+	y0 := p.GetY()
+	_, y := p.WriteHTMLReturn("first line<br>second line<br>")
+	if got := y - y0; math.Abs(got-10) > 0.01 {
+		t.Errorf("cursor advanced by %.2f, want two 5 mm lines", got)
+	}
+	if y != p.GetY() {
+		t.Errorf("returned y %.2f, cursor at %.2f", y, p.GetY())
+	}
+}
+
+func TestPageThumbnail(t *testing.T) {
+	tests := []struct {
+		name       string
+		sourceRot  int
+		rotation   int
+		wantMatrix string
+	}{
+		{"upright", 0, 0, `q 0\.[0-9]+ 0\.00000 0\.00000 0\.[0-9]+ `},
+		{"rotated source", 90, 0, `q 0\.00000 -0\.[0-9]+ 0\.[0-9]+ 0\.00000 `},
+		{"turned thumbnail", 0, -90, `q 0\.00000 0\.[0-9]+ -0\.[0-9]+ 0\.00000 `},
+		{"turned back", 90, -90, `q 0\.[0-9]+ 0\.00000 0\.00000 0\.[0-9]+ `},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewFpdf("P", "mm", "A4")
+			p.SetCompression(false)
+			p.AddPage("", "", tt.sourceRot)
+			p.SetFont("helvetica", "", 12)
+			p.Text(20, 20, "page one")
+			p.AddPage("", "", 0)
+			p.PageThumbnail(1, 10, 10, 0, 0, tt.rotation)
+			op := p.pages[2][len(p.pages[2])-1]
+			doc := output(t, p)
+
+			if !regexp.MustCompile("^" + tt.wantMatrix + `.* cm /PG1 Do Q$`).MatchString(op) {
+				t.Errorf("thumbnail operator %q does not match %s", op, tt.wantMatrix)
+			}
+			form := pdfObject(t, doc, findRef(t, doc, `/PG1 (\d+) 0 R`))
+			if !strings.Contains(form, "/Subtype /Form") || !strings.Contains(form, "(page one) Tj") {
+				t.Errorf("page 1 is not embedded as a form XObject:\n%s", form)
+			}
+		})
+	}
+}
+
+// mustPanic fails t unless f panics with a message containing want.
+func mustPanic(t *testing.T, want string, f func()) {
+	t.Helper()
+	defer func() {
+		t.Helper()
+		r := recover()
+		if msg, _ := r.(string); !strings.Contains(msg, want) {
+			t.Errorf("panic %v, want %q", r, want)
+		}
+	}()
+	f()
+}
+
+func TestAddFontWithEncoding(t *testing.T) {
+	p := newTestPdf()
+	p.AddFontWithEncoding("times", "", "", "", "cp1250")
+	p.SetFont("times", "", 12)
+	p.Cell(40, 10, "\xa3\xf3d\xbc", 0, 0, "L", false, "") // "Łódź" in cp1250
+	if got, want := p.GetStringWidth("\xa3"), p.GetStringWidth("L"); got != want {
+		t.Errorf("width of Lslash %.3f, want the width of L %.3f", got, want)
+	}
+	doc := output(t, p)
+	enc := pdfObject(t, doc, findRef(t, doc, `/BaseFont /Times-Roman\n/Subtype /Type1\n/Encoding (\d+) 0 R`))
+	if !strings.Contains(enc, "161 /caron /breve /Lslash ") {
+		t.Errorf("encoding has no Lslash difference at 163:\n%s", enc)
+	}
+
+	tests := []struct {
+		name string
+		add  func(p *Fpdf)
+		want string
+	}{
+		{"cyrillic core font", func(p *Fpdf) { p.AddFontWithEncoding("courier", "", "", "", "cp1251") }, "no glyphs for encoding cp1251"},
+		{"greek core font", func(p *Fpdf) { p.AddFontWithEncoding("courier", "", "", "", "cp1253") }, "no glyphs for encoding cp1253"},
+		{"second encoding", func(p *Fpdf) { p.AddFontWithEncoding("helvetica", "", "", "", "cp1257") }, "already been added with encoding cp1252"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mustPanic(t, tt.want, func() { tt.add(newTestPdf()) })
+		})
+	}
+}
+
+func TestOutputIsReproducible(t *testing.T) {
+	build := func() string {
+		p := newTestPdf()
+		p.SetCanonicalOutput(true)
+		for i, family := range []string{"courier", "times", "helvetica", "zapfdingbats", "symbol"} {
+			p.SetFont(family, "", 12)
+			p.Cell(40, 10, "text", 0, 1, "L", false, "")
+			name := "img" + strconv.Itoa(i) + ".png"
+			p.RegisterImageBytes(name, pngBytes(t, 1, 1, color.NRGBA{R: uint8(40 * i), A: 255}), "")
+			p.Image(name, 100, float64(10+20*i), 10, 0, "", nil)
+		}
+		return output(t, p)
+	}
+	first := build()
+	for i := 0; i < 5; i++ {
+		if build() != first {
+			t.Fatal("the same document gave different output")
+		}
+	}
+}
+
+func TestWriteHTMLEditTags(t *testing.T) {
+	p := newTestPdf()
+	p.WriteHTML("<del>old</del> <ins>new</ins> <s>x<ins>both</ins>y</s> plain")
+	stream := pageStream(p, 1)
+
+	run := regexp.MustCompile(`BT [0-9.]+ ([0-9.]+) Td \( ?(\w+)\) Tj ET((?: [0-9.]+ [0-9.]+ [0-9.]+ -[0-9.]+ re f)*)`)
+	rule := regexp.MustCompile(`[0-9.]+ ([0-9.]+) [0-9.]+ -[0-9.]+ re f`)
+	want := map[string]string{"old": "S", "new": "U", "x": "S", "both": "US", "y": "S", "plain": ""}
+	for _, m := range run.FindAllStringSubmatch(stream, -1) {
+		base, _ := strconv.ParseFloat(m[1], 64)
+		got := ""
+		for _, r := range rule.FindAllStringSubmatch(m[3], -1) {
+			if y, _ := strconv.ParseFloat(r[1], 64); y > base {
+				got += "S"
+			} else {
+				got += "U"
+			}
+		}
+		if w, ok := want[m[2]]; !ok || got != w {
+			t.Errorf("%q has lines %q, want %q", m[2], got, w)
+		}
+		delete(want, m[2])
+	}
+	for word := range want {
+		t.Errorf("%q not printed:\n%s", word, stream)
+	}
+}
+
+func TestDrawAtBottom(t *testing.T) {
+	p := newTestPdf()
+	p.SetY(50, true)
+	if got, want := p.SpaceToBottom(), p.pageBreakTrigger-50; got != want {
+		t.Errorf("SpaceToBottom() = %.2f, want %.2f", got, want)
+	}
+	var y float64
+	p.DrawAtBottom(30, func() {
+		y = p.GetY()
+		p.Cell(0, 20, "first", 0, 1, "L", false, "")
+		p.Cell(0, 20, "second", 0, 1, "L", false, "")
+	})
+	if want := p.pageBreakTrigger - 30; y != want {
+		t.Errorf("callback started at y %.2f, want %.2f", y, want)
+	}
+	if p.PageNo() != 1 {
+		t.Errorf("drawing past the trigger added a page")
+	}
+	if !p.autoPageBreak {
+		t.Error("automatic page breaks are not restored")
+	}
+}
+
+func TestSignatureLine(t *testing.T) {
+	tests := []struct {
+		name string
+		y    float64
+	}{
+		{"mid page", 100},
+		{"page bottom", 290},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			p.SignatureLine(20, tt.y, 60, "Signature")
+			stream := pageStream(p, 1)
+
+			k := p.k
+			line := sprintf("%.2F %.2F m %.2F %.2F l S", 20*k, (p.h-tt.y)*k, 80*k, (p.h-tt.y)*k)
+			if !strings.Contains(stream, line) {
+				t.Errorf("line %q not drawn:\n%s", line, stream)
+			}
+			label := regexp.MustCompile(`BT ([0-9.]+) ([0-9.]+) Td \(Signature\) Tj ET`).FindStringSubmatch(stream)
+			if label == nil {
+				t.Fatalf("label not printed:\n%s", stream)
+			}
+			if x, _ := strconv.ParseFloat(label[1], 64); x != math.Round((20+p.cMargin)*k*100)/100 {
+				t.Errorf("label at x %.2f, want the line start plus the cell margin", x)
+			}
+			if y, _ := strconv.ParseFloat(label[2], 64); y >= (p.h-tt.y)*k || y < (p.h-tt.y-5)*k {
+				t.Errorf("label baseline %.2f is not just below the line", y)
+			}
+			if p.PageNo() != 1 || p.fontSizePt != 12 {
+				t.Errorf("page %d, font size %.0f after the signature line", p.PageNo(), p.fontSizePt)
+			}
+		})
+	}
+}
+
+func TestVSpace(t *testing.T) {
+	tests := []struct {
+		name     string
+		h        float64
+		wantPage int
+	}{
+		{"fits", 10, 1},
+		{"overflows", 40, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			y := p.pageBreakTrigger - 20
+			p.SetY(y, true)
+			p.VSpace(tt.h)
+			if p.PageNo() != tt.wantPage {
+				t.Fatalf("on page %d, want %d", p.PageNo(), tt.wantPage)
+			}
+			want := y + tt.h
+			if tt.wantPage == 2 {
+				want = p.tMargin
+			}
+			if p.GetY() != want || p.GetX() != p.lMargin {
+				t.Errorf("position (%.2f, %.2f), want (%.2f, %.2f)", p.GetX(), p.GetY(), p.lMargin, want)
+			}
+		})
+	}
+}
+
+func TestRawImageColorSpaces(t *testing.T) {
+	gray16 := image.NewGray16(image.Rect(0, 0, 2, 2))
+	gray16.SetGray16(1, 1, color.Gray16{Y: 0x1234})
+	var gray16PNG bytes.Buffer
+	if err := png.Encode(&gray16PNG, gray16); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		register    func(p *Fpdf)
+		wantCS      string
+		wantBPC     int
+		wantVersion string
+	}{
+		{"gray16", func(p *Fpdf) { p.RegisterImageBytes("img", gray16PNG.Bytes(), "") }, "/DeviceGray", 16, "1.5"},
+		{"cmyk", func(p *Fpdf) { p.RegisterCMYKImage("img", 2, 1, []byte{0, 0, 0, 255, 255, 0, 0, 0}) }, "/DeviceCMYK", 8, "1.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			tt.register(p)
+			p.Image("img", 10, 10, 20, 0, "", nil)
+			doc := output(t, p)
+
+			img := pdfObject(t, doc, findRef(t, doc, `/I1 (\d+) 0 R`))
+			if want := "/ColorSpace " + tt.wantCS + "\n/BitsPerComponent " + strconv.Itoa(tt.wantBPC) + "\n"; !strings.Contains(img, want) {
+				t.Errorf("image object does not contain %q:\n%s", want, img)
+			}
+			if !strings.HasPrefix(doc, "%PDF-"+tt.wantVersion+"\n") {
+				t.Errorf("header %q, want PDF %s", doc[:8], tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestWriteHTMLAlignAttribute(t *testing.T) {
+	p := newTestPdf()
+	p.WriteHTML(`<p align="right">Right text</p>`)
+	m := regexp.MustCompile(`BT ([0-9.]+) [0-9.]+ Td \(Right text\) Tj`).FindStringSubmatch(pageStream(p, 1))
+	if m == nil {
+		t.Fatalf("text not printed:\n%s", pageStream(p, 1))
+	}
+	x, _ := strconv.ParseFloat(m[1], 64)
+	if end, want := x/p.k+p.GetStringWidth("Right text"), p.w-p.rMargin-p.cMargin; math.Abs(end-want) > 0.01 {
+		t.Errorf("text ends at %.2f, want %.2f", end, want)
+	}
+
+	tests := []struct {
+		html  string
+		wantX func(p *Fpdf) float64
+	}{
+		{`<img src="box.png">`, func(p *Fpdf) float64 { return p.lMargin }},
+		{`<img src="box.png" align="right">`, func(p *Fpdf) float64 { return p.w - p.rMargin - 25.4 }},
+		{`<img src="box.png" align="center" height="48">`, func(p *Fpdf) float64 { return (p.w - 25.4) / 2 }},
+		{`<div align="right"><img src="box.png"></div>`, func(p *Fpdf) float64 { return p.w - p.rMargin - 25.4 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.html, func(t *testing.T) {
+			p := newTestPdf()
+			p.RegisterImageBytes("box.png", pngBytes(t, 96, 48, color.NRGBA{B: 255, A: 255}), "")
+			p.WriteHTML(tt.html)
+			m := regexp.MustCompile(`q [0-9.]+ 0 0 [0-9.]+ ([0-9.]+) [0-9.]+ cm /I1 Do Q`).FindStringSubmatch(pageStream(p, 1))
+			if m == nil {
+				t.Fatalf("image not drawn:\n%s", pageStream(p, 1))
+			}
+			x, _ := strconv.ParseFloat(m[1], 64)
+			if want := tt.wantX(p); math.Abs(x/p.k-want) > 0.01 {
+				t.Errorf("image at x %.2f, want %.2f", x/p.k, want)
+			}
+		})
+	}
+}
+
+func TestSetXMPMetadata(t *testing.T) {
+	packet := `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?><x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"/></x:xmpmeta><?xpacket end="w"?>`
+	p := newTestPdf()
+	p.SetXMPMetadata(packet)
+	doc := output(t, p)
+
+	catalog := pdfObject(t, doc, findRef(t, doc, `/Root (\d+) 0 R`))
+	meta := pdfObject(t, doc, findRef(t, catalog, `/Metadata (\d+) 0 R`))
+	if !strings.Contains(meta, "/Type /Metadata /Subtype /XML") || !strings.Contains(meta, packet) {
+		t.Errorf("metadata stream does not hold the packet:\n%s", meta)
+	}
+	if !strings.HasPrefix(doc, "%PDF-1.4\n") {
+		t.Errorf("header %q, want PDF 1.4", doc[:8])
+	}
+
+	mustPanic(t, "not well-formed", func() { newTestPdf().SetXMPMetadata("<x:xmpmeta>") })
+}
+
+// syllables is a Hyphenator with a fixed list of broken words.
+type syllables map[string][]string
+
+func (s syllables) Hyphenate(word string) []string { return s[word] }
+
+func TestHyphenator(t *testing.T) {
+	p := newTestPdf()
+	p.SetHyphenator(syllables{"extraordinarily": {"extra", "ordi", "narily"}})
+	txt := "quite extraordinarily long"
+	w := p.GetStringWidth("quite extraordi-") + 2*p.cMargin + 0.1
+
+	lines := p.SplitLines(txt, w)
+	if len(lines) < 2 || lines[0] != "quite extraordi-" || !strings.HasPrefix(lines[1], "narily") {
+		t.Errorf("lines %q, want the word broken after \"ordi\"", lines)
+	}
+	p.MultiCell(w, 5, txt, "", "L", false)
+	if !strings.Contains(pageStream(p, 1), "(quite extraordi-) Tj") {
+		t.Errorf("MultiCell does not print the hyphen:\n%s", pageStream(p, 1))
+	}
+
+	p.SetHyphenator(nil)
+	if lines := p.SplitLines(txt, w); lines[0] != "quite" {
+		t.Errorf("without a hyphenator the first line is %q, want \"quite\"", lines[0])
+	}
+}
+
+func TestCellHighlighted(t *testing.T) {
+	p := newTestPdf()
+	p.SetFillColor(10, 20, 30)
+	p.CellHighlighted(40, 10, "marked", [3]int{255, 255, 0}, "L")
+	ops := p.pages[1]
+	cell := strings.Join(ops, "\n")
+
+	if !strings.Contains(cell, "1.000 1.000 0.000 rg\n") || !regexp.MustCompile(`re f .*\(marked\) Tj`).MatchString(cell) {
+		t.Errorf("cell background is not filled with the highlight color:\n%s", cell)
+	}
+	if restored := "0.039 0.078 0.118 rg"; ops[len(ops)-1] != restored || p.fillColor != restored {
+		t.Errorf("fill color %q after the cell, want %q", ops[len(ops)-1], restored)
+	}
+	if p.GetX() != p.lMargin+40 {
+		t.Errorf("position %.2f after the cell, want its right edge", p.GetX())
+	}
+}
+
+func TestTileImage(t *testing.T) {
+	p := newTestPdf()
+	p.RegisterImageBytes("icon.png", pngBytes(t, 4, 4, color.NRGBA{G: 255, A: 255}), "")
+	p.TileImage("icon.png", 20, 20, 10, 10, 2, 2)
+	stream := pageStream(p, 1)
+	doc := output(t, p)
+
+	if got := strings.Count(stream, " scn "); got != 1 || strings.Contains(stream, " Do") {
+		t.Errorf("area is not painted with a single pattern fill:\n%s", stream)
+	}
+	if got := strings.Count(doc, "/PatternType 1"); got != 1 {
+		t.Errorf("%d tiling patterns, want 1", got)
+	}
+	pattern := pdfObject(t, doc, findRef(t, doc, `/P1 (\d+) 0 R`))
+	step := sprintf("/XStep %.2F /YStep %.2F", 2*p.k, 2*p.k)
+	if !strings.Contains(pattern, step) || !strings.Contains(pattern, "/I1 Do") {
+		t.Errorf("pattern does not repeat the image every 2 mm:\n%s", pattern)
+	}
+}
+
+func TestSetJavaScript(t *testing.T) {
+	p := newTestPdf()
+	p.SetJavaScript("print(true);")
+	doc := output(t, p)
+
+	catalog := pdfObject(t, doc, findRef(t, doc, `/Root (\d+) 0 R`))
+	action := pdfObject(t, doc, findRef(t, catalog, `/Names <</JavaScript <</Names \[\(EmbeddedJS\) (\d+) 0 R\]>>>>`))
+	if !strings.Contains(action, "<</S /JavaScript /JS (print\\(true\\);)>>") {
+		t.Errorf("JavaScript action does not hold the script:\n%s", action)
+	}
+
+	mustPanic(t, "script is empty", func() { newTestPdf().SetJavaScript("  ") })
+}
+
+func TestSetCanonicalOutput(t *testing.T) {
+	build := func(txt string) string {
+		p := NewFpdf("P", "mm", "A4")
+		p.SetCanonicalOutput(true)
+		p.AddPage("", "", 0)
+		p.SetFont("helvetica", "", 12)
+		p.Cell(40, 10, txt, 0, 0, "L", false, "")
+		return output(t, p)
+	}
+	id := regexp.MustCompile(`/ID \[<([0-9a-f]{32})> <([0-9a-f]{32})>\]`)
+
+	doc := build("same")
+	if doc != build("same") {
+		t.Error("the same drawing calls gave different output")
+	}
+	if strings.Contains(doc, "/CreationDate") || strings.Contains(doc, "/FlateDecode") {
+		t.Error("canonical output has a creation date or compressed streams")
+	}
+	m := id.FindStringSubmatch(doc)
+	if m == nil || m[1] != m[2] {
+		t.Fatalf("canonical output has no stable file identifier")
+	}
+	if other := id.FindStringSubmatch(build("other")); other == nil || other[1] == m[1] {
+		t.Error("different content gave the same file identifier")
+	}
+}
+
+func TestWriteHTMLTableCaption(t *testing.T) {
+	rows := func(n int) string {
+		var b strings.Builder
+		for i := 0; i < n; i++ {
+			b.WriteString("<tr><td>row " + strconv.Itoa(i) + "</td><td>x</td></tr>")
+		}
+		return b.String()
+	}
+	p := newTestPdf()
+	p.WriteHTML(`<table border="1"><caption>Totals</caption><tr><td>first</td><td>x</td></tr></table>`)
+	stream := pageStream(p, 1)
+	caption := regexp.MustCompile(`BT ([0-9.]+) ([0-9.]+) Td \(Totals\) Tj`).FindStringSubmatch(stream)
+	first := regexp.MustCompile(`BT [0-9.]+ ([0-9.]+) Td \(first\) Tj`).FindStringSubmatch(stream)
+	if caption == nil || first == nil {
+		t.Fatalf("caption or first row not printed:\n%s", stream)
+	}
+	cx, _ := strconv.ParseFloat(caption[1], 64)
+	if mid := cx/p.k + p.GetStringWidth("Totals")/2; math.Abs(mid-p.w/2) > 1 {
+		t.Errorf("caption centered on %.2f, want the table center %.2f", mid, p.w/2)
+	}
+	cy, _ := strconv.ParseFloat(caption[2], 64)
+	fy, _ := strconv.ParseFloat(first[1], 64)
+	if cy <= fy {
+		t.Errorf("caption baseline %.2f is not above the first row %.2f", cy, fy)
+	}
+
+	tests := []struct {
+		name         string
+		continuation string
+		want2        string
+	}{
+		{"plain", "", "(Totals) Tj"},
+		{"continuation text", "(cont.)", "(Totals \\(cont.\\)) Tj"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			p.SetContinuationText(tt.continuation)
+			p.WriteHTML(`<table border="1"><caption>Totals</caption>` + rows(80) + `</table>`)
+			if !strings.Contains(pageStream(p, 2), tt.want2) {
+				t.Errorf("page 2 does not repeat the caption as %s", tt.want2)
+			}
+			if note := strings.Contains(pageStream(p, 1), "(\\(cont.\\)) Tj"); note != (tt.continuation != "") {
+				t.Errorf("continuation note on page 1: %v", note)
+			}
+		})
+	}
+
+	t.Run("kept with first row", func(t *testing.T) {
+		p := newTestPdf()
+		p.SetY(p.pageBreakTrigger-7, true)
+		p.WriteHTML(`<table border="1"><caption>Totals</caption>` + rows(3) + `</table>`)
+		if strings.Contains(pageStream(p, 1), "(Totals) Tj") || !strings.Contains(pageStream(p, 2), "(Totals) Tj") {
+			t.Error("caption is left alone at the bottom of page 1")
+		}
+	})
+}
+
+func TestDrawCropMarks(t *testing.T) {
+	p := newTestPdf()
+	p.SetPageBox("trim", 20, 20, 170, 257)
+	p.DrawCropMarks(3)
+	marks := p.pages[1][len(p.pages[1])-1]
+
+	k := p.k
+	off, l := 3*k, 12.0
+	left, right := 20*k, 190*k
+	top, bottom := (p.h-20)*k, (p.h-277)*k
+	tests := []struct {
+		name   string
+		x, y   float64
+		dx, dy float64
+	}{
+		{"bottom left", left, bottom, -1, -1},
+		{"bottom right", right, bottom, 1, -1},
+		{"top left", left, top, -1, 1},
+		{"top right", right, top, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := sprintf("%.2F %.2F m %.2F %.2F l S", tt.x+tt.dx*off, tt.y, tt.x+tt.dx*(off+l), tt.y)
+			v := sprintf("%.2F %.2F m %.2F %.2F l S", tt.x, tt.y+tt.dy*off, tt.x, tt.y+tt.dy*(off+l))
+			if !strings.Contains(marks, h) || !strings.Contains(marks, v) {
+				t.Errorf("marks do not contain %q and %q:\n%s", h, v, marks)
+			}
+		})
+	}
+	if got := strings.Count(marks, " c S"); got != 4 {
+		t.Errorf("%d registration marks, want 4", got)
+	}
+
+	mustPanic(t, "need a trim box", func() { newTestPdf().DrawCropMarks(3) })
+}
+
+func TestImageAtDPI(t *testing.T) {
+	tests := []struct {
+		unit string
+		w, h float64
+	}{
+		{"in", 2, 1},
+		{"mm", 50.8, 25.4},
+		{"pt", 144, 72},
+	}
+	for _, tt := range tests {
+		t.Run(tt.unit, func(t *testing.T) {
+			p := NewFpdf("P", tt.unit, "A4")
+			p.AddPage("", "", 0)
+			p.RegisterImageBytes("photo.png", pngBytes(t, 600, 300, color.NRGBA{R: 128, A: 255}), "")
+			p.ImageAtDPI("photo.png", 1, 1, 300)
+			want := sprintf("q %.2F 0 0 %.2F ", tt.w*p.k, tt.h*p.k)
+			if op := p.pages[1][len(p.pages[1])-1]; !strings.HasPrefix(op, want) {
+				t.Errorf("image drawn with %q, want a %gx%g %s box", op, tt.w, tt.h, tt.unit)
+			}
+		})
+	}
+}
+
+func TestWriteHTMLBodyStyle(t *testing.T) {
+	p := newTestPdf()
+	p.WriteHTML(`<body style="font-family:times;font-size:16pt;color:#ff0000"><p>Hello <span style="font-size:20pt">big</span> after</p><h1>Title</h1><p>tail</p></body>`)
+	stream := pageStream(p, 1)
+	times := p.fonts["times"]
+	if times == nil {
+		t.Fatal("body font family is not used")
+	}
+
+	for _, word := range []string{"Hello ", " after", "tail"} {
+		re := regexp.MustCompile(sprintf(`BT /F(\d+) ([0-9.]+) Tf ET\n(?:q ([0-9. ]+ rg) )?BT [0-9. ]+ Td \(%s\) Tj`, regexp.QuoteMeta(word)))
+		m := re.FindStringSubmatch(stream)
+		if m == nil {
+			t.Errorf("%q is not printed after a font change:\n%s", word, stream)
+			continue
+		}
+		if m[1] != strconv.Itoa(times.i) || m[2] != "16.00" || m[3] != "1.000 0.000 0.000 rg" {
+			t.Errorf("%q printed with font %s at %s pt in %q, want Times at 16 pt in red", word, m[1], m[2], m[3])
+		}
+	}
+}
+
+func TestSetUserUnit(t *testing.T) {
+	p := newTestPdf()
+	p.SetUserUnit(10)
+	p.AddPage("", "", 0)
+	doc := output(t, p)
+
+	first := pdfObject(t, doc, p.pageInfo[1]["n"].(int))
+	second := pdfObject(t, doc, p.pageInfo[2]["n"].(int))
+	if !strings.Contains(first, "/UserUnit 10") || strings.Contains(second, "/UserUnit") {
+		t.Errorf("/UserUnit is not set on the first page only:\n%s\n%s", first, second)
+	}
+	if !strings.HasPrefix(doc, "%PDF-1.6\n") {
+		t.Errorf("header %q, want PDF 1.6", doc[:8])
+	}
+
+	mustPanic(t, "at least 1", func() { newTestPdf().SetUserUnit(0.5) })
+}
+
+func TestWriteBreaksLongWords(t *testing.T) {
+	p := newTestPdf()
+	right := 130.0
+	p.SetMargins(20, 10, &right)
+	url := "https://example.com/" + strings.Repeat("abcdefghij", 6)
+	p.SetX(20)
+	p.Write(5, url, nil)
+
+	var printed strings.Builder
+	lines := 0
+	for _, m := range regexp.MustCompile(`Td \((.*?)\) Tj`).FindAllStringSubmatch(pageStream(p, 1), -1) {
+		printed.WriteString(m[1])
+		if w := p.GetStringWidth(m[1]); w > p.w-p.lMargin-p.rMargin {
+			t.Errorf("line %q is %.2f wide, past the margin", m[1], w)
+		}
+		lines++
+	}
+	if lines < 3 || printed.String() != url {
+		t.Errorf("URL printed as %d lines %q, want it wrapped on several lines", lines, printed.String())
+	}
+}
+
+func TestMultiCellStriped(t *testing.T) {
+	p := newTestPdf()
+	txt := strings.Repeat("stripe ", 6)
+	if n := len(p.SplitLines(txt, 40)); n != 3 {
+		t.Fatalf("text wraps to %d lines, want 3", n)
+	}
+	p.MultiCellStriped(40, 6, txt, [3]int{255, 255, 255}, [3]int{230, 230, 230})
+
+	fill := regexp.MustCompile(`^([0-9. ]+) rg\n[0-9.]+ [0-9.]+ [0-9.]+ -[0-9.]+ re f `)
+	var got []string
+	for i, op := range p.pages[1] {
+		if strings.Contains(op, " re f ") && i > 0 {
+			if m := fill.FindStringSubmatch(p.pages[1][i-1] + "\n" + op); m != nil {
+				got = append(got, m[1])
+			}
+		}
+	}
+	if want := []string{"1.000 1.000 1.000", "0.902 0.902 0.902", "1.000 1.000 1.000"}; !slices.Equal(got, want) {
+		t.Errorf("line fills %q, want %q", got, want)
+	}
+	if last := p.pages[1][len(p.pages[1])-1]; last != "0 g" || p.fillColor != "0 g" {
+		t.Errorf("fill color %q after the block, want it restored to black", last)
+	}
+}
+
+func TestGetStringWidthPt(t *testing.T) {
+	for _, unit := range []string{"pt", "mm", "in"} {
+		t.Run(unit, func(t *testing.T) {
+			p := NewFpdf("P", unit, "A4")
+			p.SetFont("helvetica", "", 12)
+			units := 0
+			for _, c := range []byte("Hello") {
+				units += p.currentFont.cw[c]
+			}
+			if got, want := p.GetStringWidthPt("Hello"), float64(units)*12/1000; math.Abs(got-want) > 1e-9 {
+				t.Errorf("GetStringWidthPt(\"Hello\") = %g, want %g", got, want)
+			}
+		})
+	}
+}
+
+func TestCenterX(t *testing.T) {
+	tests := []struct {
+		name        string
+		left, right float64
+		want        float64
+	}{
+		{"even margins", 10, 10, 80},
+		{"uneven margins", 20, 40, 70},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewFpdf("P", "mm", "A4")
+			p.SetMargins(tt.left, 10, &tt.right)
+			p.w = 210
+			if got := p.CenterX(50); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("CenterX(50) = %g, want %g", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetCellBorderJoin(t *testing.T) {
+	tests := []struct {
+		join   string
+		border string
+		want   string // with the cell corners TL, TR, BR and BL
+	}{
+		{"", "LT", "TL m BL l S TL m TR l S "},
+		{"miter", "LTRB", "q 0 j 2 J TL m TR l BR l BL l h S Q "},
+		{"round", "LT", "q 1 j 2 J BL m TL l TR l S Q "},
+		{"bevel", "TB", "q 2 j 2 J TL m TR l BR m BL l S Q "},
+		{"miter", "RBL", "q 0 j 2 J TR m BR l BL l TL l S Q "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.join+" "+tt.border, func(t *testing.T) {
+			p := newTestPdf()
+			p.SetLineWidth(2 / p.k)
+			p.SetCellBorderJoin(tt.join)
+			x, y := p.GetX(), p.GetY()
+			p.Cell(40, 10, "", tt.border, 0, "L", false, "")
+
+			pt := func(x, y float64) string { return sprintf("%.2F %.2F", x*p.k, (p.h-y)*p.k) }
+			want := strings.NewReplacer("TL", pt(x, y), "TR", pt(x+40, y), "BR", pt(x+40, y+10), "BL", pt(x, y+10)).Replace(tt.want)
+			if got := p.pages[1][len(p.pages[1])-1]; got != want {
+				t.Errorf("borders %q, want %q", got, want)
+			}
+		})
+	}
+
+	mustPanic(t, "incorrect border join style", func() { newTestPdf().SetCellBorderJoin("square") })
+}
+
+func TestSetFontDifferences(t *testing.T) {
+	p := newTestPdf()
+	p.SetFont("courier", "", 12)
+	p.SetFontDifferences("courier", map[int]string{65: "Lslash", 66: "uni0416", 128: "a12"})
+	p.Cell(40, 10, "AB\x80", 0, 0, "L", false, "")
+	doc := output(t, p)
+
+	font := pdfObject(t, doc, findRef(t, doc, `/F2 (\d+) 0 R`))
+	enc := pdfObject(t, doc, findRef(t, font, `/Encoding (\d+) 0 R`))
+	if !strings.Contains(enc, "/Differences [65 /Lslash /uni0416 128 /a12]") {
+		t.Errorf("encoding does not hold the differences:\n%s", enc)
+	}
+	cmap := pdfObject(t, doc, findRef(t, font, `/ToUnicode (\d+) 0 R`))
+	for _, want := range []string{"<00> <40> <0000>", "<43> <7F> <0043>", "<41> <0141>", "<42> <0416>"} {
+		if !strings.Contains(cmap, want) {
+			t.Errorf("ToUnicode map has no %q:\n%s", want, cmap)
+		}
+	}
+	if strings.Contains(cmap, "<80> ") {
+		t.Errorf("ToUnicode map keeps the Euro sign for a dingbat:\n%s", cmap)
+	}
+}
+
+func TestAddImagePage(t *testing.T) {
+	p := NewFpdf("P", "mm", "A4")
+	p.SetCompression(false)
+	sizes := [][2]int{{40, 30}, {30, 40}, {20, 20}}
+	for i, sz := range sizes {
+		name := "scan" + strconv.Itoa(i) + ".png"
+		p.RegisterImageBytes(name, pngBytes(t, sz[0], sz[1], color.NRGBA{B: uint8(80 * i), A: 255}), "")
+		p.AddImagePage(name, []string{"contain", "cover", "stretch"}[i])
+	}
+	if p.PageNo() != 3 {
+		t.Fatalf("%d pages, want 3", p.PageNo())
+	}
+	for n := 1; n <= 3; n++ {
+		if got := strings.Count(pageStream(p, n), " Do"); got != 1 {
+			t.Errorf("page %d draws %d images, want 1", n, got)
+		}
+	}
+	if sz := p.pageSizePt(1); sz[0] <= sz[1] {
+		t.Error("page of a landscape image is not landscape")
+	}
+
+	q := newTestPdf()
+	mustPanic(t, "incorrect image fit", func() { q.AddImagePage("scan.png", "tile") })
+	if q.PageNo() != 1 {
+		t.Errorf("a page was added before the fit mode was checked")
+	}
+}
+
+func TestWriteHTMLEntities(t *testing.T) {
+	tests := []struct {
+		entity string
+		want   byte
+	}{
+		{"&mdash;", 0x97},
+		{"&ndash;", 0x96},
+		{"&rsquo;", 0x92},
+		{"&euro;", 0x80},
+		{"&copy;", 0xA9},
+		{"&hellip;", 0x85},
+	}
+	for _, tt := range tests {
+		t.Run(tt.entity, func(t *testing.T) {
+			p := newTestPdf()
+			p.WriteHTML("a" + tt.entity + "b")
+			if want := "(a" + string([]byte{tt.want}) + "b) Tj"; !strings.Contains(pageStream(p, 1), want) {
+				t.Errorf("%s not printed as byte %#x:\n%q", tt.entity, tt.want, pageStream(p, 1))
+			}
+		})
+	}
+}
+
+func TestSetPageMarginDefaults(t *testing.T) {
+	p := newTestPdf()
+	p.SetPageMarginDefaults(15, 20, 25, 30)
+	p.AddPage("", "", 0)
+	right := 50.0
+	p.SetMargins(40, 40, &right)
+	p.SetAutoPageBreak(true, 5)
+	p.AddPage("", "", 0)
+
+	if got := [4]float64{p.lMargin, p.tMargin, p.rMargin, p.bMargin}; got != [4]float64{15, 20, 25, 30} {
+		t.Errorf("page 3 margins %v, want the defaults", got)
+	}
+	if p.pageBreakTrigger != p.h-30 || p.GetX() != 15 || p.GetY() != 20 {
+		t.Errorf("trigger %.2f at (%.2f, %.2f), want %.2f at the top left margins", p.pageBreakTrigger, p.GetX(), p.GetY(), p.h-30)
+	}
+}
+
+func TestContactSheet(t *testing.T) {
+	p := NewFpdf("P", "mm", "A4")
+	p.SetCompression(false)
+	var keys []string
+	for i := 0; i < 7; i++ {
+		key := "photo" + strconv.Itoa(i) + ".png"
+		p.RegisterImageBytes(key, pngBytes(t, 30+10*i, 40, color.NRGBA{R: uint8(30 * i), A: 255}), "")
+		keys = append(keys, key)
+	}
+	p.ContactSheet(keys, 2, 3, 5)
+
+	if p.PageNo() != 2 {
+		t.Fatalf("%d pages, want 2", p.PageNo())
+	}
+	cw := (p.w - p.lMargin - p.rMargin - 5) / 2
+	ch := (p.h - p.tMargin - p.bMargin - 10) / 3
+	draw := regexp.MustCompile(`q ([0-9.]+) 0 0 ([0-9.]+) ([0-9.]+) ([0-9.]+) cm /I\d+ Do Q`)
+	for i := range keys {
+		page, n := 1+i/6, i%6
+		ops := draw.FindAllStringSubmatch(pageStream(p, page), -1)
+		if n >= len(ops) {
+			t.Fatalf("image %d not drawn on page %d", i, page)
+		}
+		v := make([]float64, 4)
+		for j := range v {
+			v[j], _ = strconv.ParseFloat(ops[n][j+1], 64)
+			v[j] /= p.k
+		}
+		cx, cy := v[2]+v[0]/2, p.h-v[3]-v[1]/2
+		wantX := p.lMargin + float64(n%2)*(cw+5) + cw/2
+		wantY := p.tMargin + float64(n/2)*(ch+5) + ch/2
+		if math.Abs(cx-wantX) > 0.05 || math.Abs(cy-wantY) > 0.05 {
+			t.Errorf("image %d centered on (%.2f, %.2f), want (%.2f, %.2f)", i, cx, cy, wantX, wantY)
+		}
+		if v[0] > cw+0.01 || v[1] > ch+0.01 {
+			t.Errorf("image %d is %.2f by %.2f, larger than its cell", i, v[0], v[1])
+		}
+	}
+}
+
+func TestArrow(t *testing.T) {
+	p := newTestPdf()
+	p.Arrow(10, 10, 50, 50, 4*math.Sqrt2, "F")
+	head := p.pages[1][len(p.pages[1])-1]
+
+	// The head points down right: its base is 4 mm back along both axes and
+	// half as wide as it is long on each side of the line.
+	pt := func(x, y float64) string { return sprintf("%.2F %.2F", x*p.k, (p.h-y)*p.k) }
+	want := pt(50, 50) + " m " + pt(44, 48) + " l " + pt(48, 44) + " l f"
+	if head != want {
+		t.Errorf("arrowhead %q, want %q", head, want)
+	}
+	line := p.pages[1][len(p.pages[1])-2]
+	if !strings.HasPrefix(line, pt(10, 10)+" m "+pt(46, 46)+" l S") {
+		t.Errorf("shaft %q does not stop at the base of the head", line)
+	}
+
+	p.DoubleArrow(10, 60, 50, 60, 4, "D")
+	ops := p.pages[1][len(p.pages[1])-2:]
+	if ops[0] != pt(50, 60)+" m "+pt(46, 62)+" l "+pt(46, 58)+" l s" || ops[1] != pt(10, 60)+" m "+pt(14, 58)+" l "+pt(14, 62)+" l s" {
+		t.Errorf("double arrow heads %q", ops)
+	}
+}
+
+func TestSetUnderlineStyle(t *testing.T) {
+	tests := []struct {
+		style string
+		want  string
+	}{
+		{"solid", ` [0-9.]+ [0-9.]+ [0-9.]+ -[0-9.]+ re f$`},
+		{"dashed", ` q 0 G [0-9.]+ w 0 J \[[0-9.]+ [0-9.]+\] 0 d [0-9. ]+ m [0-9. ]+ l S Q`},
+		{"dotted", ` q 0 G [0-9.]+ w 0 J 1 J \[0 [0-9.]+\] 0 d [0-9. ]+ m [0-9. ]+ l S Q`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.style, func(t *testing.T) {
+			p := newTestPdf()
+			p.SetUnderlineStyle(tt.style)
+			p.SetFont("", "U", 0)
+			p.Cell(40, 10, "underlined", 0, 0, "L", false, "")
+			op := p.pages[1][len(p.pages[1])-1]
+			if !regexp.MustCompile(`\(underlined\) Tj ET` + tt.want).MatchString(op) {
+				t.Errorf("underline %q does not match %s", op, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreloadImages(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for i, c := range []color.Color{color.NRGBA{R: 255, A: 255}, color.NRGBA{G: 255, A: 255}} {
+		path := filepath.Join(dir, "img"+strconv.Itoa(i)+".png")
+		if err := os.WriteFile(path, pngBytes(t, 8, 8, c), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	p := newTestPdf()
+	if err := p.PreloadImages([]string{"red", "green", "crimson"}, append(paths, paths[0])); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.ImageCount(); got != 3 {
+		t.Errorf("ImageCount %d, want 3", got)
+	}
+	if p.GetImageCount() != 2 {
+		t.Errorf("GetImageCount %d, want 2", p.GetImageCount())
+	}
+
+	// The files are gone, so drawing only works from the decoded images.
+	for _, path := range paths {
+		os.Remove(path)
+	}
+	p.Image("red", 10, 10, 20, 0, "", nil)
+	p.Image("green", 40, 10, 20, 0, "", nil)
+	if err := p.Error(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(pageStream(p, 1), " Do Q"); got != 2 {
+		t.Errorf("%d images drawn, want 2", got)
+	}
+
+	if err := p.PreloadImages([]string{"a"}, nil); err == nil {
+		t.Error("mismatched keys and paths accepted")
+	}
+}
+
+func TestHTMLColorToRGB(t *testing.T) {
+	tests := []struct {
+		color string
+		want  [3]int
+	}{
+		{"#ff8800", [3]int{255, 136, 0}},
+		{"#F80", [3]int{255, 136, 0}},
+		{"rgb(10, 20, 30)", [3]int{10, 20, 30}},
+		{"rgb(100%, 0%, 50%)", [3]int{255, 0, 128}},
+		{"Red", [3]int{255, 0, 0}},
+		{"teal", [3]int{0, 128, 128}},
+		{"cornflowerblue", [3]int{100, 149, 237}},
+		{"#12345", [3]int{0, 0, 0}},
+		{"nocolor", [3]int{0, 0, 0}},
+	}
+	for _, tt := range tests {
+		r, g, b := htmlColorToRGB(tt.color)
+		if got := [3]int{r, g, b}; got != tt.want {
+			t.Errorf("htmlColorToRGB(%q) = %v, want %v", tt.color, got, tt.want)
+		}
+	}
+
+	p := newTestPdf()
+	p.WriteHTML(`<span style="color:#ff8800">x</span>`)
+	if !strings.Contains(pageStream(p, 1), "1.000 0.533 0.000 rg BT") {
+		t.Errorf("span color not emitted:\n%s", pageStream(p, 1))
+	}
+}
+
+func TestCMYKColors(t *testing.T) {
+	p := newTestPdf()
+	p.SetDrawColorCMYK(100, 0, 0, 0)
+	p.SetFillColorCMYK(0, 50, 0, 0)
+	p.SetTextColorCMYK(0, 0, 100, 20)
+	p.Cell(40, 10, "cmyk", 0, 0, "L", true, "")
+	s := pageStream(p, 1)
+	for _, want := range []string{
+		"1.000 0.000 0.000 0.000 K",
+		"0.000 0.500 0.000 0.000 k",
+		"q 0.000 0.000 1.000 0.200 k BT",
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("page stream has no %q:\n%s", want, s)
+		}
+	}
+
+	p.SetFillColor(255, 0, 0)
+	p.SetTextColor(0, 0, 255)
+	if p.fillColor != "1.000 0.000 0.000 rg" || p.textColor != "0.000 0.000 1.000 rg" {
+		t.Errorf("RGB setters give %q and %q", p.fillColor, p.textColor)
+	}
+}
+
+func TestTextOnArc(t *testing.T) {
+	p := newTestPdf()
+	p.TextOnArc(100, 100, 30, "SEAL", 90, true)
+	op := p.pages[1][len(p.pages[1])-1]
+	re := regexp.MustCompile(`BT (-?[0-9.]+) (-?[0-9.]+) (-?[0-9.]+) (-?[0-9.]+) [0-9.]+ [0-9.]+ Tm \((.)\) Tj ET`)
+	m := re.FindAllStringSubmatch(op, -1)
+	if len(m) != 4 {
+		t.Fatalf("%d characters positioned, want 4:\n%s", len(m), op)
+	}
+	seen := map[string]bool{}
+	for i, c := range m {
+		if c[5] != string("SEAL"[i]) {
+			t.Errorf("character %d is %q", i, c[5])
+		}
+		a, _ := strconv.ParseFloat(c[1], 64)
+		b, _ := strconv.ParseFloat(c[2], 64)
+		if math.Abs(a*a+b*b-1) > 1e-4 || c[3] != strings.TrimPrefix("-"+c[2], "--") || c[4] != c[1] {
+			t.Errorf("character %d matrix %v is not a rotation", i, c[1:5])
+		}
+		seen[c[1]+" "+c[2]] = true
+	}
+	if len(seen) != 4 {
+		t.Errorf("characters share rotations: %v", seen)
+	}
+}
+
+func TestRectWithOptions(t *testing.T) {
+	p := newTestPdf()
+	p.RectWithOptions(10, 10, 30, 20, RectOptions{Style: "F", Opacity: 0.3})
+	p.Rect(50, 10, 30, 20, "F")
+	ops := p.pages[1][len(p.pages[1])-4:]
+	if ops[0] != "q /GS1 gs" || !strings.HasSuffix(ops[1], " re f") || ops[2] != "Q" || !strings.HasSuffix(ops[3], " re f") {
+		t.Errorf("rectangle not scoped by its graphics state: %q", ops)
+	}
+	if !strings.Contains(output(t, p), "/ca 0.300 /CA 0.300") {
+		t.Error("graphics state does not carry the opacity")
+	}
+
+	q := newTestPdf()
+	n := len(q.pages[1])
+	q.RectWithOptions(10, 10, 30, 20, RectOptions{Style: "D"})
+	if len(q.pages[1]) != n+1 || len(q.extGStates) != 0 {
+		t.Errorf("an opaque rectangle pushes a graphics state: %q", q.pages[1][n:])
+	}
+	mustPanic(t, "opacity must be between 0 and 1", func() { q.RectWithOptions(0, 0, 1, 1, RectOptions{Opacity: 2}) })
+}
+
+func TestCurve(t *testing.T) {
+	p := newTestPdf()
+	p.SetXY(33, 44)
+	pt := func(x, y float64) string { return sprintf("%.2F %.2F", x*p.k, (p.h-y)*p.k) }
+	tests := []struct {
+		style, op string
+	}{
+		{"", "S"},
+		{"D", "S"},
+		{"F", "f"},
+		{"DF", "B"},
+		{"FD", "B"},
+	}
+	for _, tt := range tests {
+		p.CurveBezier(10, 20, 30, 0, 60, 0, 80, 20, tt.style)
+		want := pt(10, 20) + " m " + pt(30, 0) + " " + pt(60, 0) + " " + pt(80, 20) + " c " + tt.op
+		if got := p.pages[1][len(p.pages[1])-1]; got != want {
+			t.Errorf("style %q: %q, want %q", tt.style, got, want)
+		}
+	}
+
+	// The quadratic control point (40, 0) becomes cubic control points two
+	// thirds of the way from each end.
+	p.Curve(10, 30, 40, 0, 70, 30, "D")
+	want := pt(10, 30) + " m " + pt(30, 10) + " " + pt(50, 10) + " " + pt(70, 30) + " c S"
+	if got := p.pages[1][len(p.pages[1])-1]; got != want {
+		t.Errorf("quadratic curve %q, want %q", got, want)
+	}
+	if p.GetX() != 33 || p.GetY() != 44 {
+		t.Errorf("current position moved to (%.2f, %.2f)", p.GetX(), p.GetY())
+	}
+}
+
+func TestResetStyle(t *testing.T) {
+	p := newTestPdf()
+	p.SetFont("courier", "BIU", 20)
+	p.SetUnderlineStyle("dotted")
+	p.SetTextColor(255, 0, 0)
+	p.SetFillColor(0, 255, 0)
+	p.SetDrawColor(0, 0, 255)
+	p.ws = 2
+	p.SetTextRise(3)
+
+	p.ResetStyle()
+	if p.fontFamily != "helvetica" || p.fontStyle != "" || p.fontSizePt != 12 || p.underline || p.strikeout {
+		t.Errorf("font %q %q %.1f underline %v strikeout %v, want regular 12 point helvetica", p.fontFamily, p.fontStyle, p.fontSizePt, p.underline, p.strikeout)
+	}
+	if p.underlineStyle != "" || p.ws != 0 || p.rise != 0 {
+		t.Errorf("underline style %q, word spacing %.2f, rise %.2f left over", p.underlineStyle, p.ws, p.rise)
+	}
+	if p.textColor != "0.000 g" || p.fillColor != "0.000 g" || p.drawColor != "0.000 G" {
+		t.Errorf("colors %q %q %q, want black", p.textColor, p.fillColor, p.drawColor)
+	}
+	ops := strings.Join(p.pages[1][len(p.pages[1])-5:], "\n")
+	for _, want := range []string{"BT /F1 12.00 Tf ET", "0.000 g", "0.000 G", "0 Tw"} {
+		if !strings.Contains(ops, want) {
+			t.Errorf("reset does not emit %q:\n%s", want, ops)
+		}
+	}
+}
+
+func TestResetStyleDefaultFont(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(p *Fpdf)
+		want  string
+	}{
+		{"no font", func(p *Fpdf) {}, "helvetica 12.0"},
+		{"first font", func(p *Fpdf) {
+			p.AddUTF8Font("test", "", testFont(t))
+			p.SetFont("test", "U", 10)
+		}, "test 10.0"},
+		{"configured", func(p *Fpdf) {
+			p.SetFont("times", "", 10)
+			p.SetDefaultFont("Courier", "ib", 9)
+		}, "courierBI 9.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewFpdf("P", "mm", "A4")
+			p.AddPage("", "", 0)
+			tt.setup(p)
+			if p.fontFamily != "" {
+				p.SetFont("helvetica", "B", 20)
+			}
+			p.ResetStyle()
+			if got := sprintf("%s%s %.1f", p.fontFamily, p.fontStyle, p.fontSizePt); got != tt.want || p.underline {
+				t.Errorf("reset to %s (underline %v), want %s", got, p.underline, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoundedRect(t *testing.T) {
+	last := func(p *Fpdf) string { return p.pages[1][len(p.pages[1])-1] }
+	pt := func(p *Fpdf, x, y float64) string { return sprintf("%.2F %.2F", x*p.k, (p.h-y)*p.k) }
+
+	p := newTestPdf()
+	p.RoundedRect(10, 10, 40, 20, 50, "DF")
+	clamped := last(p)
+	p.RoundedRect(10, 10, 40, 20, 10, "DF")
+	if clamped != last(p) {
+		t.Errorf("radius 50 on a 40 by 20 rectangle is not reduced to 10:\n%s\n%s", clamped, last(p))
+	}
+	if !strings.HasPrefix(clamped, pt(p, 20, 10)+" m "+pt(p, 40, 10)+" l ") || !strings.HasSuffix(clamped, " h B") {
+		t.Errorf("path %q", clamped)
+	}
+	if got := strings.Count(clamped, " c"); got != 4 {
+		t.Errorf("%d arcs, want 4", got)
+	}
+
+	p.RoundedRectExt(10, 40, 40, 20, 5, 0, 30, 0, "F")
+	ext := last(p)
+	if got := strings.Count(ext, " c"); got != 2 {
+		t.Errorf("%d arcs with two square corners, want 2", got)
+	}
+	if !strings.Contains(ext, pt(p, 50, 40)+" l "+pt(p, 50, 50)+" l") {
+		t.Errorf("square top-right corner or clamped bottom-right radius missing: %q", ext)
+	}
+	if !strings.HasSuffix(ext, " h f") {
+		t.Errorf("fill operator missing: %q", ext)
+	}
+}
+
+func TestSymbols(t *testing.T) {
+	p := newTestPdf()
+	p.DefineSymbol("pin", func() {
+		p.SetFillColor(200, 0, 0)
+		p.Polygon([][2]float64{{0, 0}, {-2, -5}, {2, -5}}, "F")
+	})
+	n := len(p.pages[1])
+	for i := 0; i < 5; i++ {
+		p.PlaceSymbol("pin", float64(20+10*i), 50, 1)
+	}
+	if got := strings.Count(pageStream(p, 1), "/SY1 Do"); got != 5 {
+		t.Errorf("%d placements, want 5", got)
+	}
+	if len(p.pages[1]) != n+5 || strings.Contains(pageStream(p, 1), " h f") {
+		t.Error("symbol drawing leaked into the page")
+	}
+	doc := output(t, p)
+	if got := strings.Count(doc, "/Subtype /Form"); got != 1 {
+		t.Errorf("%d form XObjects, want 1", got)
+	}
+	mustPanic(t, "undefined symbol: tree", func() { newTestPdf().PlaceSymbol("tree", 0, 0, 1) })
+}
+
+func TestPolygon(t *testing.T) {
+	p := newTestPdf()
+	pt := func(x, y float64) string { return sprintf("%.2F %.2F", x*p.k, (p.h-y)*p.k) }
+	last := func() string { return p.pages[1][len(p.pages[1])-1] }
+
+	p.Polygon([][2]float64{{10, 10}, {30, 10}, {20, 25}}, "F")
+	if want := pt(10, 10) + " m " + pt(30, 10) + " l " + pt(20, 25) + " l h f"; last() != want {
+		t.Errorf("triangle %q, want %q", last(), want)
+	}
+	p.Polyline([][2]float64{{10, 40}, {20, 50}, {30, 40}, {40, 50}})
+	if want := pt(10, 40) + " m " + pt(20, 50) + " l " + pt(30, 40) + " l " + pt(40, 50) + " l S"; last() != want {
+		t.Errorf("polyline %q, want %q", last(), want)
+	}
+	n := len(p.pages[1])
+	p.Polygon([][2]float64{{1, 1}}, "D")
+	p.Polyline(nil)
+	if len(p.pages[1]) != n {
+		t.Error("fewer than two points drew something")
+	}
+}
+
+func TestTrimCenter(t *testing.T) {
+	p := newTestPdf()
+	if p.TrimCenterX() != p.w/2 || p.TrimCenterY() != p.h/2 {
+		t.Errorf("without a trim box the center is (%.2f, %.2f)", p.TrimCenterX(), p.TrimCenterY())
+	}
+	p.SetPageBox("trim", 20, 10, 150, 250)
+	if got := p.TrimCenterX(); math.Abs(got-95) > 1e-9 || got == p.w/2 {
+		t.Errorf("TrimCenterX = %g, want 95", got)
+	}
+	if got := p.TrimCenterY(); math.Abs(got-135) > 1e-9 {
+		t.Errorf("TrimCenterY = %g, want 135", got)
+	}
+
+	media := p.CenterX(50)
+	p.SetTrimAwareCentering(true)
+	if got, want := p.CenterX(50), 20+p.lMargin+(150-p.lMargin-p.rMargin-50)/2; math.Abs(got-want) > 1e-9 || got == media {
+		t.Errorf("trim-aware CenterX(50) = %g, want %g", got, want)
+	}
+}
+
+func TestSetImageErrorMode(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.png")
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{"skip", ""},
+		{"placeholder", ` re B 0.400 g BT [0-9.]+ [0-9.]+ Td \(image\) Tj ET Q$`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			p := newTestPdf()
+			p.SetImageErrorMode(tt.mode)
+			n := len(p.pages[1])
+			p.Image(missing, 10, 10, 30, 20, "", nil)
+			p.Cell(40, 10, "after", 0, 0, "L", false, "")
+			if p.Error() == nil {
+				t.Error("the failure was not recorded")
+			}
+			added := p.pages[1][n:]
+			if tt.want == "" {
+				if len(added) != 1 {
+					t.Errorf("skipped image drew %q", added[:len(added)-1])
+				}
+				return
+			}
+			if len(added) != 2 || !regexp.MustCompile(tt.want).MatchString(added[0]) {
+				t.Errorf("placeholder %q does not match %s", added, tt.want)
+			}
+		})
+	}
+	mustPanic(t, "missing.png", func() { newTestPdf().Image(missing, 10, 10, 30, 20, "", nil) })
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestOutputTo(t *testing.T) {
+	p := newTestPdf()
+	p.Cell(40, 10, "stream", 0, 0, "L", false, "")
+	var b bytes.Buffer
+	if err := p.OutputTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	s, err := p.Output("S", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() != len(s) || b.String() != s {
+		t.Errorf("OutputTo wrote %d bytes, Output returned %d", b.Len(), len(s))
+	}
+	if err := p.OutputTo(failingWriter{}); err == nil || err.Error() != "disk full" {
+		t.Errorf("write error %v not returned", err)
+	}
+}
+
+func TestWriteHTMLWbr(t *testing.T) {
+	tests := []struct {
+		name, html string
+		want       []string
+	}{
+		{"cell", `<table border="1"><tr><td width="40">prefixpart<wbr>suffixpart</td><td>x</td></tr></table>`, []string{"prefixpart", "suffixpart"}},
+		{"flow", strings.Repeat("word ", 14) + `prefixpart<wbr>suffixpart`, []string{"prefixpart", "suffixpart"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			p.WriteHTML(tt.html)
+			stream := pageStream(p, 1)
+			var ys []string
+			for _, piece := range tt.want {
+				m := regexp.MustCompile(`BT [0-9.]+ ([0-9.]+) Td \((?:[a-z ]* )?` + piece + `\) Tj`).FindStringSubmatch(stream)
+				if m == nil {
+					t.Fatalf("%s not printed whole:\n%s", piece, stream)
+				}
+				ys = append(ys, m[1])
+			}
+			if ys[0] == ys[1] {
+				t.Errorf("no break at the hint:\n%s", stream)
+			}
+		})
+	}
+
+	p := newTestPdf()
+	got := p.SplitLines("prefixpart"+zeroWidthSpace+"suffixpart", 40)
+	if !slices.Equal(got, []string{"prefixpart", "suffixpart"}) {
+		t.Errorf("SplitLines at a zero-width space gives %q", got)
+	}
+	if got := p.SplitLines("a"+zeroWidthSpace+"b", 100); !slices.Equal(got, []string{"ab"}) {
+		t.Errorf("zero-width space printed: %q", got)
+	}
+}
+
+func TestAddUTF8Font(t *testing.T) {
+	p := newTestPdf()
+	p.AddUTF8Font("cyrillic", "", testFontRange(t, 'П', 36))
+	p.SetFont("cyrillic", "", 12)
+
+	// П, р, и, в, е and т are glyphs 1, 34, 26, 20, 23 and 36.
+	want := (510 + 840 + 760 + 700 + 730 + 860) * 12 / 1000.0 / p.k
+	if got := p.GetStringWidth("Привет"); math.Abs(got-want) > 1e-9 {
+		t.Errorf("GetStringWidth = %g, want %g from the glyph advances", got, want)
+	}
+	p.Cell(40, 10, "Привет", 0, 1, "L", false, "")
+	p.Write(5, "Привет", "")
+	p.Ln(5)
+	p.MultiCell(40, 5, "Привет", "", "L", false)
+	if got := strings.Count(pageStream(p, 1), "<00010022001A001400170024> Tj"); got != 3 {
+		t.Errorf("%d runs of glyph ids, want 3:\n%s", got, pageStream(p, 1))
+	}
+
+	doc := output(t, p)
+	if len(fontFile(t, doc)) == 0 {
+		t.Error("empty font file")
+	}
+	for _, want := range []string{"/Subtype /CIDFontType2", "/FontFile2 ", "/ToUnicode ", "<0022> <0440>"} {
+		if !strings.Contains(doc, want) {
+			t.Errorf("document has no %q", want)
+		}
+	}
+
+	q := newTestPdf()
+	q.AddUTF8Font("cyrillic", "", testFontRange(t, 'П', 36))
+	q.SetFont("cyrillic", "", 12)
+	q.SetImageErrorMode("placeholder")
+	q.Image(filepath.Join(t.TempDir(), "missing.png"), 10, 10, 30, 20, "", nil)
+	if s := pageStream(q, 1); strings.Contains(s, "(image)") {
+		t.Errorf("placeholder label printed as a byte string in a UTF-8 font:\n%s", s)
+	}
+}
+
+func TestProgressBar(t *testing.T) {
+	tests := []struct {
+		fraction, want float64
+	}{
+		{0.25, 0.25},
+		{0.5, 0.5},
+		{1.5, 1},
+	}
+	for _, tt := range tests {
+		p := newTestPdf()
+		p.ProgressBar(10, 10, 80, 6, tt.fraction, [3]int{0, 128, 0}, [3]int{220, 220, 220})
+		n := len(p.pages[1])
+		bar := p.pages[1][n-2]
+		p.RoundedRect(10, 10, tt.want*80, 6, 3, "F")
+		if want := p.pages[1][n]; bar != want {
+			t.Errorf("fraction %g: bar %q, want %q", tt.fraction, bar, want)
+		}
+	}
+
+	p := newTestPdf()
+	n := len(p.pages[1])
+	p.ProgressBar(10, 10, 80, 6, -1, [3]int{0, 128, 0}, [3]int{220, 220, 220})
+	if got := strings.Count(pageStream(p, 1), " h f"); len(p.pages[1]) != n+3 || got != 1 {
+		t.Errorf("an empty bar draws %d shapes, want the background only", got)
+	}
+}
+
+func TestStarRating(t *testing.T) {
+	tests := []struct {
+		value, max    float64
+		filled, empty int
+	}{
+		{3, 5, 3, 2},
+		{3.6, 5, 4, 1},
+		{7, 5, 5, 0},
+		{0, 4, 0, 4},
+	}
+	for _, tt := range tests {
+		p := newTestPdf()
+		p.StarRating(10, 10, 8, tt.value, tt.max)
+		s := pageStream(p, 1)
+		if f, e := strings.Count(s, " h B"), strings.Count(s, " h S"); f != tt.filled || e != tt.empty {
+			t.Errorf("%g of %g: %d filled and %d empty stars, want %d and %d", tt.value, tt.max, f, e, tt.filled, tt.empty)
+		}
+	}
+}
+
+func TestSingleByteText(t *testing.T) {
+	tests := []struct {
+		name, font, enc, txt string
+		want                 string
+	}{
+		{"utf-8 in cp1252", "helvetica", "", "café", "caf\xe9"},
+		{"cp1252 bytes", "helvetica", "", "caf\xe9", "caf\xe9"},
+		{"cp1250 bytes", "times", "cp1250", "\xd3\xa3", "\xd3\xa3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			if tt.enc != "" {
+				p.AddFontWithEncoding(tt.font, "", "", "", tt.enc)
+			}
+			p.SetFont(tt.font, "", 10)
+			p.SetKerning(false)
+			units := 0
+			for i := 0; i < len(tt.want); i++ {
+				units += p.currentFont.cw[tt.want[i]]
+			}
+			if got, want := p.GetStringWidth(tt.txt), float64(units)*p.fontSize/1000; math.Abs(got-want) > 1e-9 {
+				t.Errorf("width %.3f, want the %d units of %q, %.3f", got, units, tt.want, want)
+			}
+			p.Cell(40, 10, tt.txt, 0, 0, "L", false, "")
+			if want := "(" + tt.want + ") Tj"; !strings.Contains(pageStream(p, 1), want) {
+				t.Errorf("stream has no %q:\n%q", want, pageStream(p, 1))
+			}
+			if lines := p.SplitLines(tt.txt+" "+tt.txt, p.GetStringWidth(tt.txt)+2*p.cMargin+0.01); len(lines) != 2 || lines[0] != tt.txt {
+				t.Errorf("wrapped as %q", lines)
+			}
+		})
+	}
+}
+
+func TestRotation(t *testing.T) {
+	p := newTestPdf()
+	cx, cy := sprintf("%.2F", 50*p.k), sprintf("%.2F", (p.h-100)*p.k)
+	want := "q 0.00000 1.00000 -1.00000 0.00000 " + cx + " " + cy + " cm 1 0 0 1 -" + cx + " -" + cy + " cm"
+	n := len(p.pages[1])
+	p.TextWithRotation(50, 100, "axis", 90)
+	ops := p.pages[1][n:]
+	if len(ops) != 3 || ops[0] != want || !strings.Contains(ops[1], "(axis) Tj") || ops[2] != "Q" {
+		t.Errorf("rotated text %q, want it inside %q ... Q", ops, want)
+	}
+
+	n = len(p.pages[1])
+	p.Rotate(90, 50, 100)
+	p.Rotate(45, 50, 100)
+	p.Rect(10, 10, 5, 5, "D")
+	p.RotateReset()
+	p.RotateReset()
+	ops = p.pages[1][n:]
+	if len(ops) != 5 || ops[0] != want || ops[1] != "Q" || !strings.HasPrefix(ops[2], "q 0.70711 0.70711 -0.70711 0.70711 ") || ops[4] != "Q" {
+		t.Errorf("a new rotation does not replace the previous one: %q", ops)
+	}
+}
+
+func TestRotationKeepsStyle(t *testing.T) {
+	tests := []struct {
+		name  string
+		style func(p *Fpdf)
+		want  []string
+	}{
+		{"unchanged", func(p *Fpdf) {}, nil},
+		{"font and fill", func(p *Fpdf) {
+			p.SetFont("courier", "B", 20)
+			p.SetFillColor(255, 0, 0)
+		}, []string{"BT /F2 20.00 Tf ET", "1.000 0.000 0.000 rg"}},
+		{"line", func(p *Fpdf) {
+			p.SetLineWidth(1)
+			p.SetDashPattern([]float64{2, 1}, 0)
+		}, []string{"2.83 w", "[5.67 2.83] 0.00 d"}},
+		{"rise", func(p *Fpdf) { p.SetTextRise(2) }, []string{"BT 5.67 Ts ET"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			p.Rotate(30, 50, 50)
+			tt.style(p)
+			n := len(p.pages[1])
+			p.RotateReset()
+			if got := p.pages[1][n:]; got[0] != "Q" || !slices.Equal(got[1:], tt.want) {
+				t.Errorf("after the rotation %q, want Q then %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetDefaultCellAlign(t *testing.T) {
+	p := newTestPdf()
+	p.SetDefaultCellAlign("R")
+	p.SetDefaultCellLn(1)
+	at := func(align string) string {
+		p.SetXY(10, 50)
+		p.Cell(100, 10, "text", 0, CellLnDefault, align, false, "")
+		return regexp.MustCompile(`BT ([0-9.]+) `).FindStringSubmatch(p.pages[1][len(p.pages[1])-1])[1]
+	}
+	if def, right := at(CellAlignDefault), at("R"); def != right {
+		t.Errorf("default alignment prints at %s, right alignment at %s", def, right)
+	}
+	if def, left := at(CellAlignDefault), at("L"); def == left {
+		t.Error("an explicit alignment does not override the default")
+	}
+	if p.GetY() != 60 || p.GetX() != p.lMargin {
+		t.Errorf("default line mode left the position at (%.2f, %.2f)", p.GetX(), p.GetY())
+	}
+	p.SetXY(10, 50)
+	p.Cell(100, 10, "text", 0, 0, CellAlignDefault, false, "")
+	if p.GetX() != 110 || p.GetY() != 50 {
+		t.Errorf("an explicit line mode does not override the default")
+	}
+}
+
+func TestWriteTableOfContents(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(p *Fpdf)
+		toc   int
+		pages []int
+	}{
+		{"appended", func(p *Fpdf) { p.WriteTableOfContents("Contents") }, 5, []int{2, 3, 4}},
+		{"after the cover", func(p *Fpdf) { p.WriteTableOfContentsAt("Contents", 2) }, 2, []int{3, 4, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			p.Cell(40, 10, "Cover", 0, 1, "L", false, "")
+			for i := 1; i <= 3; i++ {
+				p.AddPage("", "", 0)
+				p.Bookmark("Chapter "+strconv.Itoa(i), 0, -1)
+				p.Cell(40, 10, "Chapter "+strconv.Itoa(i), 0, 1, "L", false, "")
+			}
+			tt.write(p)
+
+			if p.PageNo() != 5 {
+				t.Fatalf("%d pages, want 5", p.PageNo())
+			}
+			toc := pageStream(p, tt.toc)
+			if !strings.Contains(toc, "(Contents) Tj") {
+				t.Fatalf("page %d is not the table of contents:\n%s", tt.toc, toc)
+			}
+			for i, page := range tt.pages {
+				chapter := "Chapter " + strconv.Itoa(i+1)
+				if !strings.Contains(pageStream(p, page), "("+chapter+") Tj") {
+					t.Errorf("%s is not on page %d", chapter, page)
+				}
+				if !strings.Contains(toc, "("+strconv.Itoa(page)+") Tj") {
+					t.Errorf("%s not listed on page %d", chapter, page)
+				}
+				if p.outlines[i].page != page {
+					t.Errorf("bookmark of %s points to page %d, want %d", chapter, p.outlines[i].page, page)
+				}
+			}
+			var dests []int
+			for _, pl := range p.pageLinks[tt.toc] {
+				dests = append(dests, int(p.links[pl[4].(int)][0]))
+			}
+			if !slices.Equal(dests, tt.pages) {
+				t.Errorf("links to pages %v, want %v", dests, tt.pages)
+			}
+			output(t, p)
+		})
+	}
+
+	// A table longer than a page pushes the content two pages back.
+	p := newTestPdf()
+	for i := 0; i < 60; i++ {
+		p.Bookmark("Section "+strconv.Itoa(i), 0, -1)
+	}
+	p.AddPage("", "", 0)
+	p.PageThumbnail(1, 10, 10, 40, 0, 0)
+	p.WriteTableOfContentsAt("", 1)
+	if p.PageNo() != 4 || !strings.Contains(pageStream(p, 2), "(Section 59) Tj") {
+		t.Fatalf("table not moved to pages 1 and 2")
+	}
+	if !strings.Contains(pageStream(p, 1), "(3) Tj") || !strings.Contains(pageStream(p, 4), "/PG3 Do") {
+		t.Errorf("page numbers or thumbnail not moved along with the pages")
+	}
+	output(t, p)
+
+	mustPanic(t, "invalid page: 3", func() { newTestPdf().WriteTableOfContentsAt("", 3) })
+}
+
+func TestSetAlpha(t *testing.T) {
+	p := newTestPdf()
+	p.SetAlpha(0.5, "Multiply")
+	p.Rect(10, 10, 50, 50, "F")
+	p.SetAlphaReset()
+	p.SetAlpha(0.5, "Multiply")
+	ops := strings.Join(p.pages[1][len(p.pages[1])-4:], "\n")
+	if want := "/GS1 gs\n"; !strings.HasPrefix(ops, want) || !strings.Contains(ops, "/GS2 gs\n/GS1 gs") {
+		t.Errorf("gs operators %q, want GS1, GS2 for the reset, then GS1 again", ops)
+	}
+
+	doc := output(t, p)
+	for i, want := range []string{"/ca 0.500 /CA 0.500 /BM /Multiply", "/ca 1.000 /CA 1.000 /BM /Normal"} {
+		gs := pdfObject(t, doc, findRef(t, doc, `/GS`+strconv.Itoa(i+1)+` (\d+) 0 R`))
+		if !strings.Contains(gs, "<</Type /ExtGState "+want+">>") {
+			t.Errorf("GS%d is %q, want %q", i+1, gs, want)
+		}
+	}
+	if !strings.Contains(doc, "%PDF-1.4") {
+		t.Error("transparency does not raise the version to 1.4")
+	}
+	mustPanic(t, "incorrect blend mode: Glow", func() { p.SetAlpha(1, "Glow") })
+}
+
+func TestSetAlphaKept(t *testing.T) {
+	tests := []struct {
+		name string
+		draw func(p *Fpdf) []string
+		want []string
+	}{
+		{"page break", func(p *Fpdf) []string {
+			p.SetHeaderFunc(func() { p.SetAlpha(1, "") })
+			p.AddPage("", "", 0)
+			return p.pages[2]
+		}, []string{"/GS1 gs", "/GS2 gs", "/GS1 gs"}},
+		{"rotation", func(p *Fpdf) []string {
+			p.Rotate(30, 50, 50)
+			p.SetAlpha(0.2, "")
+			p.RotateReset()
+			return p.pages[1]
+		}, []string{"/GS1 gs", "/GS2 gs", "/GS2 gs"}},
+		{"before the first page", func(p *Fpdf) []string {
+			p = NewFpdf("P", "mm", "A4")
+			p.SetAlpha(0.5, "Multiply")
+			p.AddPage("", "", 0)
+			return p.pages[1]
+		}, []string{"/GS1 gs"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			p.SetAlpha(0.5, "Multiply")
+			var got []string
+			for _, op := range tt.draw(p) {
+				if strings.HasSuffix(op, " gs") {
+					got = append(got, op)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("gs operators %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetKerning(t *testing.T) {
+	p := newTestPdf()
+	plain := p.GetStringWidth("AWAY")
+	p.SetKerning(true)
+	// AW, WA and AY are kerned by -50, -50 and -100 units in Helvetica.
+	if got, want := p.GetStringWidth("AWAY"), plain-200*12/1000.0/p.k; math.Abs(got-want) > 1e-9 {
+		t.Errorf("kerned width %.3f, want %.3f", got, want)
+	}
+	p.Cell(40, 10, "AWAY", 0, 1, "L", false, "")
+	if op := "[(A) 50 (W) 50 (A) 100 (Y)] TJ"; !strings.Contains(pageStream(p, 1), op) {
+		t.Errorf("stream has no %q:\n%s", op, pageStream(p, 1))
+	}
+	for _, font := range [][2]string{{"courier", ""}, {"helvetica", "B"}, {"times", "I"}} {
+		p.SetFont(font[0], font[1], 12)
+		p.Cell(40, 10, "AWAY", 0, 1, "L", false, "")
+		if !strings.Contains(p.pages[1][len(p.pages[1])-1], "(AWAY) Tj") {
+			t.Errorf("%s %q text is kerned", font[0], font[1])
+		}
+	}
+}
+
+func TestBookmark(t *testing.T) {
+	p := newTestPdf()
+	p.Bookmark("Chapter 1", 0, -1)
+	p.SetY(80, true)
+	p.Bookmark("Section 1.1", 1, -1)
+	p.AddPage("", "", 0)
+	p.Bookmark("Section 1.2", 1, 50)
+	p.Bookmark("Chapter 2", 0, 100)
+	doc := output(t, p)
+
+	if !strings.Contains(doc, "/PageMode /UseOutlines") {
+		t.Error("catalog does not open the outline")
+	}
+	kids := regexp.MustCompile(`/Kids \[(\d+) 0 R (\d+) 0 R \]`).FindStringSubmatch(doc)
+	if kids == nil {
+		t.Fatal("no page tree")
+	}
+	entry := func(ref string) int {
+		t.Helper()
+		n, _ := strconv.Atoi(ref)
+		return n
+	}
+	get := func(obj, key string) string {
+		t.Helper()
+		m := regexp.MustCompile(`/` + key + ` (\d+) 0 R`).FindStringSubmatch(obj)
+		if m == nil {
+			t.Fatalf("no /%s in %q", key, obj)
+		}
+		return m[1]
+	}
+
+	rootRef := strconv.Itoa(findRef(t, doc, `/Outlines (\d+) 0 R`))
+	root := pdfObject(t, doc, entry(rootRef))
+	if !strings.Contains(root, "/Count 4") {
+		t.Errorf("root %q does not count 4 entries", root)
+	}
+	ch1Ref := get(root, "First")
+	ch1 := pdfObject(t, doc, entry(ch1Ref))
+	ch2Ref := get(root, "Last")
+	ch2 := pdfObject(t, doc, entry(ch2Ref))
+	s11 := pdfObject(t, doc, entry(get(ch1, "First")))
+	s12 := pdfObject(t, doc, entry(get(ch1, "Last")))
+	tests := []struct {
+		name, obj, title, page string
+		y                      float64
+		parent                 string
+	}{
+		{"chapter 1", ch1, "Chapter 1", kids[1], p.tMargin, rootRef},
+		{"section 1.1", s11, "Section 1.1", kids[1], 80, ch1Ref},
+		{"section 1.2", s12, "Section 1.2", kids[2], 50, ch1Ref},
+		{"chapter 2", ch2, "Chapter 2", kids[2], 100, rootRef},
+	}
+	for _, tt := range tests {
+		if !strings.Contains(tt.obj, "/Title ("+tt.title+")") {
+			t.Errorf("%s: %q", tt.name, tt.obj)
+		}
+		if want := sprintf("/Dest [%s 0 R /XYZ 0 %.2F null]", tt.page, (p.h-tt.y)*p.k); !strings.Contains(tt.obj, want) {
+			t.Errorf("%s: %q has no %s", tt.name, tt.obj, want)
+		}
+		if get(tt.obj, "Parent") != tt.parent {
+			t.Errorf("%s: parent %s, want %s", tt.name, get(tt.obj, "Parent"), tt.parent)
+		}
+	}
+	if get(ch1, "Next") != ch2Ref || !strings.Contains(ch1, "/Count 2") || get(s11, "Next") != get(ch1, "Last") {
+		t.Errorf("siblings not chained: %q %q", ch1, s11)
+	}
+}
+
+func TestOutputMulti(t *testing.T) {
+	p := newTestPdf()
+	p.Cell(40, 10, "fan out", 0, 0, "L", false, "")
+	var a, b bytes.Buffer
+	if err := p.OutputMulti(&a, failingWriter{}, &b); err == nil || err.Error() != "disk full" {
+		t.Errorf("write error %v not returned", err)
+	}
+	if a.Len() == 0 || !bytes.Equal(a.Bytes(), b.Bytes()) {
+		t.Errorf("writers received %d and %d bytes, want the same document", a.Len(), b.Len())
+	}
+}
+
+func TestPNGAlpha(t *testing.T) {
+	tests := []struct {
+		name  string
+		img   image.Image
+		cs    string
+		alpha byte
+	}{
+		{"rgba", func() image.Image {
+			img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+			for i := 0; i < len(img.Pix); i += 4 {
+				copy(img.Pix[i:], []byte{255, 0, 0, 128})
+			}
+			return img
+		}(), "/ColorSpace /DeviceRGB", 128},
+		{"paletted", image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.NRGBA{0, 0, 255, 64}, color.NRGBA{A: 255}}), "/ColorSpace [/Indexed /DeviceRGB 1 ", 64},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := png.Encode(&b, tt.img); err != nil {
+				t.Fatal(err)
+			}
+			p := newTestPdf()
+			p.RegisterImageBytes("logo.png", b.Bytes(), "")
+			p.Image("logo.png", 10, 10, 20, 0, "", nil)
+			doc := output(t, p)
+
+			img := pdfObject(t, doc, findRef(t, doc, `/I1 (\d+) 0 R`))
+			if !strings.Contains(img, tt.cs) || !strings.Contains(img, "/Filter /FlateDecode") {
+				t.Errorf("image is not a lossless %s: %q", tt.cs, img)
+			}
+			mask := pdfObject(t, doc, findRef(t, img, `/SMask (\d+) 0 R`))
+			if !strings.Contains(mask, "/ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /FlateDecode") {
+				t.Fatalf("soft mask %q", mask)
+			}
+			start := strings.Index(mask, "stream\n") + len("stream\n")
+			r, err := zlib.NewReader(strings.NewReader(mask[start:]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			alpha, _ := io.ReadAll(r)
+			if !bytes.Equal(alpha, bytes.Repeat([]byte{tt.alpha}, 16)) {
+				t.Errorf("soft mask %v, want 16 times %d", alpha, tt.alpha)
+			}
+		})
+	}
+}
+
+func TestWriteHTMLImageSize(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "chart.png")
+	if err := os.WriteFile(file, pngBytes(t, 40, 20, color.NRGBA{G: 255, A: 255}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p := newTestPdf()
+	avail := p.w - p.lMargin - p.rMargin
+	tests := []struct {
+		attrs string
+		w, h  float64
+	}{
+		{`width="50%"`, avail / 2, avail / 4},
+		{`width="96"`, 25.4, 12.7},
+		{`width="192px"`, 50.8, 25.4},
+		{`style="width: 3cm"`, 30, 15},
+		{`style="height:1in"`, 50.8, 25.4},
+		{`width="40mm" height="10mm"`, 40, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.attrs, func(t *testing.T) {
+			p := newTestPdf()
+			p.WriteHTML(`<img src="` + file + `" ` + tt.attrs + `>`)
+			m := regexp.MustCompile(`q ([0-9.]+) 0 0 ([0-9.]+) [0-9.]+ [0-9.]+ cm /I1 Do Q`).FindStringSubmatch(pageStream(p, 1))
+			if m == nil {
+				t.Fatalf("image not drawn:\n%s", pageStream(p, 1))
+			}
+			w, _ := strconv.ParseFloat(m[1], 64)
+			h, _ := strconv.ParseFloat(m[2], 64)
+			if math.Abs(w/p.k-tt.w) > 0.01 || math.Abs(h/p.k-tt.h) > 0.01 {
+				t.Errorf("image is %.2f by %.2f, want %.2f by %.2f", w/p.k, h/p.k, tt.w, tt.h)
+			}
+		})
+	}
+}
+
+func TestBand(t *testing.T) {
+	p := newTestPdf()
+	p.SetFillColor(1, 2, 3)
+	p.SetXY(50, 40)
+	p.Band(12, [3]int{200, 220, 255}, "Results", "C")
+
+	w := p.w - p.lMargin - p.rMargin
+	stream := pageStream(p, 1)
+	fill := sprintf("0.784 0.863 1.000 rg\n%.2F %.2F %.2F %.2F re f", p.lMargin*p.k, (p.h-40)*p.k, w*p.k, -12*p.k)
+	if !strings.Contains(stream, fill) {
+		t.Errorf("stream has no band %q:\n%s", fill, stream)
+	}
+	m := regexp.MustCompile(`BT ([0-9.]+) [0-9.]+ Td \(Results\) Tj`).FindStringSubmatch(stream)
+	if m == nil {
+		t.Fatalf("label not printed:\n%s", stream)
+	}
+	x, _ := strconv.ParseFloat(m[1], 64)
+	if want := p.lMargin + (w-p.GetStringWidth("Results"))/2; math.Abs(x/p.k-want) > 0.01 {
+		t.Errorf("label at %.2f, want centered at %.2f", x/p.k, want)
+	}
+	if p.GetY() != 52 || p.fillColor != "0.004 0.008 0.012 rg" {
+		t.Errorf("position %.2f and fill color %q not restored below the band", p.GetY(), p.fillColor)
+	}
+
+	q := newTestPdf()
+	q.SetY(q.pageBreakTrigger-5, true)
+	q.Band(12, [3]int{0, 0, 0}, "", "L")
+	if q.PageNo() != 2 {
+		t.Error("a band that does not fit is not moved to the next page")
+	}
+}
+
+type unreadable struct{ t *testing.T }
+
+func (r unreadable) Read([]byte) (int, error) {
+	r.t.Error("a registered image is read again")
+	return 0, io.EOF
+}
+
+func TestImageReader(t *testing.T) {
+	var jpg bytes.Buffer
+	if err := jpeg.Encode(&jpg, image.NewRGBA(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		data   []byte
+		filter string
+	}{
+		{"png", pngBytes(t, 8, 8, color.NRGBA{B: 255, A: 255}), "/Filter /FlateDecode"},
+		{"jpeg", jpg.Bytes(), "/Filter /DCTDecode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			p.ImageReader("from-db", bytes.NewReader(tt.data), 10, 10, 20, 0, "", nil)
+			p.ImageReader("from-db", unreadable{t}, 40, 10, 20, 0, "", nil)
+			if got := strings.Count(pageStream(p, 1), "/I1 Do"); got != 2 {
+				t.Errorf("image drawn %d times, want 2", got)
+			}
+			doc := output(t, p)
+			if img := pdfObject(t, doc, findRef(t, doc, `/I1 (\d+) 0 R`)); !strings.Contains(img, tt.filter) {
+				t.Errorf("image type not inferred, want %s: %q", tt.filter, img)
+			}
+		})
+	}
+}
+
+func TestImageDeduplication(t *testing.T) {
+	p := newTestPdf()
+	logo := pngBytes(t, 8, 8, color.NRGBA{R: 255, A: 255})
+	p.RegisterImageBytes("logo.png", logo, "")
+	p.RegisterImageBytes("assets/../logo.png", logo, "")
+	p.Image("logo.png", 10, 10, 20, 0, "", nil)
+	p.Image("assets/../logo.png", 40, 10, 20, 0, "", nil)
+	if p.GetImageCount() != 1 || p.ImageCount() != 2 {
+		t.Errorf("%d images under %d names, want 1 under 2", p.GetImageCount(), p.ImageCount())
+	}
+	if got := strings.Count(pageStream(p, 1), "/I1 Do"); got != 2 {
+		t.Errorf("the shared image is drawn %d times, want 2", got)
+	}
+
+	// Replacing a CMYK image keeps the one already drawn.
+	p.RegisterCMYKImage("swatch", 1, 1, []byte{0, 0, 0, 255})
+	p.Image("swatch", 10, 40, 10, 10, "", nil)
+	p.RegisterCMYKImage("swatch", 1, 1, []byte{255, 0, 0, 0})
+	p.Image("swatch", 30, 40, 10, 10, "", nil)
+	p.RegisterCMYKImage("swatch", 1, 1, []byte{255, 0, 0, 0})
+	p.Image("swatch", 50, 40, 10, 10, "", nil)
+	if p.GetImageCount() != 3 {
+		t.Errorf("%d images, want the logo and both swatches", p.GetImageCount())
+	}
+	doc := output(t, p)
+	if got := strings.Count(doc, "/Subtype /Image"); got != 3 {
+		t.Errorf("%d image objects written, want 3", got)
+	}
+	for _, name := range []string{"/I2", "/I3"} {
+		if !strings.Contains(pageStream(p, 1), name+" Do") {
+			t.Errorf("%s is not drawn", name)
+		}
+		findRef(t, doc, name+` (\d+) 0 R`)
+	}
+}
+
+func TestLegend(t *testing.T) {
+	entries := []LegendEntry{{[3]int{255, 0, 0}, "North"}, {[3]int{0, 255, 0}, "South"}, {[3]int{0, 0, 255}, "West"}}
+	tests := []struct {
+		name       string
+		horizontal bool
+		y          float64
+	}{
+		{"vertical", false, 50},
+		{"horizontal", true, 50},
+		{"at the bottom", false, 275},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			if tt.horizontal {
+				p.SetLegendLayout("horizontal")
+			}
+			p.Legend(20, tt.y, entries)
+			if p.PageNo() != 1 {
+				t.Fatal("the legend triggered a page break")
+			}
+			stream := pageStream(p, 1)
+			lh, size := p.fontSize*1.5, p.fontSize*0.8
+			x, y := 20.0, tt.y
+			for _, e := range entries {
+				swatch := sprintf("%.2F %.2F %.2F %.2F re f", x*p.k, (p.h-(y+(lh-size)/2))*p.k, size*p.k, -size*p.k)
+				if !strings.Contains(stream, swatch) {
+					t.Errorf("no swatch %q for %s", swatch, e.Label)
+				}
+				label := sprintf("BT %.2F ", (x+size*1.5+p.cMargin)*p.k)
+				if !regexp.MustCompile(regexp.QuoteMeta(label) + `[0-9.]+ Td \(` + e.Label + `\) Tj`).MatchString(stream) {
+					t.Errorf("%s not printed at %q", e.Label, label)
+				}
+				if tt.horizontal {
+					x += size*1.5 + p.GetStringWidth(e.Label) + 2*p.cMargin + size
+				} else {
+					y += lh
+				}
+			}
+		})
+	}
+}
+
+func TestSetFontFallback(t *testing.T) {
+	p := newTestPdf()
+	p.AddUTF8Font("test", "", testFont(t))
+	p.SetFontFallback("courier", "")
+	p.SetFont("test", "", 12)
+	p.Cell(40, 10, "AB€C", 0, 0, "L", false, "")
+
+	fallback := p.fonts["courier"].i
+	want := sprintf("<00010002> Tj /F%d 12.00 Tf (\x80) Tj /F%d 12.00 Tf <0003> Tj", fallback, p.currentFont.i)
+	if !strings.Contains(pageStream(p, 1), want) {
+		t.Errorf("stream has no %q:\n%q", want, pageStream(p, 1))
+	}
+	if got, want := p.GetStringWidth("AB€C"), float64(510+520+600+530)*12/1000/p.k; math.Abs(got-want) > 1e-9 {
+		t.Errorf("width %.3f, want %.3f with the Courier euro sign", got, want)
+	}
+	if !slices.Contains(p.UsedFonts(), "courier") {
+		t.Error("the fallback font is not embedded")
+	}
+}
+
+func TestAliasNbPages(t *testing.T) {
+	// The glyph ids of a font covering ' ' to '~'.
+	glyphs := func(s string) string {
+		var b strings.Builder
+		for _, c := range s {
+			b.WriteString(sprintf("%04X", c-0x1F))
+		}
+		return b.String()
+	}
+	font := testFontRange(t, ' ', 95)
+	for _, tc := range []struct {
+		name, family, alias, text, align string
+		want                             string
+	}{
+		{"core font", "helvetica", "", "Page {PageNo} of {nb}", "L", "(Page 1 of 3) Tj"},
+		{"custom alias", "helvetica", "%N", "%N pages", "L", "(3 pages) Tj"},
+		{"UTF-8 font", "test", "", "Page {PageNo} of {nb}", "L",
+			"[<" + glyphs("Page 1 of ") + "> <" + glyphs("3") + ">] TJ"},
+		{"justified UTF-8 font", "test", "", "of {nb} pages", "J",
+			"<" + glyphs("3") + "> <" + glyphs(" ") + ">"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newTestPdf()
+			p.AddUTF8Font("test", "", font)
+			p.AliasNbPages(tc.alias)
+			p.SetFooterFunc(func() {
+				p.SetY(-30, true)
+				p.SetFont(tc.family, "", 10)
+				txt := strings.ReplaceAll(tc.text, "{PageNo}", strconv.Itoa(p.PageNo()))
+				if tc.align == "J" {
+					p.MultiCell(80, 5, strings.Repeat(txt+" ", 4), "", "J", false)
+					return
+				}
+				p.Cell(0, 10, txt, 0, 0, tc.align, false, "")
+			})
+			p.AddPage("", "", 0)
+			p.AddPage("", "", 0)
+			doc := output(t, p)
+			if !strings.Contains(doc, tc.want) {
+				t.Errorf("document has no %q", tc.want)
+			}
+			if alias := cmp.Or(tc.alias, "{nb}"); strings.Contains(doc, alias) {
+				t.Errorf("alias %q left in the document", alias)
+			}
+			if strings.Contains(doc, glyphs("{nb}")) {
+				t.Error("alias left in glyph ids")
+			}
+		})
+	}
+}
+
+func TestAddLink(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		entry func(p *Fpdf, link int)
+	}{
+		{"Cell", func(p *Fpdf, link int) { p.Cell(40, 10, "Chapter 2", 0, 1, "L", false, link) }},
+		{"Write", func(p *Fpdf, link int) { p.Write(5, "Chapter 2", link) }},
+		{"Link", func(p *Fpdf, link int) { p.Link(10, 10, 40, 10, link) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newTestPdf()
+			link := p.AddLink()
+			tc.entry(p, link)
+			p.AddPage("", "", 0)
+			p.AddPage("", "", 0)
+			p.SetY(50, true)
+			p.SetLink(link, -1, 0)
+			p.Cell(40, 10, "Chapter 2", 0, 1, "L", false, "")
+
+			doc := output(t, p)
+			want := sprintf("/Dest [%d 0 R /XYZ 0 %.2F null]", toInt(p.pageInfo[3]["n"]), p.hPt-50*p.k)
+			if !strings.Contains(doc, want) {
+				t.Errorf("document has no %q", want)
+			}
+		})
+	}
+
+	p := newTestPdf()
+	mustPanic(t, "undefined link", func() { p.SetLink(1, 0, 1) })
+}
+
+func TestDuplicatePage(t *testing.T) {
+	streams := regexp.MustCompile(`(?s)/Contents \d+ 0 R>>\nendobj\n\d+ 0 obj\n<</Length \d+>>\nstream\n(.*?)endstream`)
+	for _, count := range []int{0, 1, 2} {
+		t.Run(strconv.Itoa(count), func(t *testing.T) {
+			p := newTestPdf()
+			for y := 20.0; y < 250; y += 10 {
+				p.Line(10, y, 200, y)
+			}
+			top := p.AddLink()
+			p.SetLink(top, 0, 1)
+			p.Cell(40, 10, "Top", 0, 1, "L", false, top)
+			p.DuplicatePage(1, count)
+
+			doc := output(t, p)
+			if p.PageNo() != count+1 {
+				t.Fatalf("%d pages, want %d", p.PageNo(), count+1)
+			}
+			found := streams.FindAllStringSubmatch(doc, -1)
+			if len(found) != count+1 {
+				t.Fatalf("%d content streams, want %d", len(found), count+1)
+			}
+			for i, m := range found {
+				if m[1] != found[0][1] {
+					t.Errorf("page %d content differs from page 1:\n%s", i+1, m[1])
+				}
+				want := sprintf("/Dest [%d 0 R /XYZ 0 %.2F null]", toInt(p.pageInfo[i+1]["n"]), p.hPt)
+				if !strings.Contains(doc, want) {
+					t.Errorf("page %d link has no %q", i+1, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSetMaxImageDimension(t *testing.T) {
+	photo := image.NewRGBA(image.Rect(0, 0, 4000, 300))
+	for i := range photo.Pix {
+		photo.Pix[i] = uint8(i)
+	}
+	var jpg bytes.Buffer
+	if err := jpeg.Encode(&jpg, photo, nil); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		data   []byte
+		max    int
+		size   string
+		filter string
+	}{
+		{"png", pngBytes(t, 4000, 300, color.NRGBA{R: 255, A: 255}), 1000, "/Width 1000\n/Height 75\n", "/FlateDecode"},
+		{"jpeg", jpg.Bytes(), 1000, "/Width 1000\n/Height 75\n", "/DCTDecode"},
+		{"tall", pngBytes(t, 30, 4000, color.NRGBA{R: 255, A: 255}), 1000, "/Width 8\n/Height 1000\n", "/FlateDecode"},
+		{"within limit", pngBytes(t, 800, 60, color.NRGBA{R: 255, A: 255}), 1000, "/Width 800\n/Height 60\n", "/FlateDecode"},
+		{"no limit", jpg.Bytes(), 0, "/Width 4000\n/Height 300\n", "/DCTDecode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			p.SetMaxImageDimension(tt.max)
+			p.ImageReader("photo", bytes.NewReader(tt.data), 10, 10, 50, 0, "", nil)
+			// Downscaling keeps the natural size of the image on the page.
+			p.Image("photo", 10, 10, 0, 0, "", nil)
+			ref := newTestPdf()
+			ref.ImageReader("photo", bytes.NewReader(tt.data), 10, 10, 0, 0, "", nil)
+			if got, want := p.pages[1][len(p.pages[1])-1], ref.pages[1][len(ref.pages[1])-1]; got != want {
+				t.Errorf("unsized image placed as %q, want %q", got, want)
+			}
+			doc := output(t, p)
+			img := pdfObject(t, doc, findRef(t, doc, `/I1 (\d+) 0 R`))
+			if !strings.Contains(img, tt.size) || !strings.Contains(img, tt.filter) {
+				t.Errorf("image is not %s with %s: %.120q", tt.size, tt.filter, img)
+			}
+		})
+	}
+
+	p := newTestPdf()
+	mustPanic(t, "incorrect maximum image dimension", func() { p.SetMaxImageDimension(-1) })
+}
+
+func TestSetDashPattern(t *testing.T) {
+	tests := []struct {
+		name  string
+		dash  []float64
+		phase float64
+		want  string
+	}{
+		{"dashed", []float64{3, 1}, 0.5, "[8.50 2.83] 1.42 d"},
+		{"dotted", []float64{0, 2}, 0, "[0.00 5.67] 0.00 d"},
+		{"nil", nil, 2, "[] 0.00 d"},
+		{"empty", []float64{}, 2, "[] 0.00 d"},
+		{"zeros", []float64{0, 0}, 2, "[] 0.00 d"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			p.SetDashPattern([]float64{5}, 0)
+			p.SetDashPattern(tt.dash, tt.phase)
+			p.Line(10, 10, 100, 10)
+			p.Rect(10, 20, 50, 20, "D")
+			lines := p.pages[1]
+			if got := lines[len(lines)-3]; got != tt.want {
+				t.Errorf("operator %q, want %q", got, tt.want)
+			}
+			p.AddPage("", "", 0)
+			if solid := tt.want == "[] 0.00 d"; slices.Contains(p.pages[2], tt.want) == solid {
+				t.Errorf("pattern %q not carried over to the next page only when dashed:\n%s", tt.want, pageStream(p, 2))
+			}
+		})
+	}
+
+	p := newTestPdf()
+	mustPanic(t, "dash lengths must not be negative", func() { p.SetDashPattern([]float64{2, -1}, 0) })
+}
+
+func TestLineCapAndJoinStyles(t *testing.T) {
+	for style := range 3 {
+		t.Run(strconv.Itoa(style), func(t *testing.T) {
+			p := newTestPdf()
+			p.SetLineCapStyle(style)
+			p.SetLineJoinStyle(style)
+			p.Line(10, 10, 100, 10)
+			capOp, joinOp := sprintf("%d J", style), sprintf("%d j", style)
+			if s := pageStream(p, 1); !strings.HasSuffix(s, capOp+"\n"+joinOp+"\n"+"28.35 813.54 m 283.46 813.54 l S") {
+				t.Errorf("line not drawn with %q and %q:\n%s", capOp, joinOp, s)
+			}
+			p.AddPage("", "", 0)
+			if got := p.pages[2][0]; got != capOp {
+				t.Errorf("page 2 starts with %q, want %q", got, capOp)
+			}
+			if got := slices.Contains(p.pages[2], joinOp); got != (style != 0) {
+				t.Errorf("join style %q on page 2: %t", joinOp, got)
+			}
+		})
+	}
+
+	p := newTestPdf()
+	mustPanic(t, "incorrect line cap style", func() { p.SetLineCapStyle(3) })
+	mustPanic(t, "incorrect line join style", func() { p.SetLineJoinStyle(-1) })
+}
+
+func TestWriteWithSuperscripts(t *testing.T) {
+	tj := regexp.MustCompile(`\((.*)\) Tj`)
+	ts := regexp.MustCompile(`^BT (-?[0-9.]+) Ts ET$`)
+	tests := []struct {
+		name                string
+		txt                 string
+		exponents, ordinals bool
+		printed, raised     string
+	}{
+		{"both", "m^2 and 1st x^{10}", true, true, "m2 and 1st x10", "2 st 10"},
+		{"exponents", "m^2 and 1st", true, false, "m2 and 1st", "2"},
+		{"ordinals", "m^2 and 1st", false, true, "m^2 and 1st", "st"},
+		{"none", "m^2 and 1st", false, false, "m^2 and 1st", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			p.SetSuperscriptPatterns(tt.exponents, tt.ordinals)
+			p.WriteWithSuperscripts(5, tt.txt)
+			var printed string
+			var raised []string
+			rise := "0.00"
+			for _, line := range p.pages[1] {
+				if m := ts.FindStringSubmatch(line); m != nil {
+					rise = m[1]
+				} else if m := tj.FindStringSubmatch(line); m != nil {
+					printed += m[1]
+					if rise != "0.00" {
+						raised = append(raised, m[1])
+					}
+				}
+			}
+			if printed != tt.printed || strings.Join(raised, " ") != tt.raised {
+				t.Errorf("printed %q with %q raised, want %q with %q", printed, raised, tt.printed, tt.raised)
+			}
+			if rise != "0.00" || p.fontSizePt != 12 {
+				t.Errorf("rise %s and size %g after the text", rise, p.fontSizePt)
+			}
+		})
+	}
+}
+
+func TestSetDateTimezone(t *testing.T) {
+	tests := []struct {
+		name   string
+		set    bool
+		loc    *time.Location
+		suffix string
+	}{
+		{"default", false, nil, "Z"},
+		{"nil", true, nil, "Z"},
+		{"UTC", true, time.UTC, "Z"},
+		{"east", true, time.FixedZone("IST", 5*3600+1800), "+05'30'"},
+		{"west", true, time.FixedZone("BRT", -3*3600), "-03'00'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			if tt.set {
+				p.SetDateTimezone(tt.loc)
+			}
+			doc := output(t, p)
+			re := regexp.MustCompile(`/CreationDate \(D:[0-9]{14}` + regexp.QuoteMeta(tt.suffix) + `\)`)
+			if !re.MatchString(doc) {
+				t.Errorf("creation date does not end with %q:\n%s", tt.suffix, regexp.MustCompile(`/CreationDate .*`).FindString(doc))
+			}
+		})
+	}
+}
+
+func TestSetFontUnitSize(t *testing.T) {
+	tests := []struct {
+		unit       string
+		size, want float64
+	}{
+		{"mm", 5, 5 * 72 / 25.4},
+		{"cm", 0.5, 0.5 * 72 / 2.54},
+		{"in", 0.25, 18},
+		{"pt", 14, 14},
+	}
+	for _, tt := range tests {
+		t.Run(tt.unit, func(t *testing.T) {
+			p := NewFpdf("P", tt.unit, "A4")
+			p.SetCompression(false)
+			p.AddPage("", "", 0)
+			p.SetFont("helvetica", "", 12)
+			p.SetFontUnitSize(tt.size)
+			pt, unit := p.GetFontSize()
+			if math.Abs(pt-tt.want) > 1e-9 || math.Abs(unit-tt.size) > 1e-9 {
+				t.Errorf("GetFontSize() = %g, %g, want %g, %g", pt, unit, tt.want, tt.size)
+			}
+			if want := sprintf("BT /F1 %.2F Tf ET", tt.want); !strings.HasSuffix(pageStream(p, 1), want) {
+				t.Errorf("stream does not end with %q", want)
+			}
+		})
+	}
+}
+
+func TestMeasureTextWidth(t *testing.T) {
+	font := testFont(t)
+	tests := []struct {
+		family, style string
+		size          float64
+		txt           string
+	}{
+		{"courier", "", 10, "Column"},
+		{"Helvetica", "B", 14, "Total"},
+		{"times", "I", 9, "AVATAR"},
+		{"test", "", 12, "ABCDE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.family+tt.style, func(t *testing.T) {
+			p := newTestPdf()
+			p.AddUTF8Font("test", "", font)
+			p.SetFont("helvetica", "U", 12)
+			current, family, style, n := p.currentFont, p.fontFamily, p.fontStyle, len(p.pages[1])
+			got := p.MeasureTextWidth(tt.family, tt.style, tt.size, tt.txt)
+			if p.currentFont != current || p.fontFamily != family || p.fontStyle != style || !p.underline ||
+				p.fontSizePt != 12 || p.fontSize != 12/p.k || len(p.pages[1]) != n {
+				t.Error("current font changed")
+			}
+			p.SetFont(tt.family, tt.style, tt.size)
+			if want := p.GetStringWidth(tt.txt); math.Abs(got-want) > 1e-9 {
+				t.Errorf("width %g, want %g", got, want)
+			}
+		})
+	}
+}
+
+func TestVerticalText(t *testing.T) {
+	td := regexp.MustCompile(`^BT (\S+) (\S+) Td (.*) Tj ET$`)
+	font := testFont(t)
+	tests := []struct {
+		name, family, txt string
+		spacing           float64
+		// The rows of the printed characters and their half widths.
+		rows []int
+		half []float64
+	}{
+		{"core font", "courier", "ABCD", 1, []int{0, 1, 2, 3}, []float64{1.27, 1.27, 1.27, 1.27}},
+		{"UTF-8 font", "test", "ABCD", 0, []int{0, 1, 2, 3}, []float64{1.08, 1.10, 1.12, 1.14}},
+		{"spaces", "courier", "A  D", 2, []int{0, 3}, []float64{1.27, 1.27}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			p.AddUTF8Font("test", "", font)
+			p.SetFont(tt.family, "", 12)
+			n := len(p.pages[1])
+			p.VerticalText(50, 30, tt.txt, tt.spacing)
+			ops := p.pages[1][n:]
+			if len(ops) != len(tt.rows) {
+				t.Fatalf("%d characters printed, want %d:\n%s", len(ops), len(tt.rows), strings.Join(ops, "\n"))
+			}
+			step := p.fontSize + tt.spacing
+			for i, op := range ops {
+				m := td.FindStringSubmatch(op)
+				if m == nil {
+					t.Fatalf("not a text operator: %q", op)
+				}
+				x, _ := strconv.ParseFloat(m[1], 64)
+				y, _ := strconv.ParseFloat(m[2], 64)
+				wantX, wantY := 50-tt.half[i], 30+float64(tt.rows[i])*step
+				if math.Abs(x/p.k-wantX) > 0.01 || math.Abs(p.h-y/p.k-wantY) > 0.01 {
+					t.Errorf("character %d at (%.2f, %.2f), want (%.2f, %.2f)", i, x/p.k, p.h-y/p.k, wantX, wantY)
+				}
+			}
+		})
+	}
+}
+
+func TestGradientPatterns(t *testing.T) {
+	tests := []struct {
+		name  string
+		set   func(p *Fpdf, id int)
+		reset func(p *Fpdf)
+		color string
+		style string
+		paint string
+	}{
+		{"stroke", (*Fpdf).SetDrawPattern, func(p *Fpdf) { p.SetDrawColor(0, 0, 0) }, "/Pattern CS /SP1 SCN", "D", "re S"},
+		{"fill", (*Fpdf).SetFillPattern, func(p *Fpdf) { p.SetFillColor(0, 0, 0) }, "/Pattern cs /SP1 scn", "F", "re f"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			id := p.AddGradientPattern(20, 20, 120, 20, 255, 0, 0, 0, 0, 255)
+			p.SetLineWidth(4)
+			tt.set(p, id)
+			p.Rect(20, 20, 100, 50, tt.style)
+			if s := pageStream(p, 1); !strings.HasSuffix(s, tt.color+"\n56.69 785.20 283.46 -141.73 "+tt.paint) {
+				t.Errorf("rectangle not painted with %q:\n%s", tt.color, s)
+			}
+			p.AddPage("", "", 0)
+			if !slices.Contains(p.pages[2], tt.color) {
+				t.Errorf("pattern not set again on page 2:\n%s", pageStream(p, 2))
+			}
+			tt.reset(p)
+			p.AddPage("", "", 0)
+			if slices.Contains(p.pages[3], tt.color) {
+				t.Errorf("pattern set again on page 3 after the color was reset:\n%s", pageStream(p, 3))
+			}
+
+			doc := output(t, p)
+			pattern := pdfObject(t, doc, findRef(t, doc, `/SP1 (\d+) 0 R`))
+			if !strings.Contains(pattern, "/Type /Pattern /PatternType 2 /Shading ") {
+				t.Errorf("not a shading pattern: %q", pattern)
+			}
+			shading := pdfObject(t, doc, findRef(t, pattern, `/Shading (\d+) 0 R`))
+			if !strings.Contains(shading, "/ShadingType 2") || !strings.Contains(shading, "/Coords [56.69291 785.19709 340.15748 785.19709]") {
+				t.Errorf("not the axial shading of the gradient: %q", shading)
+			}
+		})
+	}
+
+	p := newTestPdf()
+	mustPanic(t, "undefined pattern", func() { p.SetDrawPattern(1) })
+}
+
+func TestSuperscriptAndSubscript(t *testing.T) {
+	ts := regexp.MustCompile(`^BT (-?[0-9.]+) Ts ET$`)
+	tf := regexp.MustCompile(`^BT /F\d+ ([0-9.]+) Tf ET$`)
+	tj := regexp.MustCompile(`\((.*)\) Tj`)
+	tests := []struct {
+		name  string
+		draw  func(p *Fpdf)
+		want  []string // text, rise and size of each run
+		final string
+	}{
+		{"sup", func(p *Fpdf) { p.WriteHTML("E=mc<sup>2</sup>") }, []string{"E=mc 0.00 12.00", "2 4.20 8.40"}, "0.00 12.00"},
+		{"sub", func(p *Fpdf) { p.WriteHTML("H<sub>2</sub>O") }, []string{"H 0.00 12.00", "2 -2.40 8.40", "O 0.00 12.00"}, "0.00 12.00"},
+		{"nested", func(p *Fpdf) { p.WriteHTML("x<sup>a<sup>b</sup>c</sup>") },
+			[]string{"x 0.00 12.00", "a 4.20 8.40", "b 7.14 5.88", "c 4.20 8.40"}, "0.00 12.00"},
+		{"methods", func(p *Fpdf) {
+			p.Write(5, "x", "")
+			p.SetSubscript(true)
+			p.Write(5, "i", "")
+			p.SetSuperscript(true)
+			p.Write(5, "2", "")
+			p.SetSuperscript(false)
+			p.SetSuperscript(false)
+			p.SetSuperscript(false)
+		}, []string{"x 0.00 12.00", "i -2.40 8.40", "2 0.54 5.88"}, "0.00 12.00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			tt.draw(p)
+			var runs []string
+			rise, size := "0.00", "12.00"
+			for _, line := range p.pages[1] {
+				if m := ts.FindStringSubmatch(line); m != nil {
+					rise = m[1]
+				} else if m := tf.FindStringSubmatch(line); m != nil {
+					size = m[1]
+				} else if m := tj.FindStringSubmatch(line); m != nil {
+					runs = append(runs, m[1]+" "+rise+" "+size)
+				}
+			}
+			if !slices.Equal(runs, tt.want) || rise+" "+size != tt.final {
+				t.Errorf("runs %q ending at %s %s, want %q ending at %s", runs, rise, size, tt.want, tt.final)
+			}
+		})
+	}
+}
+
+func TestWriteHTMLHeadings(t *testing.T) {
+	tf := regexp.MustCompile(`^BT /F(\d+) ([0-9.]+) Tf ET$`)
+	td := regexp.MustCompile(`^BT ([0-9.]+) ([0-9.]+) Td \((.*)\) Tj ET$`)
+	tests := []struct {
+		name  string
+		sizes map[int]float64
+		html  string
+		want  []string // text, bold and size of each line
+	}{
+		{"default sizes", nil, "<h1>Title</h1><h2>Sub</h2>", []string{"Title B 24.00", "Sub B 20.00"}},
+		{"after text", nil, "Intro<h3>Title</h3>Body", []string{"Intro  12.00", "Title B 16.00", "Body  12.00"}},
+		{"custom sizes", map[int]float64{1: 30}, "<h1>Title</h1><h6>Small</h6>", []string{"Title B 30.00", "Small B 10.00"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			if tt.sizes != nil {
+				p.SetHTMLHeadingSizes(tt.sizes)
+			}
+			p.WriteHTML(tt.html)
+			var lines []string
+			font, size, lastY := "", "", math.Inf(1)
+			for _, op := range p.pages[1] {
+				if m := tf.FindStringSubmatch(op); m != nil {
+					font, size = m[1], m[2]
+					continue
+				}
+				m := td.FindStringSubmatch(op)
+				if m == nil {
+					continue
+				}
+				bold := ""
+				if font == strconv.Itoa(p.fonts["helveticaB"].i) {
+					bold = "B"
+				}
+				lines = append(lines, m[3]+" "+bold+" "+size)
+				y, _ := strconv.ParseFloat(m[2], 64)
+				if m[1] != "31.19" || y >= lastY {
+					t.Errorf("%q at (%s, %s), not at the margin on a new line", m[3], m[1], m[2])
+				}
+				lastY = y
+			}
+			if !slices.Equal(lines, tt.want) {
+				t.Errorf("lines %q, want %q", lines, tt.want)
+			}
+			if p.fontStyle != "" || p.fontSizePt != 12 {
+				t.Errorf("font %q %g after the headings, want the regular 12 point font", p.fontStyle, p.fontSizePt)
+			}
+		})
+	}
+
+	mustPanic(t, "incorrect heading size", func() { newTestPdf().SetHTMLHeadingSizes(map[int]float64{7: 8}) })
+}
+
+func TestSetContinuationText(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		draw func(p *Fpdf)
+		want int // pages with the note
+	}{
+		{"table", "(continued)", func(p *Fpdf) {
+			tb := p.NewTable(40, 40)
+			tb.SetHeader("Item", "Qty")
+			for i := range 120 {
+				tb.AddRow("item "+strconv.Itoa(i), "1")
+			}
+			tb.Draw()
+		}, 2},
+		{"MultiCell", "(continued)", func(p *Fpdf) { p.MultiCell(0, 10, strings.Repeat("line\n", 40), "", "L", false) }, 1},
+		{"Cell", "(continued)", func(p *Fpdf) {
+			for range 40 {
+				p.Cell(0, 10, "line", 0, 1, "L", false, "")
+			}
+		}, 0},
+		{"no text", "", func(p *Fpdf) { p.MultiCell(0, 10, strings.Repeat("line\n", 40), "", "L", false) }, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			p.SetContinuationText(tt.text)
+			tt.draw(p)
+			// The note is at the bottom right, below the last line.
+			want := sprintf("BT %.2F %.2F Td (%s) Tj ET", (p.w-p.rMargin-p.cMargin-p.GetStringWidth(tt.text))*p.k,
+				(p.h-(p.pageBreakTrigger+0.75*p.fontSize+0.3*p.fontSize))*p.k, strings.NewReplacer("(", `\(`, ")", `\)`).Replace(tt.text))
+			pages := 0
+			for n := 1; n <= p.PageNo(); n++ {
+				if slices.Contains(p.pages[n], want) {
+					pages++
+				}
+			}
+			if p.PageNo() < 2 || pages != tt.want {
+				t.Errorf("note on %d of %d pages, want %d", pages, p.PageNo(), tt.want)
+			}
+		})
+	}
+}
+
+func TestClampText(t *testing.T) {
+	const txt = "Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor incididunt"
+	tests := []struct {
+		name     string
+		maxLines int
+		lines    int
+		ellipsis bool
+	}{
+		{"clamped", 2, 2, true},
+		{"one line", 1, 1, true},
+		{"fits", 5, 5, false},
+		{"room to spare", 8, 5, false},
+		{"no lines", 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			wrapped := p.SplitLines(txt, 50)
+			if len(wrapped) != 5 {
+				t.Fatalf("text wraps to %d lines, want 5", len(wrapped))
+			}
+			got := p.ClampText(50, tt.maxLines, txt)
+			var lines []string
+			if got != "" {
+				lines = strings.Split(got, "\n")
+			}
+			if len(lines) != tt.lines {
+				t.Fatalf("%d lines, want %d: %q", len(lines), tt.lines, got)
+			}
+			if strings.HasSuffix(got, "…") != tt.ellipsis {
+				t.Errorf("%q: ellipsis %t, want %t", got, !tt.ellipsis, tt.ellipsis)
+			}
+			for i, line := range lines {
+				if p.GetStringWidth(line) > 50-2*p.cMargin+1e-9 {
+					t.Errorf("line %q wider than the cell", line)
+				}
+				if kept := strings.TrimSuffix(line, "…"); !strings.HasPrefix(wrapped[i], kept) {
+					t.Errorf("line %q is not the start of %q", line, wrapped[i])
+				}
+			}
+		})
+	}
+}
+
+func TestWriteHTMLLists(t *testing.T) {
+	td := regexp.MustCompile(`^BT ([0-9.]+) [0-9.]+ Td \((.*)\) Tj ET$`)
+	tests := []struct {
+		name string
+		html string
+		want []string // text and indentation level of each run
+	}{
+		{"ordered in unordered", `<ul><li>A<ol start="3"><li>one</li><li>two</li></ol></li><li>B</li></ul>after`,
+			[]string{"\x95 0", "A 1", "3. 1", "one 2", "4. 1", "two 2", "\x95 0", "B 1", "after 0"}},
+		{"unordered in ordered", "<ol><li>A<ul><li>x</li></ul></li><li>B</li></ol>",
+			[]string{"1. 0", "A 1", "\x95 1", "x 2", "2. 0", "B 1"}},
+		{"left open", "<ol><li>A<ul><li>x",
+			[]string{"1. 0", "A 1", "\x95 1", "x 2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			margin := p.lMargin
+			p.WriteHTML(tt.html)
+			var runs []string
+			for _, op := range p.pages[1] {
+				if m := td.FindStringSubmatch(op); m != nil {
+					x, _ := strconv.ParseFloat(m[1], 64)
+					runs = append(runs, sprintf("%s %d", m[2], int((x/p.k-margin)/htmlListIndent)))
+				}
+			}
+			if !slices.Equal(runs, tt.want) {
+				t.Errorf("runs %q, want %q", runs, tt.want)
+			}
+			if p.lMargin != margin {
+				t.Errorf("left margin %g after the lists, want %g", p.lMargin, margin)
+			}
+		})
+	}
+}
+
+func TestWriteHTMLClosesOpenElements(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+	}{
+		{"heading", "<h1>Title"},
+		{"superscript", "E=mc<sup>2"},
+		{"nested scripts", "x<sub>i<sup>2"},
+		{"list", "<ul><li>item"},
+		{"all", "<ol><li><h2>x<sup>2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			p.SetSubscript(true)
+			size, rise, margin := p.fontSizePt, p.rise, p.lMargin
+			p.WriteHTML(tt.html)
+			if p.fontStyle != "" || p.fontSizePt != size || p.rise != rise || len(p.scripts) != 1 {
+				t.Errorf("font %q %g with rise %g after the HTML, want the subscript set before it", p.fontStyle, p.fontSizePt, p.rise)
+			}
+			if p.lMargin != margin {
+				t.Errorf("left margin %g after the HTML, want %g", p.lMargin, margin)
+			}
+		})
+	}
+}
+
+func TestWriteHTMLImage(t *testing.T) {
+	dir := t.TempDir()
+	logo, wide := filepath.Join(dir, "logo.png"), filepath.Join(dir, "wide.png")
+	if err := os.WriteFile(logo, pngBytes(t, 96, 48, color.NRGBA{B: 255, A: 255}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(wide, pngBytes(t, 2000, 100, color.NRGBA{B: 255, A: 255}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ref := newTestPdf()
+	avail := ref.w - ref.lMargin - ref.rMargin
+	tests := []struct {
+		name string
+		html string
+		w, h float64
+		err  string
+	}{
+		{"intrinsic size", `Logo:<img src="` + logo + `">`, 25.4, 12.7, ""},
+		{"wider than the content", `<img src="` + wide + `">`, avail, avail / 20, ""},
+		{"height only", `<img src="` + logo + `" height="48">`, 25.4, 12.7, ""},
+		{"natural width in pixels", `<img src="` + logo + `" width="96px">`, 25.4, 12.7, ""},
+		{"remote", `<img src="https://example.com/logo.png">`, 0, 0, "remote image not supported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			p.WriteHTML(tt.html)
+			m := regexp.MustCompile(`q ([0-9.]+) 0 0 ([0-9.]+) ([0-9.]+) [0-9.]+ cm /I1 Do Q`).FindStringSubmatch(pageStream(p, 1))
+			if tt.err != "" {
+				if m != nil || p.Error() == nil || !strings.Contains(p.Error().Error(), tt.err) {
+					t.Errorf("image drawn or error %v, want %q", p.Error(), tt.err)
+				}
+				return
+			}
+			if m == nil {
+				t.Fatalf("image not drawn (%v):\n%s", p.Error(), pageStream(p, 1))
+			}
+			w, _ := strconv.ParseFloat(m[1], 64)
+			h, _ := strconv.ParseFloat(m[2], 64)
+			x, _ := strconv.ParseFloat(m[3], 64)
+			if math.Abs(w/p.k-tt.w) > 0.01 || math.Abs(h/p.k-tt.h) > 0.01 || math.Abs(x/p.k-p.lMargin) > 0.01 {
+				t.Errorf("image is %.2f by %.2f at x %.2f, want %.2f by %.2f at the margin", w/p.k, h/p.k, x/p.k, tt.w, tt.h)
+			}
+		})
+	}
+}
+
+func TestWriteColored(t *testing.T) {
+	cell := regexp.MustCompile(`^q ([0-9.]+ [0-9.]+ [0-9.]+) rg BT [0-9.]+ ([0-9.]+) Td \((.*)\) Tj ET Q$`)
+	red, green, blue := [3]int{255, 0, 0}, [3]int{0, 255, 0}, [3]int{0, 0, 255}
+	tests := []struct {
+		name string
+		x    float64
+		runs []TextRun
+		want []string // color, line and text of each cell
+	}{
+		{"wrap mid-run", 120, []TextRun{
+			{Text: "if ", Color: red, Style: "B"},
+			{Text: "ready and waiting for the next line", Color: green},
+			{Text: " end", Color: blue},
+		}, []string{
+			"1.000 0.000 0.000 0 if ",
+			"0.000 1.000 0.000 0 ready and waiting for the next",
+			"0.000 1.000 0.000 1 line",
+			"0.000 0.000 1.000 1  end",
+		}},
+		{"word across runs", 185, []TextRun{
+			{Text: "a ", Color: red},
+			{Text: "key", Color: green},
+			{Text: "word", Color: blue},
+		}, []string{
+			"1.000 0.000 0.000 0 a",
+			"0.000 1.000 0.000 1 key",
+			"0.000 0.000 1.000 1 word",
+		}},
+		{"long word", 150, []TextRun{
+			{Text: "red ", Color: red},
+			{Text: "g" + strings.Repeat("x", 95), Color: green},
+			{Text: "blue", Color: blue},
+		}, []string{
+			"1.000 0.000 0.000 0 red",
+			"0.000 1.000 0.000 1 g" + strings.Repeat("x", 87),
+			"0.000 1.000 0.000 2 " + strings.Repeat("x", 8),
+			"0.000 0.000 1.000 2 blue",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			p.SetTextColor(1, 2, 3)
+			p.SetX(tt.x)
+			top := (p.h - p.y) * p.k
+			p.WriteColored(5, tt.runs)
+			var cells []string
+			for _, op := range p.pages[1] {
+				if m := cell.FindStringSubmatch(op); m != nil {
+					y, _ := strconv.ParseFloat(m[2], 64)
+					line := int(math.Floor((top - y) / (5 * p.k)))
+					cells = append(cells, sprintf("%s %d %s", m[1], line, m[3]))
+				}
+			}
+			if !slices.Equal(cells, tt.want) {
+				t.Errorf("cells\n%q\nwant\n%q", cells, tt.want)
+			}
+			if p.fontStyle != "" || p.textColor != "0.004 0.008 0.012 rg" {
+				t.Errorf("style %q and color %q not restored", p.fontStyle, p.textColor)
+			}
+		})
+	}
+}
+
+func TestWriteHTMLFontStyle(t *testing.T) {
+	tf := regexp.MustCompile(`^BT /F(\d+) ([0-9.]+) Tf ET$`)
+	tj := regexp.MustCompile(`\((.*)\) Tj`)
+	tests := []struct {
+		name string
+		html string
+		want []string // text, font and size of each run
+	}{
+		{"px and family", `<span style="font-size:18px;font-family:times">big</span> after`,
+			[]string{"big times 13.50", " after helvetica 12.00"}},
+		{"pt", `<span style="font-size:16pt">big</span>`, []string{"big helvetica 16.00"}},
+		{"em", `<span style="font-size:1.5em">big</span>`, []string{"big helvetica 18.00"}},
+		{"generic family", `<span style="font-family:'Courier New', monospace">code</span>`, []string{"code courier 12.00"}},
+		{"unknown family", `<span style="font-family:Comic Sans">x</span>`, []string{"x helvetica 12.00"}},
+		{"bold kept", `<b><span style="font-family:times">x</span>y</b>z`,
+			[]string{"x timesB 12.00", "y helveticaB 12.00", "z helvetica 12.00"}},
+		{"nested", `<span style="font-size:20pt">a<span style="font-family:courier">b</span>c</span>d`,
+			[]string{"a helvetica 20.00", "b courier 20.00", "c helvetica 20.00", "d helvetica 12.00"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			p.WriteHTML(tt.html)
+			keys := map[string]string{}
+			for k, f := range p.fonts {
+				keys[strconv.Itoa(f.i)] = k
+			}
+			var runs []string
+			font, size := "", ""
+			for _, op := range p.pages[1] {
+				if m := tf.FindStringSubmatch(op); m != nil {
+					font, size = keys[m[1]], m[2]
+				} else if m := tj.FindStringSubmatch(op); m != nil {
+					runs = append(runs, m[1]+" "+font+" "+size)
+				}
+			}
+			if !slices.Equal(runs, tt.want) {
+				t.Errorf("runs %q, want %q", runs, tt.want)
+			}
+			if p.fontFamily != "helvetica" || p.fontStyle != "" || p.fontSizePt != 12 {
+				t.Errorf("font %s %q %g after the HTML", p.fontFamily, p.fontStyle, p.fontSizePt)
+			}
+		})
+	}
+}
+
+func TestLinearGradient(t *testing.T) {
+	tests := []struct {
+		name     string
+		second   func(p *Fpdf)
+		shadings int
+		paint    string
+	}{
+		{"same gradient", func(p *Fpdf) { p.LinearGradient(20, 60, 80, 10, 255, 0, 0, 0, 0, 255, 0, 0, 1, 0) }, 1, "/Sh1 sh Q"},
+		{"other colors", func(p *Fpdf) { p.LinearGradient(20, 60, 80, 10, 255, 0, 0, 0, 255, 0, 0, 0, 1, 0) }, 2, "/Sh2 sh Q"},
+		{"other vector", func(p *Fpdf) { p.LinearGradient(20, 60, 80, 10, 255, 0, 0, 0, 0, 255, 0, 0, 0, 1) }, 2, "/Sh2 sh Q"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			p.LinearGradient(20, 20, 80, 10, 255, 0, 0, 0, 0, 255, 0, 0, 1, 0)
+			p.AddPage("", "", 0)
+			tt.second(p)
+			if s := pageStream(p, 2); !strings.HasSuffix(s, tt.paint) {
+				t.Errorf("second gradient not painted with %q:\n%s", tt.paint, s)
+			}
+			if got := strings.Count(output(t, p), "/ShadingType 2"); got != tt.shadings {
+				t.Errorf("%d shadings, want %d", got, tt.shadings)
+			}
+		})
+	}
+}
@@ -0,0 +1,438 @@
+package gofpdf
+
+import (
+	"bytes"
+	"math/bits"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// SetLinearized makes Close write the document linearized, for fast web
+// view: the first page and the objects it uses come first, after a
+// linearization dictionary and hint tables, so that a viewer can show it
+// before the rest of the file has been downloaded.
+func (p *Fpdf) SetLinearized(linearized bool) { p.linearized = linearized }
+
+// linObject is an object of the document being linearized.
+type linObject struct {
+	dict []byte // the object without its header, up to its stream data
+	data []byte // the stream data, nil for other objects
+	refs []int  // the objects referenced by dict
+}
+
+// linRefRe matches the indirect references of a dictionary.
+var linRefRe = regexp.MustCompile(`(\d+) 0 R\b`)
+
+// linearize rewrites the document written by endDoc, whose cross-reference
+// table is at offset xref, in the order of a linearized file: the
+// linearization dictionary and the cross-reference table of the first page,
+// the catalog, the hint stream, the objects of the first page (including the
+// resources, which all pages share), the objects of each following page and
+// the other objects, followed by the main cross-reference table. Objects are
+// renumbered to follow that order, as the hint tables require.
+func (p *Fpdf) linearize(xref int) {
+	buf := p.buffer.Bytes()
+	starts := make([]int, 0, p.n+1)
+	for n := 1; n <= p.n; n++ {
+		starts = append(starts, p.offsets[n])
+	}
+	starts = append(starts, xref)
+	sort.Ints(starts)
+	header := buf[:starts[0]]
+	objs := make(map[int]*linObject, p.n)
+	for n := 1; n <= p.n; n++ {
+		start := p.offsets[n]
+		end := starts[sort.SearchInts(starts, start)+1]
+		body := buf[start:end]
+		body = body[bytes.IndexByte(body, '\n')+1 : len(body)-len("endobj\n")]
+		o := &linObject{dict: body}
+		if bytes.HasSuffix(body, []byte("\nendstream\n")) {
+			i := bytes.Index(body, []byte("\nstream\n"))
+			o.dict = body[:i+1]
+			o.data = body[i+len("\nstream\n") : len(body)-len("\nendstream\n")]
+		}
+		p.linRewrite(o.dict, func(ref int) int {
+			o.refs = append(o.refs, ref)
+			return ref
+		}, nil)
+		objs[n] = o
+	}
+
+	// Sort the objects into the sections of the file.
+	catalog, info := p.n, p.n-1
+	pageObjs := map[int]bool{}
+	for i := 1; i <= p.page; i++ {
+		pageObjs[toInt(p.pageInfo[i]["n"])] = true
+	}
+	assigned := map[int]bool{1: true, catalog: true, info: true}
+	if p.encryptionObj > 0 {
+		assigned[p.encryptionObj] = true
+	}
+	var collect func(n int, page int) []int
+	collect = func(n int, page int) []int {
+		if assigned[n] || (pageObjs[n] && n != page) || objs[n] == nil {
+			return nil
+		}
+		assigned[n] = true
+		list := []int{n}
+		for _, ref := range objs[n].refs {
+			list = append(list, collect(ref, page)...)
+		}
+		return list
+	}
+	pages := make([][]int, p.page)
+	for i := range pages {
+		page := toInt(p.pageInfo[i+1]["n"])
+		pages[i] = collect(page, page)
+	}
+	var others []int
+	for n := 1; n <= p.n; n++ {
+		if !assigned[n] || n == 1 || n == info {
+			others = append(others, n)
+		}
+	}
+	docLevel := []int{catalog}
+	if p.encryptionObj > 0 {
+		docLevel = append(docLevel, p.encryptionObj)
+	}
+
+	// The main cross-reference table holds the following pages and the
+	// other objects, the first page one everything before them.
+	renum := map[int]int{}
+	num := 1
+	for _, list := range append(pages[1:], others) {
+		for _, n := range list {
+			renum[n] = num
+			num++
+		}
+	}
+	linNum := num
+	num++
+	for _, n := range docLevel {
+		renum[n] = num
+		num++
+	}
+	hintNum := num
+	num++
+	for _, n := range pages[0] {
+		renum[n] = num
+		num++
+	}
+	size := num
+
+	object := func(n int) []byte {
+		o := objs[n]
+		crypt := func(s []byte) []byte {
+			if p.protection == nil || n == p.encryptionObj {
+				return s
+			}
+			return p.protection.encrypt(renum[n], p.protection.encrypt(n, s))
+		}
+		var b bytes.Buffer
+		b.WriteString(strconv.Itoa(renum[n]) + " 0 obj\n")
+		b.Write(p.linRewrite(o.dict, func(ref int) int { return renum[ref] }, crypt))
+		if o.data != nil {
+			b.WriteString("stream\n")
+			b.Write(crypt(o.data))
+			b.WriteString("\nendstream\n")
+		}
+		b.WriteString("endobj\n")
+		return b.Bytes()
+	}
+	written := map[int][]byte{}
+	for n := range objs {
+		written[n] = object(n)
+	}
+
+	// Offsets are first computed without the hint stream, as the hint
+	// tables give them.
+	linDict := func(l, h0, h1, e, t int) string {
+		return sprintf("%d 0 obj\n<</Linearized 1 /L %-10d /H [%-10d %-10d] /O %d /E %-10d /N %d /T %-10d>>\nendobj\n",
+			linNum, l, h0, h1, renum[pages[0][0]], e, p.page, t)
+	}
+	id := regexp.MustCompile(`/ID \[[^\]]*\]`).Find(buf[xref:])
+	trailer := func(prev int) string {
+		s := sprintf("trailer\n<</Size %d /Prev %-10d /Root %d 0 R /Info %d 0 R", size, prev, renum[catalog], renum[info])
+		if p.encryptionObj > 0 {
+			s += sprintf(" /Encrypt %d 0 R", renum[p.encryptionObj])
+		}
+		if id != nil {
+			s += " " + string(id)
+		}
+		return s + ">>\nstartxref\n0\n%%EOF\n"
+	}
+	firstXrefLen := len(sprintf("xref\n%d %d\n", linNum, size-linNum)) + 20*(size-linNum)
+	offsets := map[int]int{}
+	pos := len(header) + len(linDict(0, 0, 0, 0, 0)) + firstXrefLen + len(trailer(0))
+	for _, n := range docLevel {
+		offsets[n] = pos
+		pos += len(written[n])
+	}
+	hintOffset := pos
+	for _, list := range append(pages, others) {
+		for _, n := range list {
+			offsets[n] = pos
+			pos += len(written[n])
+		}
+	}
+	hint := p.linHints(pages, offsets, written)
+	if p.protection != nil {
+		hint.data = p.protection.encrypt(hintNum, hint.data)
+	}
+	hintObj := sprintf("%d 0 obj\n<</Length %d /S %d>>\nstream\n", hintNum, len(hint.data), hint.shared) +
+		string(hint.data) + "\nendstream\nendobj\n"
+	for _, list := range append(pages, others) {
+		for _, n := range list {
+			offsets[n] += len(hintObj)
+		}
+	}
+	last := pages[0][len(pages[0])-1]
+	end := offsets[last] + len(written[last])
+	mainXref := pos + len(hintObj)
+	mainHead := sprintf("xref\n0 %d\n", linNum)
+	mainXrefLen := len(mainHead) + 20*linNum + len(sprintf("trailer\n<</Size %d>>\nstartxref\n", linNum))
+	firstXref := len(header) + len(linDict(0, 0, 0, 0, 0))
+	total := mainXref + mainXrefLen + len(strconv.Itoa(firstXref)) + len("\n%%EOF\n")
+
+	var out bytes.Buffer
+	out.Write(header)
+	out.WriteString(linDict(total, hintOffset, len(hintObj), end, mainXref+len(mainHead)-1))
+	out.WriteString(sprintf("xref\n%d %d\n", linNum, size-linNum))
+	out.WriteString(sprintf("%010d 00000 n \n", len(header)))
+	for _, n := range docLevel {
+		out.WriteString(sprintf("%010d 00000 n \n", offsets[n]))
+	}
+	out.WriteString(sprintf("%010d 00000 n \n", hintOffset))
+	for _, n := range pages[0] {
+		out.WriteString(sprintf("%010d 00000 n \n", offsets[n]))
+	}
+	out.WriteString(trailer(mainXref))
+	for _, n := range docLevel {
+		out.Write(written[n])
+	}
+	out.WriteString(hintObj)
+	for _, list := range append(pages, others) {
+		for _, n := range list {
+			out.Write(written[n])
+		}
+	}
+	out.WriteString(mainHead)
+	out.WriteString("0000000000 65535 f \n")
+	for _, list := range append(pages[1:], others) {
+		for _, n := range list {
+			out.WriteString(sprintf("%010d 00000 n \n", offsets[n]))
+		}
+	}
+	out.WriteString(sprintf("trailer\n<</Size %d>>\nstartxref\n%d\n%%%%EOF\n", linNum, firstXref))
+	p.buffer = out
+}
+
+// linHintStream holds the data of the primary hint stream and the offset of
+// its shared object hint table.
+type linHintStream struct {
+	data   []byte
+	shared int
+}
+
+// linHints builds the page offset and shared object hint tables. Every page
+// is one run of objects starting with its page object, and the first page
+// holds the objects shared by all pages, so no page refers to shared objects.
+func (p *Fpdf) linHints(pages [][]int, offsets map[int]int, written map[int][]byte) linHintStream {
+	nobjs := make([]int, len(pages))
+	lengths := make([]int, len(pages))
+	for i, list := range pages {
+		nobjs[i] = len(list)
+		for _, n := range list {
+			lengths[i] += len(written[n])
+		}
+	}
+	minObjs, objBits := linRange(nobjs)
+	minLen, lenBits := linRange(lengths)
+	var w linBitWriter
+	w.write(minObjs, 32)
+	w.write(offsets[pages[0][0]], 32)
+	w.write(objBits, 16)
+	w.write(minLen, 32)
+	w.write(lenBits, 16)
+	// Like other writers, give the whole page as its content stream, which
+	// is what viewers expect.
+	w.write(0, 32)
+	w.write(0, 16)
+	w.write(minLen, 32)
+	w.write(lenBits, 16)
+	w.write(0, 16)
+	w.write(0, 16)
+	w.write(0, 16)
+	w.write(1, 16)
+	for _, values := range [][]int{nobjs, lengths} {
+		min, nbits := linRange(values)
+		for _, v := range values {
+			w.write(v-min, nbits)
+		}
+		w.flush()
+	}
+	// No shared object references nor content offsets, then the content
+	// lengths.
+	for _, v := range lengths {
+		w.write(v-minLen, lenBits)
+	}
+	w.flush()
+	h := linHintStream{shared: len(w.buf)}
+
+	// The shared object table lists the objects of the first page, one
+	// group each.
+	groups := make([]int, len(pages[0]))
+	for i, n := range pages[0] {
+		groups[i] = len(written[n])
+	}
+	minGroup, groupBits := linRange(groups)
+	w.write(0, 32)
+	w.write(0, 32)
+	w.write(len(groups), 32)
+	w.write(len(groups), 32)
+	w.write(0, 16)
+	w.write(minGroup, 32)
+	w.write(groupBits, 16)
+	for _, v := range groups {
+		w.write(v-minGroup, groupBits)
+	}
+	w.flush()
+	for range groups {
+		w.write(0, 1)
+	}
+	w.flush()
+	h.data = w.buf
+	return h
+}
+
+// linRange returns the least of values and the number of bits needed for
+// their differences to it.
+func linRange(values []int) (min, nbits int) {
+	min, max := values[0], values[0]
+	for _, v := range values {
+		min, max = minInt(min, v), maxInt(max, v)
+	}
+	return min, bits.Len(uint(max - min))
+}
+
+// linBitWriter packs the items of hint tables, most significant bit first.
+type linBitWriter struct {
+	buf   []byte
+	cur   byte
+	nbits int
+}
+
+func (w *linBitWriter) write(v, nbits int) {
+	for i := nbits - 1; i >= 0; i-- {
+		w.cur = w.cur<<1 | byte(v>>i&1)
+		w.nbits++
+		if w.nbits == 8 {
+			w.buf = append(w.buf, w.cur)
+			w.cur, w.nbits = 0, 0
+		}
+	}
+}
+
+// flush pads the last byte with zero bits.
+func (w *linBitWriter) flush() {
+	if w.nbits > 0 {
+		w.write(0, 8-w.nbits)
+	}
+}
+
+// linRewrite returns dict with the object numbers of its references mapped
+// by ref and, when str is not nil, the content of its literal strings mapped
+// by str. Hex strings are kept as they are.
+func (p *Fpdf) linRewrite(dict []byte, ref func(int) int, str func([]byte) []byte) []byte {
+	var out bytes.Buffer
+	refs := func(s []byte) {
+		last := 0
+		for _, m := range linRefRe.FindAllSubmatchIndex(s, -1) {
+			if m[0] > 0 && isPDFRegular(s[m[0]-1]) {
+				continue
+			}
+			n, _ := strconv.Atoi(string(s[m[2]:m[3]]))
+			out.Write(s[last:m[0]])
+			out.WriteString(strconv.Itoa(ref(n)) + " 0 R")
+			last = m[1]
+		}
+		out.Write(s[last:])
+	}
+	start := 0
+	for i := 0; i < len(dict); i++ {
+		switch {
+		case dict[i] == '(':
+			refs(dict[start:i])
+			j, depth := i+1, 1
+			for ; j < len(dict) && depth > 0; j++ {
+				switch dict[j] {
+				case '\\':
+					j++
+				case '(':
+					depth++
+				case ')':
+					depth--
+				}
+			}
+			s := dict[i:j]
+			if str != nil {
+				s = []byte("(" + p.escape(string(str(pdfUnescape(s[1:len(s)-1])))) + ")")
+			}
+			out.Write(s)
+			i, start = j-1, j
+		case dict[i] == '<' && i+1 < len(dict) && dict[i+1] != '<':
+			refs(dict[start:i])
+			j := bytes.IndexByte(dict[i:], '>') + i + 1
+			out.Write(dict[i:j])
+			i, start = j-1, j
+		case dict[i] == '<' || dict[i] == '>':
+			i++
+		}
+	}
+	refs(dict[start:])
+	return out.Bytes()
+}
+
+// isPDFRegular reports whether c is neither white space nor a delimiter.
+func isPDFRegular(c byte) bool {
+	return !bytes.ContainsRune([]byte(" \t\r\n\f\x00()<>[]{}/%"), rune(c))
+}
+
+// pdfUnescape returns the bytes of the content of a literal string.
+func pdfUnescape(s []byte) []byte {
+	out := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			out = append(out, s[i])
+			continue
+		}
+		i++
+		switch c := s[i]; c {
+		case 'n':
+			out = append(out, '\n')
+		case 'r':
+			out = append(out, '\r')
+		case 't':
+			out = append(out, '\t')
+		case 'b':
+			out = append(out, '\b')
+		case 'f':
+			out = append(out, '\f')
+		case '\n':
+		default:
+			if c >= '0' && c <= '7' {
+				v := 0
+				for k := 0; k < 3 && i < len(s) && s[i] >= '0' && s[i] <= '7'; k++ {
+					v = v*8 + int(s[i]-'0')
+					i++
+				}
+				i--
+				out = append(out, byte(v))
+				continue
+			}
+			out = append(out, c)
+		}
+	}
+	return out
+}
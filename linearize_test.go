@@ -0,0 +1,147 @@
+package gofpdf
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSetLinearized(t *testing.T) {
+	tests := []struct {
+		name     string
+		compress bool
+		protect  bool
+	}{
+		{"plain", false, false},
+		{"compressed", true, false},
+		{"protected", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			p.SetCompression(tt.compress)
+			if tt.protect {
+				p.SetProtection(PermissionPrint, "user", "owner")
+			}
+			p.SetLinearized(true)
+			p.SetTitle("Report (draft)")
+			link := p.AddLink()
+			p.Bookmark("First", 0, 0)
+			p.Cell(40, 10, "first page", 0, 1, "L", false, "")
+			p.Link(10, 10, 40, 10, link)
+			p.AddPage("", "", 0)
+			p.Bookmark("Second", 0, 0)
+			p.Cell(40, 10, "second page", 0, 1, "L", false, "")
+			p.AddPage("", "", 0)
+			p.SetLink(link, 0, 3)
+			p.Cell(40, 10, "third page", 0, 1, "L", false, "")
+			doc := output(t, p)
+
+			// The linearization dictionary is the first object.
+			m := regexp.MustCompile(`^%PDF-1\.\d\n(\d+) 0 obj\n<</Linearized 1 /L (\d+) +/H \[(\d+) +(\d+) +\] /O (\d+) /E (\d+) +/N (\d+) /T (\d+) +>>`).FindStringSubmatch(doc)
+			if m == nil {
+				t.Fatalf("no linearization dictionary at the start of %q", doc[:min(len(doc), 200)])
+			}
+			v := make([]int, len(m))
+			for i := 1; i < len(m); i++ {
+				v[i], _ = strconv.Atoi(m[i])
+			}
+			lin, length, hintOff, hintLen, first, end, npages, mainEntry := v[1], v[2], v[3], v[4], v[5], v[6], v[7], v[8]
+			if length != len(doc) {
+				t.Errorf("/L %d, want the file size %d", length, len(doc))
+			}
+			if npages != 3 {
+				t.Errorf("/N %d, want 3", npages)
+			}
+
+			// Every cross-reference entry points to its object.
+			offsets := map[int]int{}
+			for _, x := range regexp.MustCompile(`(?m)^xref\n(\d+) (\d+)\n`).FindAllStringSubmatchIndex(doc, -1) {
+				start, _ := strconv.Atoi(doc[x[2]:x[3]])
+				count, _ := strconv.Atoi(doc[x[4]:x[5]])
+				for i := 0; i < count; i++ {
+					entry := doc[x[1]+20*i : x[1]+20*i+20]
+					off, _ := strconv.Atoi(entry[:10])
+					if start+i == 0 {
+						continue
+					}
+					offsets[start+i] = off
+					if header := strconv.Itoa(start+i) + " 0 obj\n"; !strings.HasPrefix(doc[off:], header) {
+						t.Errorf("entry of object %d points to %q", start+i, doc[off:off+20])
+					}
+				}
+			}
+			if offsets[lin] == 0 || offsets[first] == 0 {
+				t.Fatalf("objects %d and %d are not in the cross-reference tables", lin, first)
+			}
+
+			// The file ends pointing to the first page cross-reference
+			// table, whose trailer points to the main one.
+			startxref := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindStringSubmatch(doc)
+			firstXref, _ := strconv.Atoi(startxref[1])
+			if !strings.HasPrefix(doc[firstXref:], "xref\n"+strconv.Itoa(lin)+" ") {
+				t.Errorf("startxref %d does not point to the first page cross-reference table", firstXref)
+			}
+			prev := findRef(t, doc, `/Prev (\d+)`)
+			if !strings.HasPrefix(doc[prev:], "xref\n0 ") {
+				t.Errorf("/Prev %d does not point to the main cross-reference table", prev)
+			}
+			if doc[mainEntry] != '\n' || !strings.HasPrefix(doc[mainEntry+1:], "0000000000 65535 f") {
+				t.Errorf("/T %d does not precede the first main cross-reference entry", mainEntry)
+			}
+
+			// The hint stream follows the document-level objects and the
+			// first page ends at /E, before the other pages.
+			hint := doc[hintOff : hintOff+hintLen]
+			if !regexp.MustCompile(`^\d+ 0 obj\n<</Length \d+ /S \d+>>\nstream\n`).MatchString(hint) || !strings.HasSuffix(hint, "endstream\nendobj\n") {
+				t.Errorf("/H does not span the hint stream: %q", hint)
+			}
+			if hintOff+hintLen != offsets[first] {
+				t.Errorf("the first page object is at %d, want %d after the hint stream", offsets[first], hintOff+hintLen)
+			}
+			if !strings.Contains(pdfObject(t, doc, first), "/Type /Page\n") {
+				t.Errorf("/O %d is not a page object", first)
+			}
+			if doc[end-7:end] != "endobj\n" {
+				t.Errorf("/E %d is not at the end of an object", end)
+			}
+			kids := regexp.MustCompile(`/Kids \[(\d+) 0 R (\d+) 0 R (\d+) 0 R \]`).FindStringSubmatch(doc)
+			if kids == nil {
+				t.Fatal("no page tree")
+			}
+			for _, kid := range kids[2:] {
+				n, _ := strconv.Atoi(kid)
+				if offsets[n] < end {
+					t.Errorf("page object %d is in the first page section", n)
+				}
+			}
+
+			// References resolve after renumbering.
+			for _, ref := range regexp.MustCompile(`[^\w](\d+) 0 R\b`).FindAllStringSubmatch(doc, -1) {
+				n, _ := strconv.Atoi(ref[1])
+				if _, ok := offsets[n]; !ok {
+					t.Errorf("reference to missing object %d", n)
+				}
+			}
+
+			if tt.protect {
+				content := findRef(t, pdfObject(t, doc, first), `/Contents (\d+) 0 R`)
+				obj := pdfObject(t, doc, content)
+				data := obj[strings.Index(obj, "stream\n")+len("stream\n") : strings.LastIndex(obj, "\nendstream")]
+				if plain := p.protection.encrypt(content, []byte(data)); !bytes.Contains(plain, []byte("(first page) Tj")) {
+					t.Errorf("decrypted content %q", plain)
+				}
+				info := findRef(t, doc, `/Info (\d+) 0 R`)
+				title := regexp.MustCompile(`/Title \((.*)\)\n`).FindStringSubmatch(pdfObject(t, doc, info))
+				if title == nil {
+					t.Fatal("no /Title")
+				}
+				if plain := p.protection.encrypt(info, pdfUnescape([]byte(title[1]))); string(plain) != "Report (draft)" {
+					t.Errorf("decrypted /Title %q", plain)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package gofpdf
+
+import "strings"
+
+// Table lays out rows of text in columns. Cell text is wrapped to the column
+// width, every cell gets a border and the header row is repeated after
+// automatic page breaks.
+type Table struct {
+	p          *Fpdf
+	widths     []float64
+	aligns     []string
+	autoMax    map[int]float64
+	gradients  map[[2]int][2][3]int
+	renderers  map[int]func(pdf *Fpdf, x, y, w, h float64, value string)
+	fracWidth  map[int]float64
+	header     []string
+	rows       [][]string
+	lineHeight float64
+	style      string
+}
+
+// NewTable returns a table builder whose columns have the given widths in
+// user units. Columns with a zero width share the space left over by the
+// other columns within the page margins.
+func (p *Fpdf) NewTable(widths ...float64) *Table {
+	return &Table{
+		p:         p,
+		widths:    widths,
+		autoMax:   map[int]float64{},
+		gradients: map[[2]int][2][3]int{},
+		renderers: map[int]func(pdf *Fpdf, x, y, w, h float64, value string){},
+	}
+}
+
+// SetHeader sets the header row, printed in bold above the first row and
+// again at the top of every page the table continues on.
+func (t *Table) SetHeader(cells ...string) { t.header = cells }
+
+// SetAligns sets the text alignment ("L", "C", "R" or "decimal") of each
+// column. In a "decimal" column the body values are right-aligned so that
+// their decimal points line up; values without a point end where the point
+// would be.
+func (t *Table) SetAligns(aligns ...string) { t.aligns = aligns }
+
+// SetLineHeight sets the height of a line of text in a cell. By default it is
+// 1.5 times the font size.
+func (t *Table) SetLineHeight(h float64) { t.lineHeight = h }
+
+// SetAutoWidth makes column col as wide as its widest cell text, capped at
+// max when max is positive.
+func (t *Table) SetAutoWidth(col int, max float64) { t.autoMax[col] = max }
+
+// SetCellGradient paints the background of a cell with a vertical gradient
+// going from color from at the top to color to at the bottom, both RGB
+// triples (0-255). row is the index of a row added with AddRow, or -1 for the
+// header.
+func (t *Table) SetCellGradient(row, col int, from, to [3]int) {
+	t.gradients[[2]int{row, col}] = [2][3]int{from, to}
+}
+
+// SetCellRenderer makes render draw the body cells of column col instead of
+// the table printing their text. render receives the cell rectangle and value;
+// the table still sizes the row from the value and draws the cell border.
+func (t *Table) SetCellRenderer(col int, render func(pdf *Fpdf, x, y, w, h float64, value string)) {
+	t.renderers[col] = render
+}
+
+// AddRow appends a row of cells to the table.
+func (t *Table) AddRow(cells ...string) { t.rows = append(t.rows, cells) }
+
+// Draw prints the table at the current position, starting at the left margin.
+func (t *Table) Draw() {
+	p := t.p
+	if p.currentFont == nil {
+		p.panicError("no font has been set")
+	}
+	t.style = p.fontStyle
+	if p.underline {
+		t.style += "U"
+	}
+	if p.strikeout {
+		t.style += "S"
+	}
+	widths := t.columnWidths()
+	t.fracWidth = map[int]float64{}
+	for col, align := range t.aligns {
+		if align != "decimal" {
+			continue
+		}
+		for _, row := range t.rows {
+			if col < len(row) {
+				for _, line := range strings.Split(row[col], "\n") {
+					t.fracWidth[col] = maxFloat(t.fracWidth[col], t.decimalWidth(line))
+				}
+			}
+		}
+	}
+	if t.header != nil {
+		t.drawRow(t.header, -1, widths, true)
+	}
+	for i, row := range t.rows {
+		h := t.rowHeight(row, widths, false)
+		if p.y+h > p.pageBreakTrigger && !p.inHeader && !p.inFooter && p.AcceptPageBreak() {
+			if i > 0 {
+				p.putContinuation()
+			}
+			p.AddPage(p.curOrientation, "", p.curRotation)
+			if t.header != nil {
+				t.drawRow(t.header, -1, widths, true)
+			}
+		}
+		t.drawRow(row, i, widths, false)
+	}
+}
+
+// columnWidths resolves fixed, automatic and shared column widths.
+func (t *Table) columnWidths() []float64 {
+	p := t.p
+	n := len(t.widths)
+	for _, row := range append([][]string{t.header}, t.rows...) {
+		n = maxInt(n, len(row))
+	}
+	widths := make([]float64, n)
+	copy(widths, t.widths)
+	for col, max := range t.autoMax {
+		if col < 0 || col >= n {
+			continue
+		}
+		w := 0.0
+		if col < len(t.header) {
+			t.setHeaderStyle(true)
+			w = t.textWidth(t.header[col])
+			t.setHeaderStyle(false)
+		}
+		for _, row := range t.rows {
+			if col < len(row) {
+				w = maxFloat(w, t.textWidth(row[col]))
+			}
+		}
+		w += 2 * p.cMargin
+		if max > 0 && w > max {
+			w = max
+		}
+		widths[col] = w
+	}
+	used := 0.0
+	free := 0
+	for _, w := range widths {
+		if w > 0 {
+			used += w
+		} else {
+			free++
+		}
+	}
+	if free > 0 {
+		share := maxFloat(p.w-p.lMargin-p.rMargin-used, 0) / float64(free)
+		for i, w := range widths {
+			if w <= 0 {
+				widths[i] = share
+			}
+		}
+	}
+	return widths
+}
+
+// textWidth returns the width of the longest line of txt.
+func (t *Table) textWidth(txt string) float64 {
+	w := 0.0
+	for _, line := range strings.Split(txt, "\n") {
+		w = maxFloat(w, t.p.GetStringWidth(line))
+	}
+	return w
+}
+
+// decimalWidth returns the width of the decimal point and the digits after it
+// in txt, or zero if txt has no decimal point.
+func (t *Table) decimalWidth(txt string) float64 {
+	i := strings.LastIndex(txt, ".")
+	if i < 0 {
+		return 0
+	}
+	return t.p.GetStringWidth(txt[i:])
+}
+
+func (t *Table) rowLineHeight() float64 {
+	if t.lineHeight > 0 {
+		return t.lineHeight
+	}
+	return t.p.fontSize * 1.5
+}
+
+// rowHeight returns the height of a row once its cells are wrapped.
+func (t *Table) rowHeight(row []string, widths []float64, header bool) float64 {
+	t.setHeaderStyle(header)
+	lines := 1
+	for i, txt := range row {
+		lines = maxInt(lines, len(t.p.SplitLines(txt, widths[i])))
+	}
+	t.setHeaderStyle(false)
+	return float64(lines) * t.rowLineHeight()
+}
+
+// drawRow prints row number index (-1 for the header) at the current position
+// and moves below it.
+func (t *Table) drawRow(row []string, index int, widths []float64, header bool) {
+	p := t.p
+	h := t.rowHeight(row, widths, header)
+	lh := t.rowLineHeight()
+	x, y := p.lMargin, p.y
+	auto := p.autoPageBreak
+	p.autoPageBreak = false
+	t.setHeaderStyle(header)
+	for i, w := range widths {
+		if g, ok := t.gradients[[2]int{index, i}]; ok {
+			p.LinearGradient(x, y, w, h, g[0][0], g[0][1], g[0][2], g[1][0], g[1][1], g[1][2], 0, 0, 0, 1)
+		}
+		p.Rect(x, y, w, h, "D")
+		if render, ok := t.renderers[i]; ok && !header && i < len(row) {
+			render(p, x, y, w, h, row[i])
+		} else if i < len(row) {
+			align := "L"
+			if header {
+				align = "C"
+			} else if i < len(t.aligns) {
+				align = t.aligns[i]
+			}
+			p.SetXY(x, y)
+			for _, line := range p.SplitLines(row[i], w) {
+				if align == "decimal" {
+					p.Cell(w-t.fracWidth[i]+t.decimalWidth(line), lh, line, 0, 2, "R", false, "")
+					continue
+				}
+				p.Cell(w, lh, line, 0, 2, align, false, "")
+			}
+		}
+		x += w
+	}
+	t.setHeaderStyle(false)
+	p.autoPageBreak = auto
+	p.SetXY(p.lMargin, y+h)
+}
+
+// setHeaderStyle switches between the bold header style and the style the
+// table was drawn with.
+func (t *Table) setHeaderStyle(header bool) {
+	style := t.style
+	if header && !strings.Contains(style, "B") {
+		style = "B" + style
+	}
+	t.p.SetFont("", style, 0)
+}
@@ -0,0 +1,129 @@
+package gofpdf
+
+import (
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTableAutoWidth(t *testing.T) {
+	long := "a much longer cell text"
+	tests := []struct {
+		name string
+		max  float64
+		want func(p *Fpdf) float64
+	}{
+		{"fit", 0, func(p *Fpdf) float64 { return p.GetStringWidth(long) + 2*p.cMargin }},
+		{"capped", 20, func(p *Fpdf) float64 { return 20 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPdf()
+			tb := p.NewTable(0, 40, 0)
+			tb.SetAutoWidth(0, tt.max)
+			tb.AddRow("short", "fixed", "shared")
+			tb.AddRow(long, "fixed", "shared")
+			widths := tb.columnWidths()
+
+			if want := tt.want(p); math.Abs(widths[0]-want) > 1e-9 {
+				t.Errorf("auto column is %.2f wide, want %.2f", widths[0], want)
+			}
+			if widths[1] != 40 {
+				t.Errorf("fixed column is %.2f wide, want 40", widths[1])
+			}
+			if rest := p.w - p.lMargin - p.rMargin - widths[0] - 40; math.Abs(widths[2]-rest) > 1e-9 {
+				t.Errorf("shared column is %.2f wide, want the remaining %.2f", widths[2], rest)
+			}
+		})
+	}
+}
+
+func TestTableCellGradient(t *testing.T) {
+	p := newTestPdf()
+	tb := p.NewTable(40, 40)
+	tb.AddRow("plain", "shaded")
+	tb.SetCellGradient(0, 1, [3]int{255, 255, 255}, [3]int{200, 200, 255})
+	y := p.GetY()
+	tb.Draw()
+	stream := pageStream(p, 1)
+
+	x := p.lMargin + 40
+	h := tb.rowHeight([]string{"plain", "shaded"}, []float64{40, 40}, false)
+	clip := sprintf("q %.2F %.2F %.2F %.2F re W n", x*p.k, (p.h-y)*p.k, 40*p.k, -h*p.k)
+	if !strings.Contains(stream, clip) {
+		t.Errorf("gradient is not clipped to the cell, want %q:\n%s", clip, stream)
+	}
+	if got := strings.Count(stream, "/Sh1 sh Q"); got != 1 {
+		t.Errorf("%d shadings painted, want 1", got)
+	}
+	if strings.Index(stream, "/Sh1 sh") > strings.Index(stream, "(shaded) Tj") {
+		t.Error("gradient is painted over the cell text")
+	}
+}
+
+func TestTableCellRenderer(t *testing.T) {
+	p := newTestPdf()
+	tb := p.NewTable(40, 50)
+	tb.SetHeader("Name", "Share")
+	type call struct {
+		x, y, w, h float64
+		value      string
+	}
+	var calls []call
+	tb.SetCellRenderer(1, func(pdf *Fpdf, x, y, w, h float64, value string) {
+		calls = append(calls, call{x, y, w, h, value})
+		v, _ := strconv.ParseFloat(value, 64)
+		pdf.Rect(x, y, w*v, h, "F")
+	})
+	tb.AddRow("a", "0.5")
+	tb.AddRow("b", "1")
+	y := p.GetY()
+	tb.Draw()
+
+	lh := tb.rowLineHeight()
+	want := []call{
+		{p.lMargin + 40, y + lh, 50, lh, "0.5"},
+		{p.lMargin + 40, y + 2*lh, 50, lh, "1"},
+	}
+	if !slices.Equal(calls, want) {
+		t.Errorf("renderer calls %v, want %v", calls, want)
+	}
+	stream := pageStream(p, 1)
+	if bar := sprintf("%.2F %.2F %.2F %.2F re f", (p.lMargin+40)*p.k, (p.h-y-lh)*p.k, 25*p.k, -lh*p.k); !strings.Contains(stream, bar) {
+		t.Errorf("bar %q not drawn:\n%s", bar, stream)
+	}
+	if strings.Contains(stream, "(0.5) Tj") || !strings.Contains(stream, "(Share) Tj") {
+		t.Error("rendered cells print their value or the header is not printed")
+	}
+}
+
+func TestTableDecimalAlign(t *testing.T) {
+	p := newTestPdf()
+	tb := p.NewTable(30, 40)
+	tb.SetAligns("L", "decimal")
+	values := []string{"1.5", "123.45", "0.9", "42"}
+	for _, v := range values {
+		tb.AddRow("item", v)
+	}
+	tb.Draw()
+
+	stream := pageStream(p, 1)
+	var points []float64
+	for _, v := range values {
+		m := regexp.MustCompile(`BT ([0-9.]+) [0-9.]+ Td \(` + regexp.QuoteMeta(v) + `\) Tj`).FindStringSubmatch(stream)
+		if m == nil {
+			t.Fatalf("%s not printed:\n%s", v, stream)
+		}
+		x, _ := strconv.ParseFloat(m[1], 64)
+		intPart, _, _ := strings.Cut(v, ".")
+		points = append(points, x/p.k+p.GetStringWidth(intPart))
+	}
+	for i, x := range points {
+		if math.Abs(x-points[0]) > 0.01 {
+			t.Errorf("decimal point of %s at %.2f, want %.2f", values[i], x, points[0])
+		}
+	}
+}
@@ -0,0 +1,470 @@
+package gofpdf
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// ttfFont holds what is needed to embed a TrueType font for UTF-8 text. Text
+// is written with the Identity-H encoding, so the character codes are glyph
+// ids; the glyphs used are recorded so that only their outlines are embedded.
+type ttfFont struct {
+	data        []byte
+	tables      map[string][]byte
+	unitsPerEm  int
+	locLong     bool
+	numGlyphs   int
+	widths      []int // advance widths in 1/1000 of the font size
+	cmap        map[rune]uint16
+	ascent      int
+	descent     int
+	capHeight   int
+	bbox        [4]int
+	italicAngle float64
+	fixedPitch  bool
+	bold        bool
+	postName    string
+	used        map[uint16]rune
+}
+
+// parseTTF reads the tables of a TrueType font file.
+func parseTTF(data []byte) (*ttfFont, error) {
+	if len(data) < 12 {
+		return nil, errors.New("font file is too short")
+	}
+	switch binary.BigEndian.Uint32(data) {
+	case 0x00010000, 0x74727565: // 1.0 and "true"
+	case 0x4F54544F: // "OTTO"
+		return nil, errors.New("fonts with PostScript outlines are not supported")
+	default:
+		return nil, errors.New("not a TrueType font file")
+	}
+	t := &ttfFont{data: data, tables: map[string][]byte{}, used: map[uint16]rune{}}
+	numTables := int(binary.BigEndian.Uint16(data[4:]))
+	if len(data) < 12+16*numTables {
+		return nil, errors.New("truncated font table directory")
+	}
+	for i := 0; i < numTables; i++ {
+		entry := data[12+16*i:]
+		tag := string(entry[:4])
+		offset := int(binary.BigEndian.Uint32(entry[8:]))
+		length := int(binary.BigEndian.Uint32(entry[12:]))
+		if offset < 0 || length < 0 || offset+length > len(data) {
+			return nil, errors.New("font table " + tag + " is out of bounds")
+		}
+		t.tables[tag] = data[offset : offset+length]
+	}
+	for _, tag := range []string{"head", "hhea", "maxp", "hmtx", "cmap", "loca", "glyf"} {
+		if t.tables[tag] == nil {
+			return nil, errors.New("font has no " + tag + " table")
+		}
+	}
+
+	head := t.tables["head"]
+	if len(head) < 54 {
+		return nil, errors.New("incorrect head table")
+	}
+	t.unitsPerEm = int(binary.BigEndian.Uint16(head[18:]))
+	if t.unitsPerEm == 0 {
+		return nil, errors.New("incorrect units per em")
+	}
+	for i := range t.bbox {
+		t.bbox[i] = t.scale(int(int16(binary.BigEndian.Uint16(head[36+2*i:]))))
+	}
+	t.bold = binary.BigEndian.Uint16(head[44:])&1 != 0
+	t.locLong = binary.BigEndian.Uint16(head[50:]) != 0
+
+	hhea := t.tables["hhea"]
+	maxp := t.tables["maxp"]
+	if len(hhea) < 36 || len(maxp) < 6 {
+		return nil, errors.New("incorrect hhea or maxp table")
+	}
+	t.ascent = t.scale(int(int16(binary.BigEndian.Uint16(hhea[4:]))))
+	t.descent = t.scale(int(int16(binary.BigEndian.Uint16(hhea[6:]))))
+	numHMetrics := int(binary.BigEndian.Uint16(hhea[34:]))
+	t.numGlyphs = int(binary.BigEndian.Uint16(maxp[4:]))
+
+	hmtx := t.tables["hmtx"]
+	if numHMetrics == 0 || len(hmtx) < 4*numHMetrics {
+		return nil, errors.New("incorrect hmtx table")
+	}
+	t.widths = make([]int, t.numGlyphs)
+	for gid := range t.widths {
+		m := minInt(gid, numHMetrics-1)
+		t.widths[gid] = t.scale(int(binary.BigEndian.Uint16(hmtx[4*m:])))
+	}
+
+	t.capHeight = t.ascent
+	if os2 := t.tables["OS/2"]; len(os2) >= 90 {
+		if binary.BigEndian.Uint16(os2) >= 2 {
+			t.capHeight = t.scale(int(int16(binary.BigEndian.Uint16(os2[88:]))))
+		}
+		t.bold = t.bold || binary.BigEndian.Uint16(os2[4:]) >= 600
+	}
+	if post := t.tables["post"]; len(post) >= 16 {
+		t.italicAngle = float64(int32(binary.BigEndian.Uint32(post[4:]))) / 65536
+		t.fixedPitch = binary.BigEndian.Uint32(post[12:]) != 0
+	}
+	t.postName = t.nameRecord(6)
+	if t.postName == "" {
+		t.postName = "TrueTypeFont"
+	}
+
+	var err error
+	if t.cmap, err = parseCmap(t.tables["cmap"]); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// scale converts a value in font design units to 1/1000 of the font size.
+func (t *ttfFont) scale(v int) int {
+	return int(float64(v)*1000/float64(t.unitsPerEm) + 0.5*sign(v))
+}
+
+func sign(v int) float64 {
+	if v < 0 {
+		return -1
+	}
+	return 1
+}
+
+// underline returns the underline position and thickness in 1/1000 of the
+// font size.
+func (t *ttfFont) underline() (float64, float64) {
+	post := t.tables["post"]
+	if len(post) < 12 {
+		return -100, 50
+	}
+	return float64(t.scale(int(int16(binary.BigEndian.Uint16(post[8:]))))),
+		float64(t.scale(int(int16(binary.BigEndian.Uint16(post[10:])))))
+}
+
+// nameRecord returns a string of the name table, stripped of the characters
+// a PDF name cannot hold.
+func (t *ttfFont) nameRecord(id uint16) string {
+	name := t.tables["name"]
+	if len(name) < 6 {
+		return ""
+	}
+	count := int(binary.BigEndian.Uint16(name[2:]))
+	strOffset := int(binary.BigEndian.Uint16(name[4:]))
+	for i := 0; i < count && 6+12*i+12 <= len(name); i++ {
+		rec := name[6+12*i:]
+		platform := binary.BigEndian.Uint16(rec)
+		if binary.BigEndian.Uint16(rec[6:]) != id {
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(rec[8:]))
+		offset := strOffset + int(binary.BigEndian.Uint16(rec[10:]))
+		if offset+length > len(name) {
+			continue
+		}
+		raw := name[offset : offset+length]
+		var s []rune
+		if platform == 0 || platform == 3 {
+			u := make([]uint16, len(raw)/2)
+			for j := range u {
+				u[j] = binary.BigEndian.Uint16(raw[2*j:])
+			}
+			s = utf16.Decode(u)
+		} else {
+			for _, b := range raw {
+				s = append(s, rune(b))
+			}
+		}
+		clean := make([]rune, 0, len(s))
+		for _, r := range s {
+			if r > 32 && r < 127 && !containsRune("()<>[]{}/%#", r) {
+				clean = append(clean, r)
+			}
+		}
+		if len(clean) > 0 {
+			return string(clean)
+		}
+	}
+	return ""
+}
+
+func containsRune(s string, r rune) bool {
+	for _, c := range s {
+		if c == r {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCmap maps characters to glyph ids using the Unicode subtable of the
+// font, in format 4 or 12.
+func parseCmap(cmap []byte) (map[rune]uint16, error) {
+	if len(cmap) < 4 {
+		return nil, errors.New("incorrect cmap table")
+	}
+	best, bestRank := -1, 0
+	n := int(binary.BigEndian.Uint16(cmap[2:]))
+	for i := 0; i < n && 4+8*i+8 <= len(cmap); i++ {
+		rec := cmap[4+8*i:]
+		platform, encoding := binary.BigEndian.Uint16(rec), binary.BigEndian.Uint16(rec[2:])
+		offset := int(binary.BigEndian.Uint32(rec[4:]))
+		if offset+4 > len(cmap) {
+			continue
+		}
+		format := binary.BigEndian.Uint16(cmap[offset:])
+		rank := 0
+		switch {
+		case format == 12 && (platform == 3 && encoding == 10 || platform == 0):
+			rank = 3
+		case format == 4 && platform == 3 && encoding == 1:
+			rank = 2
+		case format == 4 && platform == 0:
+			rank = 1
+		}
+		if rank > bestRank {
+			best, bestRank = offset, rank
+		}
+	}
+	if best < 0 {
+		return nil, errors.New("font has no Unicode character map")
+	}
+	sub := cmap[best:]
+	m := map[rune]uint16{}
+	if binary.BigEndian.Uint16(sub) == 12 {
+		if len(sub) < 16 {
+			return nil, errors.New("incorrect cmap subtable")
+		}
+		groups := int(binary.BigEndian.Uint32(sub[12:]))
+		for i := 0; i < groups && 16+12*i+12 <= len(sub); i++ {
+			g := sub[16+12*i:]
+			start, end := binary.BigEndian.Uint32(g), binary.BigEndian.Uint32(g[4:])
+			gid := binary.BigEndian.Uint32(g[8:])
+			for c := start; c <= end && c <= 0x10FFFF; c++ {
+				m[rune(c)] = uint16(gid + c - start)
+			}
+		}
+		return m, nil
+	}
+	if len(sub) < 14 {
+		return nil, errors.New("incorrect cmap subtable")
+	}
+	segs := int(binary.BigEndian.Uint16(sub[6:])) / 2
+	if len(sub) < 16+8*segs {
+		return nil, errors.New("incorrect cmap subtable")
+	}
+	ends := sub[14:]
+	starts := sub[16+2*segs:]
+	deltas := sub[16+4*segs:]
+	rangeOffsets := sub[16+6*segs:]
+	for i := 0; i < segs; i++ {
+		end := int(binary.BigEndian.Uint16(ends[2*i:]))
+		start := int(binary.BigEndian.Uint16(starts[2*i:]))
+		delta := int(binary.BigEndian.Uint16(deltas[2*i:]))
+		ro := int(binary.BigEndian.Uint16(rangeOffsets[2*i:]))
+		for c := start; c <= end && c != 0xFFFF; c++ {
+			gid := 0
+			if ro == 0 {
+				gid = (c + delta) & 0xFFFF
+			} else {
+				pos := 16 + 6*segs + 2*i + ro + 2*(c-start)
+				if pos+2 > len(sub) {
+					continue
+				}
+				if gid = int(binary.BigEndian.Uint16(sub[pos:])); gid != 0 {
+					gid = (gid + delta) & 0xFFFF
+				}
+			}
+			if gid != 0 {
+				m[rune(c)] = uint16(gid)
+			}
+		}
+	}
+	return m, nil
+}
+
+// glyph returns the glyph id of r, 0 (the missing glyph) if the font does not
+// cover it.
+func (t *ttfFont) glyph(r rune) uint16 {
+	return t.cmap[r]
+}
+
+// width returns the advance width of r in 1/1000 of the font size.
+func (t *ttfFont) width(r rune) int {
+	gid := int(t.glyph(r))
+	if gid >= len(t.widths) {
+		return 0
+	}
+	return t.widths[gid]
+}
+
+// encode returns txt as a sequence of two-byte glyph ids and records the
+// glyphs as used.
+func (t *ttfFont) encode(txt string) []byte {
+	out := make([]byte, 0, 2*len(txt))
+	for _, r := range txt {
+		gid := t.glyph(r)
+		if _, ok := t.used[gid]; !ok && gid != 0 {
+			t.used[gid] = r
+		}
+		out = append(out, byte(gid>>8), byte(gid))
+	}
+	return out
+}
+
+// glyphRange returns the offsets of glyph gid in the glyf table.
+func (t *ttfFont) glyphRange(gid int) (int, int) {
+	loca := t.tables["loca"]
+	if t.locLong {
+		if 4*gid+8 > len(loca) {
+			return 0, 0
+		}
+		return int(binary.BigEndian.Uint32(loca[4*gid:])), int(binary.BigEndian.Uint32(loca[4*gid+4:]))
+	}
+	if 2*gid+4 > len(loca) {
+		return 0, 0
+	}
+	return 2 * int(binary.BigEndian.Uint16(loca[2*gid:])), 2 * int(binary.BigEndian.Uint16(loca[2*gid+2:]))
+}
+
+// subset returns a font file holding the outlines of the used glyphs and of
+// the glyphs they are composed of. Glyph ids are kept, the other glyphs
+// being left empty.
+func (t *ttfFont) subset() []byte {
+	glyf := t.tables["glyf"]
+	keep := map[int]bool{0: true}
+	queue := []int{0}
+	for gid := range t.used {
+		queue = append(queue, int(gid))
+	}
+	for len(queue) > 0 {
+		gid := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		keep[gid] = true
+		start, end := t.glyphRange(gid)
+		if end-start < 10 || end > len(glyf) || int16(binary.BigEndian.Uint16(glyf[start:])) >= 0 {
+			continue
+		}
+		// Composite glyph: queue its components.
+		pos := start + 10
+		for pos+4 <= end {
+			flags := binary.BigEndian.Uint16(glyf[pos:])
+			component := int(binary.BigEndian.Uint16(glyf[pos+2:]))
+			if !keep[component] {
+				queue = append(queue, component)
+			}
+			pos += 4
+			if flags&0x0001 != 0 {
+				pos += 4
+			} else {
+				pos += 2
+			}
+			switch {
+			case flags&0x0008 != 0:
+				pos += 2
+			case flags&0x0040 != 0:
+				pos += 4
+			case flags&0x0080 != 0:
+				pos += 8
+			}
+			if flags&0x0020 == 0 {
+				break
+			}
+		}
+	}
+
+	var newGlyf []byte
+	newLoca := make([]byte, 4*(t.numGlyphs+1))
+	for gid := 0; gid < t.numGlyphs; gid++ {
+		binary.BigEndian.PutUint32(newLoca[4*gid:], uint32(len(newGlyf)))
+		if !keep[gid] {
+			continue
+		}
+		start, end := t.glyphRange(gid)
+		if end > start && end <= len(glyf) {
+			newGlyf = append(newGlyf, glyf[start:end]...)
+			for len(newGlyf)%4 != 0 {
+				newGlyf = append(newGlyf, 0)
+			}
+		}
+	}
+	binary.BigEndian.PutUint32(newLoca[4*t.numGlyphs:], uint32(len(newGlyf)))
+
+	head := append([]byte(nil), t.tables["head"]...)
+	binary.BigEndian.PutUint32(head[8:], 0)
+	binary.BigEndian.PutUint16(head[50:], 1)
+	tables := map[string][]byte{"glyf": newGlyf, "loca": newLoca, "head": head}
+	for _, tag := range []string{"hhea", "hmtx", "maxp", "cvt ", "fpgm", "prep"} {
+		if data, ok := t.tables[tag]; ok {
+			tables[tag] = data
+		}
+	}
+	font := buildTTF(tables)
+	binary.BigEndian.PutUint32(font[tableOffset(font, "head")+8:], 0xB1B0AFBA-ttfChecksum(font))
+	return font
+}
+
+// buildTTF assembles a font file from its tables.
+func buildTTF(tables map[string][]byte) []byte {
+	tags := make([]string, 0, len(tables))
+	for tag := range tables {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	n := len(tags)
+	entrySelector := 0
+	for 1<<(entrySelector+1) <= n {
+		entrySelector++
+	}
+	searchRange := 16 << entrySelector
+	font := make([]byte, 12+16*n)
+	binary.BigEndian.PutUint32(font, 0x00010000)
+	binary.BigEndian.PutUint16(font[4:], uint16(n))
+	binary.BigEndian.PutUint16(font[6:], uint16(searchRange))
+	binary.BigEndian.PutUint16(font[8:], uint16(entrySelector))
+	binary.BigEndian.PutUint16(font[10:], uint16(16*n-searchRange))
+	for i, tag := range tags {
+		data := tables[tag]
+		entry := font[12+16*i:]
+		copy(entry, tag)
+		binary.BigEndian.PutUint32(entry[4:], ttfChecksum(data))
+		binary.BigEndian.PutUint32(entry[8:], uint32(len(font)))
+		binary.BigEndian.PutUint32(entry[12:], uint32(len(data)))
+		font = append(font, data...)
+		for len(font)%4 != 0 {
+			font = append(font, 0)
+		}
+	}
+	return font
+}
+
+// tableOffset returns the offset of a table in a font built by buildTTF.
+func tableOffset(font []byte, tag string) int {
+	n := int(binary.BigEndian.Uint16(font[4:]))
+	for i := 0; i < n; i++ {
+		if string(font[12+16*i:16+16*i]) == tag {
+			return int(binary.BigEndian.Uint32(font[20+16*i:]))
+		}
+	}
+	return 0
+}
+
+// utf16Hex returns r encoded in UTF-16BE as hexadecimal digits.
+func utf16Hex(r rune) string {
+	var b strings.Builder
+	for _, u := range utf16.Encode([]rune{r}) {
+		b.WriteString(sprintf("%04X", u))
+	}
+	return b.String()
+}
+
+func ttfChecksum(data []byte) uint32 {
+	var sum uint32
+	for i := 0; i < len(data); i += 4 {
+		var word [4]byte
+		copy(word[:], data[i:])
+		sum += binary.BigEndian.Uint32(word[:])
+	}
+	return sum
+}